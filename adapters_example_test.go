@@ -76,7 +76,7 @@ func ExampleCompareAndReplace() {
 	fmt.Println(err, string(val))
 
 	// Output:
-	// Key `key1` does not exist. hello world
+	// Key `key1` was changed since its token was read. hello world
 	// <nil> replacement2
 }
 
@@ -92,7 +92,7 @@ func ExampleReplace() {
 	fmt.Println(err)
 
 	// Output:
-	// Key `key1` does not exist.
+	// Key `key1` was not found.
 	// <nil>
 }
 
@@ -110,7 +110,7 @@ func ExampleGet() {
 	fmt.Println(string(value), token, err)
 
 	// Output:
-	// Key `non-existing` does not exist.
+	// Key `non-existing` was not found.
 	// Hello world! 86fb269d190d2c85f6e0468ceca42a20 <nil>
 }
 
@@ -194,7 +194,7 @@ func ExampleFlush() {
 
 	// Output:
 	// <nil> <nil>
-	// Key `key1` does not exist. Key `key2` does not exist.
+	// Key `key1` was not found. Key `key2` was not found.
 }
 
 func ExampleDelete() {
@@ -5,16 +5,39 @@
 package cacher_test
 
 import (
+	"bytes"
+	"context"
+	dbsql "database/sql"
 	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
 	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/garyburd/redigo/redis"
 	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/bitcask"
+	"github.com/jelmersnoeck/cacher/compress"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/expiry"
+	"github.com/jelmersnoeck/cacher/hybrid"
 	"github.com/jelmersnoeck/cacher/internal/encoding"
+	"github.com/jelmersnoeck/cacher/internal/sqltest"
 	"github.com/jelmersnoeck/cacher/internal/tests"
+	"github.com/jelmersnoeck/cacher/item"
 	"github.com/jelmersnoeck/cacher/memory"
+	"github.com/jelmersnoeck/cacher/namespace"
+	"github.com/jelmersnoeck/cacher/option"
 	rcache "github.com/jelmersnoeck/cacher/redis"
+	"github.com/jelmersnoeck/cacher/replicated"
+	"github.com/jelmersnoeck/cacher/sql"
+	"github.com/jelmersnoeck/cacher/stale"
+	"github.com/jelmersnoeck/cacher/tiered"
 )
 
 func TestAdd(t *testing.T) {
@@ -339,6 +362,733 @@ func TestTouch(t *testing.T) {
 	}
 }
 
+func TestContext(t *testing.T) {
+	for _, cache := range testDrivers() {
+		cc := cacher.WithContext(cache)
+
+		if err := cc.SetCtx(context.Background(), "key1", []byte("value1"), 0); err != nil {
+			tests.FailMsg(t, cache, "Expecting `key1` to be set with a background context.")
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := cc.SetCtx(ctx, "key1", []byte("value2"), 0); err != context.Canceled {
+			tests.FailMsg(t, cache, "Expecting a cancelled context to stop Set.")
+		}
+
+		if _, _, err := cc.GetCtx(ctx, "key1"); err != context.Canceled {
+			tests.FailMsg(t, cache, "Expecting a cancelled context to stop Get.")
+		}
+
+		if errs := cc.DeleteMultiCtx(ctx, []string{"key1"}); errs["key1"] != context.Canceled {
+			tests.FailMsg(t, cache, "Expecting a cancelled context to stop DeleteMulti.")
+		}
+
+		expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+		defer cancel()
+
+		if err := cc.DeleteCtx(expired, "key1"); err != context.DeadlineExceeded {
+			tests.FailMsg(t, cache, "Expecting a passed deadline to stop Delete.")
+		}
+
+		tests.Compare(t, cache, "key1", "value1")
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	// A server that reads every command and never replies, so every command
+	// blocks until its deadline.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go io.Copy(ioutil.Discard, conn)
+		}
+	}()
+
+	calls := map[string]func(*rcache.Cache, context.Context) error{
+		"Get": func(cache *rcache.Cache, ctx context.Context) error {
+			_, _, err := cache.GetCtx(ctx, "key1")
+			return err
+		},
+		"Set": func(cache *rcache.Cache, ctx context.Context) error {
+			return cache.SetCtx(ctx, "key1", []byte("value1"), 0)
+		},
+	}
+
+	for name, call := range calls {
+		c, err := redis.Dial("tcp", l.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		start := time.Now()
+		err = call(rcache.New(c), ctx)
+		cancel()
+		c.Close()
+
+		if err != context.DeadlineExceeded {
+			t.Errorf("%s: expecting the deadline to stop the blocked command, got %v.", name, err)
+		}
+
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: expecting the command to stop at the deadline, took %s.", name, elapsed)
+		}
+	}
+}
+
+func TestContextNative(t *testing.T) {
+	c, _ := redis.Dial("tcp", ":6379")
+
+	for _, cache := range []cacher.Cacher{memory.New(0), rcache.New(c)} {
+		if cacher.WithContext(cache) != cache {
+			tests.FailMsg(t, cache, "Expecting the cache to support contexts natively.")
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	for _, cache := range testDrivers() {
+		ec := cacher.WithExpiry(cache)
+
+		if err := ec.SetUntil("key1", []byte("value1"), expiry.In(time.Minute)); err != nil {
+			tests.FailMsg(t, cache, "Expecting `key1` to be set with an expiry.")
+		}
+
+		if err := ec.TouchUntil("key1", expiry.Never); err != nil {
+			tests.FailMsg(t, cache, "Expecting `key1` to be touched without an expiry.")
+		}
+		tests.Compare(t, cache, "key1", "value1")
+
+		ec.SetUntil("key1", []byte("value1"), expiry.At(time.Now().Add(-time.Second)))
+		tests.NotPresent(t, cache, "key1")
+	}
+}
+
+func TestExpiryMilliseconds(t *testing.T) {
+	c, _ := redis.Dial("tcp", ":6379")
+
+	for _, cache := range []cacher.Cacher{memory.New(0), rcache.New(c)} {
+		ec := cacher.WithExpiry(cache)
+		if ec != cache {
+			tests.FailMsg(t, cache, "Expecting the cache to support expiries natively.")
+		}
+
+		ec.SetUntil("key1", []byte("value1"), expiry.In(200*time.Millisecond))
+		ec.AddUntil("key2", []byte("value2"), expiry.In(time.Minute))
+		ec.TouchUntil("key2", expiry.In(200*time.Millisecond))
+		tests.Compare(t, cache, "key1", "value1")
+
+		time.Sleep(300 * time.Millisecond)
+		tests.NotPresent(t, cache, "key1")
+		tests.NotPresent(t, cache, "key2")
+	}
+}
+
+func TestGetItem(t *testing.T) {
+	c, _ := redis.Dial("tcp", ":6379")
+
+	for _, cache := range []cacher.Cacher{memory.New(0), rcache.New(c)} {
+		ig := cache.(cacher.ItemGetter)
+		ec := cacher.WithExpiry(cache)
+
+		if _, err := ig.GetItem("missing"); err == nil {
+			tests.FailMsg(t, cache, "Expecting no item for `missing`.")
+		}
+
+		if _, err := ig.TTL("missing"); err == nil {
+			tests.FailMsg(t, cache, "Expecting no TTL for `missing`.")
+		}
+
+		before := time.Now()
+		ec.SetUntil("key1", []byte("value1"), expiry.In(time.Minute))
+		ec.SetUntil("key2", []byte("value2"), expiry.Never)
+
+		it, err := ig.GetItem("key1")
+		if err != nil {
+			tests.FailMsg(t, cache, "Expecting an item for `key1`.")
+		}
+
+		_, token, _ := cache.Get("key1")
+		if string(it.Value) != "value1" || it.Token != token || it.Size != 6 {
+			tests.FailMsg(t, cache, "Expecting the item to hold the value of `key1`, got %+v.", it)
+		}
+
+		if it.TTL <= 59*time.Second || it.TTL > time.Minute || it.Expiry.Sub(before) < time.Minute-time.Second {
+			tests.FailMsg(t, cache, "Expecting `key1` to expire in a minute, got %s.", it.TTL)
+		}
+
+		if ttl, _ := ig.TTL("key2"); ttl != item.NoExpiry {
+			tests.FailMsg(t, cache, "Expecting `key2` not to expire, got %s.", ttl)
+		}
+
+		if it, _ := ig.GetItem("key2"); it.TTL != item.NoExpiry || !it.Expiry.IsZero() {
+			tests.FailMsg(t, cache, "Expecting the item of `key2` not to expire, got %+v.", it)
+		}
+	}
+}
+
+func TestGetItemTimestamps(t *testing.T) {
+	cache := memory.New(0)
+
+	cache.Set("key1", []byte("value1"), 0)
+	written := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	cache.Get("key1")
+	read := time.Now()
+
+	it, _ := cache.GetItem("key1")
+	if it.Created.After(written) || it.Accessed.Before(written) || it.Accessed.After(read) {
+		tests.FailMsg(t, cache, "Expecting `key1` to be created at %s and accessed at %s, got %+v.", written, read, it)
+	}
+
+	if again, _ := cache.GetItem("key1"); !again.Accessed.After(read) {
+		tests.FailMsg(t, cache, "Expecting GetItem to update the access time.")
+	}
+}
+
+func TestFlags(t *testing.T) {
+	for _, cache := range testDrivers() {
+		fc := cacher.WithFlags(cache)
+
+		fc.AddWithFlags("key1", []byte("value1"), 1, 0)
+		fc.SetWithFlags("key2", []byte("value2"), 2, 0)
+		fc.Set("key3", []byte("value3"), 0)
+
+		items, _, flags, errs := fc.GetMultiWithFlags([]string{"key1", "key2", "key3", "key4"})
+		if string(items["key1"]) != "value1" || flags["key1"] != 1 || flags["key2"] != 2 || flags["key3"] != 0 {
+			tests.FailMsg(t, cache, "Expecting the flags to be stored, got %v.", flags)
+		}
+
+		if errs["key1"] != nil || errs["key4"] == nil {
+			tests.FailMsg(t, cache, "Expecting only `key4` to be missing, got %v.", errs)
+		}
+
+		fc.ReplaceWithFlags("key1", []byte("value1"), 3, 0)
+		_, token, f, _ := fc.GetWithFlags("key1")
+		if f != 3 {
+			tests.FailMsg(t, cache, "Expecting Replace to update the flags, got %d.", f)
+		}
+
+		fc.CompareAndReplaceWithFlags(token, "key1", []byte("value5"), 5, 0)
+		if v, _, f, _ := fc.GetWithFlags("key1"); string(v) != "value5" || f != 5 {
+			tests.FailMsg(t, cache, "Expecting CompareAndReplace to update the flags, got `%s` and %d.", v, f)
+		}
+
+		fc.Set("key1", []byte("value1"), 0)
+		if v, _, f, _ := fc.GetWithFlags("key1"); string(v) != "value1" || f != 0 {
+			tests.FailMsg(t, cache, "Expecting Set to clear the flags, got `%s` and %d.", v, f)
+		}
+
+		header := []byte{0xca, 0xf1, 0, 0, 0, 1, 'x'}
+		fc.Set("key5", header, 0)
+		if v, _, f, _ := fc.GetWithFlags("key5"); !reflect.DeepEqual(v, header) || f != 0 {
+			tests.FailMsg(t, cache, "Expecting values that look like a header to be kept, got %v and %d.", v, f)
+		}
+	}
+}
+
+func TestFlagsNative(t *testing.T) {
+	c, _ := redis.Dial("tcp", ":6379")
+
+	for _, cache := range []cacher.Cacher{memory.New(0), rcache.New(c)} {
+		fc := cacher.WithFlags(cache)
+		if fc != cache {
+			tests.FailMsg(t, cache, "Expecting the cache to support flags natively.")
+		}
+
+		fc.SetWithFlags("key1", []byte("value1"), 7, 0)
+		tests.Compare(t, cache, "key1", "value1")
+
+		if it, _ := cache.(cacher.ItemGetter).GetItem("key1"); it.Flags != 7 {
+			tests.FailMsg(t, cache, "Expecting the item to hold the flags, got %d.", it.Flags)
+		}
+
+		cache.Delete("key1")
+		cache.Set("key1", []byte("value1"), 0)
+		if _, _, f, _ := fc.GetWithFlags("key1"); f != 0 {
+			tests.FailMsg(t, cache, "Expecting Delete to remove the flags, got %d.", f)
+		}
+	}
+}
+
+func TestSetWith(t *testing.T) {
+	for _, cache := range testDrivers() {
+		oc := cacher.WithOptions(cache)
+
+		if err := oc.SetWith("key1", []byte("value1"), option.OnlyIfPresent()); err == nil {
+			tests.FailMsg(t, cache, "Expecting OnlyIfPresent to fail on a missing key.")
+		}
+
+		if err := oc.SetWith("key1", []byte("value1"), option.OnlyIfAbsent(), option.WithFlags(4)); err != nil {
+			tests.FailMsg(t, cache, "Expecting OnlyIfAbsent to add `key1`.")
+		}
+
+		if err := oc.SetWith("key1", []byte("value2"), option.OnlyIfAbsent()); err == nil {
+			tests.FailMsg(t, cache, "Expecting OnlyIfAbsent to fail on an existing key.")
+		}
+
+		if _, _, f, _ := cacher.WithFlags(cache).GetWithFlags("key1"); f != 4 {
+			tests.FailMsg(t, cache, "Expecting WithFlags to store the flags, got %d.", f)
+		}
+
+		if v, _, _ := oc.Get("key1"); string(v) != "value1" {
+			tests.FailMsg(t, cache, "Expecting Get to return the value without its flags, got %q.", v)
+		}
+
+		_, token, _ := oc.Get("key1")
+		if err := oc.SetWith("key1", []byte("value3"), option.IfToken(token+"WRONG")); err == nil {
+			tests.FailMsg(t, cache, "Expecting IfToken to fail on the wrong token.")
+		}
+
+		if err := oc.SetWith("key1", []byte("value3"), option.IfToken(token), option.OnlyIfPresent()); err != nil {
+			tests.FailMsg(t, cache, "Expecting IfToken to replace `key1`.")
+		}
+		tests.Compare(t, cache, "key1", "value3")
+
+		if err := oc.SetWith("key1", []byte("value4"), option.OnlyIfAbsent(), option.IfToken(token)); err == nil {
+			tests.FailMsg(t, cache, "Expecting OnlyIfAbsent and IfToken to be rejected.")
+		}
+
+		oc.SetWith("key2", []byte("value2"), option.WithTTL(-time.Second))
+		tests.NotPresent(t, cache, "key2")
+	}
+}
+
+func TestSetWithKeepTTL(t *testing.T) {
+	c, _ := redis.Dial("tcp", ":6379")
+	dir, _ := ioutil.TempDir("", "cacher-bitcask")
+	bitcaskCache, _ := bitcask.Open(dir, bitcask.Options{MergeInterval: -1})
+	db, _ := dbsql.Open(sqltest.DriverName, "keepttl")
+	sqlCache := sql.New(db, sql.Options{PurgeInterval: -1})
+	sqlCache.Flush()
+
+	for _, cache := range []cacher.Cacher{memory.New(0), rcache.New(c)} {
+		if cacher.WithOptions(cache) != cache {
+			tests.FailMsg(t, cache, "Expecting the cache to support options natively.")
+		}
+	}
+
+	for _, cache := range []cacher.Cacher{memory.New(0), rcache.New(c), bitcaskCache, sqlCache} {
+		oc := cacher.WithOptions(cache)
+
+		oc.SetWith("key1", []byte("value1"), option.WithTTL(time.Minute), option.WithTags("tag1"))
+		oc.SetWith("key1", []byte("value2"), option.KeepTTL(), option.OnlyIfPresent())
+
+		ttl, _ := cache.(cacher.ItemGetter).TTL("key1")
+		if ttl <= 59*time.Second || ttl > time.Minute {
+			tests.FailMsg(t, cache, "Expecting KeepTTL to keep the expiry of `key1`, got %s.", ttl)
+		}
+		tests.Compare(t, cache, "key1", "value2")
+
+		oc.SetWith("key2", []byte("value2"), option.KeepTTL())
+		if ttl, _ := cache.(cacher.ItemGetter).TTL("key2"); ttl != item.NoExpiry {
+			tests.FailMsg(t, cache, "Expecting KeepTTL not to expire a new key, got %s.", ttl)
+		}
+
+		_, token, _ := oc.Get("key1")
+		if err := oc.SetWith("key1", []byte("value3"), option.KeepTTL(), option.IfToken(token+"WRONG")); !errors.Is(err, errors.ErrCASConflict) {
+			tests.FailMsg(t, cache, "Expecting KeepTTL with the wrong token to conflict, got %v.", err)
+		}
+
+		if err := oc.SetWith("key3", []byte("value3"), option.KeepTTL(), option.OnlyIfPresent()); !errors.Is(err, errors.ErrNotFound) {
+			tests.FailMsg(t, cache, "Expecting KeepTTL and OnlyIfPresent to miss `key3`, got %v.", err)
+		}
+	}
+}
+
+func TestSetWithTags(t *testing.T) {
+	for _, cache := range testDrivers() {
+		oc := cacher.WithOptions(cache)
+		tc := cacher.WithTags(cache)
+
+		if err := oc.SetWith("key1", []byte("value1"), option.WithTags("tag1")); err != nil {
+			tests.FailMsg(t, cache, "Expecting `key1` to be tagged, got %v.", err)
+		}
+
+		if value, _, err := tc.Get("key1"); err != nil || string(value) != "value1" {
+			tests.FailMsg(t, cache, "Expecting `key1` to be value1, got `%s` %v.", value, err)
+		}
+
+		tc.InvalidateTags("tag1")
+		if _, _, err := tc.Get("key1"); !errors.Is(err, errors.ErrNotFound) {
+			tests.FailMsg(t, cache, "Expecting `key1` to miss, got %v.", err)
+		}
+	}
+}
+
+func TestSetWithUnsupported(t *testing.T) {
+	// The struct hides that the memory cache is an ItemGetter.
+	oc := cacher.WithOptions(struct{ cacher.Cacher }{memory.New(0)})
+
+	if err := oc.SetWith("key1", []byte("value1"), option.KeepTTL()); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Expecting KeepTTL to be unsupported, got %v.", err)
+	}
+}
+
+func TestTags(t *testing.T) {
+	for _, cache := range testDrivers() {
+		tc := cacher.WithTags(cache)
+
+		tc.SetWith("page:1", []byte("value1"), option.WithTags("product:42", "product:7"))
+		tc.SetWith("page:2", []byte("value2"), option.WithTags("product:7"))
+		tc.SetWith("page:3", []byte("value3"), option.WithTags("product:8"))
+		tc.Set("page:4", []byte("value4"), 0)
+
+		if value, _, err := tc.Get("page:1"); err != nil || string(value) != "value1" {
+			tests.FailMsg(t, cache, "Expecting `page:1` to be value1, got `%s` %v.", value, err)
+		}
+
+		if err := tc.InvalidateTags("product:42"); err != nil {
+			tests.FailMsg(t, cache, "Expecting the tag to be invalidated, got %v.", err)
+		}
+
+		if _, _, err := tc.Get("page:1"); !errors.Is(err, errors.ErrNotFound) {
+			tests.FailMsg(t, cache, "Expecting `page:1` to miss, got %v.", err)
+		}
+
+		items, _, errs := tc.GetMulti([]string{"page:2", "page:3", "page:4"})
+		for key, value := range map[string]string{"page:2": "value2", "page:3": "value3", "page:4": "value4"} {
+			if errs[key] != nil || string(items[key]) != value {
+				tests.FailMsg(t, cache, "Expecting `%s` to be kept, got `%s` %v.", key, items[key], errs[key])
+			}
+		}
+
+		// Rewriting an item tags it with the current version again.
+		tc.InvalidateTags("product:7", "product:8")
+		tc.SetWith("page:2", []byte("value5"), option.WithTags("product:7"))
+
+		items, _, errs = tc.GetMulti([]string{"page:2", "page:3"})
+		if string(items["page:2"]) != "value5" || !errors.Is(errs["page:3"], errors.ErrNotFound) {
+			tests.FailMsg(t, cache, "Expecting only `page:2` to be found, got %v %v.", items, errs)
+		}
+
+		tc.SetWith("page:5", []byte("value6"), option.WithTags("product:9"), option.WithFlags(3))
+		if value, _, f, err := cacher.WithFlags(tc).GetWithFlags("page:5"); err != nil || string(value) != "value6" || f != 3 {
+			tests.FailMsg(t, cache, "Expecting `page:5` with flags 3, got `%s` %d %v.", value, f, err)
+		}
+	}
+}
+
+func TestTagsNative(t *testing.T) {
+	c, _ := redis.Dial("tcp", ":6379")
+
+	for _, cache := range []cacher.Cacher{memory.New(0), rcache.New(c)} {
+		tc := cacher.WithTags(cache)
+		if tc != cache {
+			tests.FailMsg(t, cache, "Expecting the cache to support tags natively.")
+		}
+
+		tc.SetWith("page:1", []byte("value1"), option.WithTags("product:42"), option.WithFlags(3))
+		tc.SetWith("page:2", []byte("value2"), option.WithTags("product:7"))
+		tc.SetWith("page:3", []byte("value3"), option.WithTags("product:42"))
+		tc.Set("page:3", []byte("value4"), 0)
+		tc.InvalidateTags("product:42")
+
+		tests.NotPresent(t, cache, "page:1")
+		tests.Compare(t, cache, "page:2", "value2")
+		tests.Compare(t, cache, "page:3", "value4")
+
+		if _, _, f, _ := cacher.WithFlags(cache).GetWithFlags("page:1"); f != 0 {
+			tests.FailMsg(t, cache, "Expecting the flags to be invalidated with the item, got %d.", f)
+		}
+	}
+
+	// The set of a tag expires with the last of its keys.
+	cache := rcache.New(c)
+	cache.SetWith("page:4", []byte("value4"), option.WithTags("product:5"), option.WithTTL(time.Minute))
+	cache.SetWith("page:5", []byte("value5"), option.WithTags("product:5"), option.WithTTL(time.Second))
+	if ms, err := redis.Int64(c.Do("PTTL", "\x00tag:product:5")); err != nil || ms <= 1000 || ms > 60000 {
+		t.Errorf("Expecting the tag set to expire in a minute, got %d %v.", ms, err)
+	}
+
+	cache.Delete("page:4")
+	cache.Delete("page:5")
+	if n, err := redis.Int64(c.Do("EXISTS", "\x00tag:product:5")); err != nil || n != 0 {
+		t.Errorf("Expecting deleted keys to leave the tag set, got %d %v.", n, err)
+	}
+
+	// The scripts are loaded again when the server forgot them.
+	c.Do("SCRIPT", "FLUSH")
+	cache.SetWith("page:6", []byte("value6"), option.WithTags("product:6"))
+	cache.Touch("page:6", 60)
+	cache.InvalidateTags("product:6")
+	tests.NotPresent(t, cache, "page:6")
+}
+
+func TestCapabilities(t *testing.T) {
+	c, _ := redis.Dial("tcp", ":6379")
+	dir, _ := ioutil.TempDir("", "cacher-bitcask")
+	bitcaskCache, _ := bitcask.Open(dir, bitcask.Options{MergeInterval: -1})
+	db, _ := dbsql.Open(sqltest.DriverName, "adapters")
+	spillDir, _ := ioutil.TempDir("", "cacher-hybrid")
+	spill, _ := bitcask.Open(spillDir, bitcask.Options{MergeInterval: -1})
+	hybridCache, _ := hybrid.New(memory.New(32), spill, 1<<20)
+	defer hybridCache.Close()
+
+	cases := []struct {
+		cache cacher.Cacher
+		caps  cacher.Capability
+	}{
+		{memory.New(0), cacher.CanScan | cacher.CanStat | cacher.CanClose | cacher.CanPing | cacher.CanLock | cacher.CanSnapshot},
+		{rcache.New(c), cacher.CanScan | cacher.CanStat | cacher.CanClose | cacher.CanPing | cacher.CanLock},
+		{bitcaskCache, cacher.CanScan | cacher.CanStat | cacher.CanClose | cacher.CanPing},
+		{sql.New(db, sql.Options{PurgeInterval: -1}), cacher.CanClose | cacher.CanPing},
+		{hybridCache, cacher.CanScan | cacher.CanStat | cacher.CanClose | cacher.CanPing | cacher.CanLock},
+		{replicated.New(0, memory.New(0), memory.New(0)), cacher.CanScan | cacher.CanStat | cacher.CanClose | cacher.CanPing | cacher.CanLock},
+		{tiered.New(memory.New(0), sql.New(db, sql.Options{PurgeInterval: -1}), 0), cacher.CanClose | cacher.CanPing},
+		{stale.New(rcache.New(c), stale.Options{}), cacher.CanScan | cacher.CanStat | cacher.CanClose | cacher.CanPing | cacher.CanLock},
+	}
+
+	for _, tc := range cases {
+		if caps := cacher.Capabilities(tc.cache); caps != tc.caps {
+			tests.FailMsg(t, tc.cache, "Expecting capabilities %s, got %s.", tc.caps, caps)
+		}
+
+		// The adapters forward what the wrapped cache can do.
+		wrapped := cacher.WithFlags(tc.cache)
+		if caps := cacher.Capabilities(wrapped); wrapped != tc.cache && caps != tc.caps {
+			tests.FailMsg(t, tc.cache, "Expecting the adapter to forward %s, got %s.", tc.caps, caps)
+		}
+	}
+
+	bc := cacher.WithContext(bitcaskCache)
+	if _, err := bc.(cacher.Stater).Stats(); err != nil {
+		t.Errorf("Expecting Stats to be forwarded to bitcask, got %s.", err)
+	}
+
+	if err := bc.(cacher.Closer).Close(); err != nil {
+		t.Errorf("Expecting Close to be forwarded to bitcask, got %s.", err)
+	}
+
+	sc := cacher.WithContext(sql.New(db, sql.Options{PurgeInterval: -1}))
+	if _, err := sc.(cacher.Stater).Stats(); err == nil {
+		t.Errorf("Expecting Stats to be unsupported for sql.")
+	}
+
+	if s := (cacher.CanScan | cacher.CanLock).String(); s != "Scan|Lock" {
+		t.Errorf("Expecting `Scan|Lock`, got `%s`.", s)
+	}
+}
+
+func TestScan(t *testing.T) {
+	c, _ := redis.Dial("tcp", ":6379")
+	dir, _ := ioutil.TempDir("", "cacher-bitcask")
+	bitcaskCache, _ := bitcask.Open(dir, bitcask.Options{MergeInterval: -1})
+	defer bitcaskCache.Close()
+
+	for _, cache := range []cacher.Cacher{memory.New(0), rcache.New(c), bitcaskCache} {
+		cache.Flush()
+		fc := cacher.WithFlags(cache)
+		fc.SetWithFlags("user:1", []byte("value1"), 1, 0)
+		cache.Set("user:2", []byte("value2"), 0)
+		cache.Set("group:1", []byte("value3"), 0)
+		cache.Set("user:3", []byte("value4"), -1)
+
+		var keys []string
+		cache.(cacher.Scanner).Scan("user:", func(key string) bool {
+			keys = append(keys, key)
+			return true
+		})
+
+		sort.Strings(keys)
+		if !reflect.DeepEqual(keys, []string{"user:1", "user:2"}) {
+			tests.FailMsg(t, cache, "Expecting to scan `user:1` and `user:2`, got %v.", keys)
+		}
+
+		var n int
+		cache.(cacher.Scanner).Scan("", func(key string) bool {
+			n++
+			return false
+		})
+		if n != 1 {
+			tests.FailMsg(t, cache, "Expecting the scan to stop after one key, got %d.", n)
+		}
+
+		s, _ := cache.(cacher.Stater).Stats()
+		if s.Items != 3 {
+			tests.FailMsg(t, cache, "Expecting 3 items, got %d.", s.Items)
+		}
+	}
+}
+
+func TestLocker(t *testing.T) {
+	c, _ := redis.Dial("tcp", ":6379")
+
+	for _, cache := range []cacher.Cacher{memory.New(0), rcache.New(c)} {
+		cache.Flush()
+		l := cache.(cacher.Locker)
+
+		token, ok, err := l.TryLock("key1", time.Minute)
+		if !ok || err != nil {
+			tests.FailMsg(t, cache, "Expecting to take the lock `key1`.")
+		}
+
+		if _, ok, _ := l.TryLock("key1", time.Minute); ok {
+			tests.FailMsg(t, cache, "Expecting `key1` to be locked already.")
+		}
+
+		if err := l.Unlock("key1", "other"); err == nil {
+			tests.FailMsg(t, cache, "Expecting a different token not to unlock `key1`.")
+		}
+
+		if err := l.Unlock("key1", token); err != nil {
+			tests.FailMsg(t, cache, "Expecting to unlock `key1`.")
+		}
+
+		if _, _, err := cache.Get("key1"); err == nil {
+			tests.FailMsg(t, cache, "Expecting the lock not to be an item.")
+		}
+
+		l.TryLock("key2", 10*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		if _, ok, _ := l.TryLock("key2", time.Minute); !ok {
+			tests.FailMsg(t, cache, "Expecting the lock `key2` to have expired.")
+		}
+	}
+
+	// The locks of a memory cache can be shared between goroutines.
+	cache := memory.New(0)
+	var wg sync.WaitGroup
+	var taken int32
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, ok, _ := cache.TryLock("key1", time.Minute); ok {
+				atomic.AddInt32(&taken, 1)
+			}
+			cache.Get("key1")
+		}()
+	}
+	wg.Wait()
+
+	if taken != 1 {
+		t.Errorf("Expecting `key1` to be taken once, got %d.", taken)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	cache := memory.New(0)
+	cache.SetWithFlags("key1", []byte("value1"), 7, 0)
+	cache.SetUntil("key2", []byte("value2"), expiry.In(time.Minute))
+	cache.SetUntil("key3", []byte("value3"), expiry.In(10*time.Millisecond))
+
+	var buf bytes.Buffer
+	if err := cache.Snapshot(&buf); err != nil {
+		t.Fatalf("Expecting a snapshot, got %s.", err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	restored := memory.New(0)
+	restored.Set("key4", []byte("value4"), 0)
+	if err := restored.Restore(&buf); err != nil {
+		t.Fatalf("Expecting the snapshot to be restored, got %s.", err)
+	}
+
+	tests.Compare(t, restored, "key1", "value1")
+	tests.Compare(t, restored, "key2", "value2")
+	tests.NotPresent(t, restored, "key3")
+	tests.NotPresent(t, restored, "key4")
+
+	if _, _, flags, _ := restored.GetWithFlags("key1"); flags != 7 {
+		t.Errorf("Expecting the flags of `key1` to be restored, got %d.", flags)
+	}
+
+	if ttl, _ := restored.TTL("key2"); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("Expecting the expiry of `key2` to be restored, got %s.", ttl)
+	}
+}
+
+func TestPing(t *testing.T) {
+	for _, cache := range testDrivers() {
+		if err := cacher.Ping(context.Background(), cache); err != nil {
+			tests.FailMsg(t, cache, "Expecting the cache to be reachable, got %s.", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		if err := cacher.Ping(ctx, cache); err == nil {
+			tests.FailMsg(t, cache, "Expecting a cancelled context to fail the ping.")
+		}
+	}
+}
+
+func TestClose(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cacher-bitcask")
+	bitcaskCache, _ := bitcask.Open(dir, bitcask.Options{MergeInterval: -1})
+
+	spillDir, _ := ioutil.TempDir("", "cacher-hybrid")
+	spill, _ := bitcask.Open(spillDir, bitcask.Options{MergeInterval: -1})
+	hybridCache, _ := hybrid.New(memory.New(32), spill, 1<<20)
+
+	c, _ := redis.Dial("tcp", ":6379")
+
+	for _, cache := range []cacher.Cacher{bitcaskCache, hybridCache, rcache.New(c)} {
+		if err := cacher.Close(cache); err != nil {
+			tests.FailMsg(t, cache, "Expecting the cache to close, got %s.", err)
+		}
+
+		if err := cacher.Ping(context.Background(), cache); err == nil {
+			tests.FailMsg(t, cache, "Expecting a closed cache to fail the ping.")
+		}
+	}
+}
+
+func TestErrorCategories(t *testing.T) {
+	for _, cache := range testDrivers() {
+		cache.Set("key1", []byte("value1"), 0)
+		cache.Set("text", []byte("hello"), 0)
+		cache.Increment("counter", 1, 1, 0)
+		_, token, _ := cache.Get("key1")
+
+		_, _, err := cache.Get("missing")
+		var nf errors.NotFound
+		if !errors.As(err, &nf) || nf.Key != "missing" {
+			tests.FailMsg(t, cache, "Expecting a NotFound for `missing`, got %v.", err)
+		}
+
+		_, _, errs := cache.GetMulti([]string{"missing"})
+		cases := []struct {
+			op     string
+			err    error
+			target error
+		}{
+			{"Get", err, errors.ErrNotFound},
+			{"GetMulti", errs["missing"], errors.ErrNotFound},
+			{"Add", cache.Add("key1", []byte("value2"), 0), errors.ErrExists},
+			{"Replace", cache.Replace("missing", []byte("value2"), 0), errors.ErrNotFound},
+			{"CompareAndReplace", cache.CompareAndReplace(token+"x", "key1", []byte("value2"), 0), errors.ErrCASConflict},
+			{"CompareAndReplace missing", cache.CompareAndReplace(token, "missing", []byte("value2"), 0), errors.ErrNotFound},
+			{"Increment", cache.Increment("text", 0, 1, 0), errors.ErrNotNumeric},
+			{"Increment range", cache.Increment("counter", 0, 0, 0), errors.ErrInvalidRange},
+			{"Decrement", cache.Decrement("counter", 0, 5, 0), errors.ErrUnderflow},
+			{"Delete", cache.Delete("missing"), errors.ErrNotFound},
+			{"Touch", cache.Touch("missing", 10), errors.ErrNotFound},
+		}
+
+		for _, tc := range cases {
+			if !errors.Is(tc.err, tc.target) {
+				tests.FailMsg(t, cache, "%s: expecting `%v`, got `%v`.", tc.op, tc.target, tc.err)
+			}
+		}
+
+		tests.Compare(t, cache, "key1", "value1")
+	}
+}
+
 func testDrivers() []cacher.Cacher {
 	var drivers []cacher.Cacher
 
@@ -351,5 +1101,35 @@ func testDrivers() []cacher.Cacher {
 	redisCache.Flush()
 	drivers = append(drivers, redisCache)
 
+	dir, _ := ioutil.TempDir("", "cacher-bitcask")
+	bitcaskCache, _ := bitcask.Open(dir, bitcask.Options{MergeInterval: -1})
+	drivers = append(drivers, bitcaskCache)
+
+	db, _ := dbsql.Open(sqltest.DriverName, "adapters")
+	sqlCache := sql.New(db, sql.Options{PurgeInterval: -1})
+	sqlCache.Flush()
+	drivers = append(drivers, sqlCache)
+
+	tieredCache := tiered.New(memory.New(0), memory.New(0), 60)
+	drivers = append(drivers, tieredCache)
+
+	// A small memory tier makes the tests move items between the tiers.
+	spillDir, _ := ioutil.TempDir("", "cacher-hybrid")
+	spill, _ := bitcask.Open(spillDir, bitcask.Options{MergeInterval: -1})
+	hybridCache, _ := hybrid.New(memory.New(32), spill, 1<<20)
+	drivers = append(drivers, hybridCache)
+
+	replicatedCache := replicated.New(0, memory.New(0), memory.New(0))
+	drivers = append(drivers, replicatedCache)
+
+	compressCache := compress.New(memory.New(0), compress.Options{Threshold: 1})
+	drivers = append(drivers, compressCache)
+
+	namespaceCache := namespace.New(memory.New(0), "test")
+	drivers = append(drivers, namespaceCache)
+
+	staleCache := stale.New(memory.New(0), stale.Options{StaleTTL: time.Minute})
+	drivers = append(drivers, staleCache)
+
 	return drivers
 }
@@ -0,0 +1,867 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package bitcask provides a persistent cache that stores its data on local
+// disk in a log-structured fashion, modelled after Bitcask.
+//
+// Every write is appended to the active data file and the position of the
+// latest record for each key is kept in an in-memory key directory, so reads
+// take a single disk seek. When the active file grows over its size limit a new
+// one is started. Overwritten, deleted and expired records are reclaimed by
+// merging all data files into a compacted set, for which hint files are written
+// so the key directory can be rebuilt without reading the values on startup.
+package bitcask
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/internal/encoding"
+)
+
+const (
+	dataExt = ".data"
+	hintExt = ".hint"
+)
+
+// Options configures a Cache. The zero value of every field selects a sensible
+// default.
+type Options struct {
+	// MaxFileSize is the size in bytes after which the active data file is
+	// closed and a new one is started. Defaults to 64MB.
+	MaxFileSize int64
+
+	// MergeInterval is how often the background process checks whether the
+	// data files should be merged. Defaults to one minute, a negative value
+	// disables background merging.
+	MergeInterval time.Duration
+
+	// MergeRatio is the fraction of dead bytes, from overwritten, deleted or
+	// expired records, that triggers a background merge. Defaults to 0.5.
+	MergeRatio float64
+
+	// SyncWrites makes every write call fsync on the active data file.
+	SyncWrites bool
+}
+
+// entry is the key directory's pointer to the latest record of a key.
+type entry struct {
+	fileID    int
+	offset    int64
+	valueSize uint32
+	expiry    int64
+}
+
+func (e *entry) expired(now int64) bool {
+	return e.expiry != 0 && now >= e.expiry
+}
+
+type dataFile struct {
+	id   int
+	file *os.File
+	size int64
+}
+
+// Cache is a caching implementation that stores the data in append-only files
+// in a directory on disk. The data will persist between runs of the
+// application.
+type Cache struct {
+	mu        sync.Mutex
+	dir       string
+	opts      Options
+	keydir    map[string]*entry
+	files     map[int]*dataFile
+	active    *dataFile
+	liveBytes int64
+	diskBytes int64
+	done      chan struct{}
+	closed    bool
+}
+
+// Open opens the cache stored in dir, creating the directory if it doesn't
+// exist yet. The key directory is rebuilt from the hint files and data files
+// present. A record at the end of the last data file that fails its checksum,
+// which happens when the application stopped halfway through a write, is
+// discarded; corruption anywhere else is reported as an error.
+func Open(dir string, opts Options) (*Cache, error) {
+	if opts.MaxFileSize <= 0 {
+		opts.MaxFileSize = 64 << 20
+	}
+	if opts.MergeInterval == 0 {
+		opts.MergeInterval = time.Minute
+	}
+	if opts.MergeRatio <= 0 {
+		opts.MergeRatio = 0.5
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	cache := new(Cache)
+	cache.dir = dir
+	cache.opts = opts
+	cache.keydir = make(map[string]*entry)
+	cache.files = make(map[int]*dataFile)
+
+	if err := cache.load(); err != nil {
+		cache.closeFiles()
+		return nil, err
+	}
+
+	if opts.MergeInterval > 0 {
+		cache.done = make(chan struct{})
+		go cache.mergeLoop()
+	}
+
+	return cache, nil
+}
+
+// Add an item to the cache. If the item is already cached, the value won't be
+// overwritten.
+//
+// See the `Set()` function for ttl information.
+func (c *Cache) Add(key string, value []byte, ttl int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.get(key); err == nil {
+		return errors.NewAlreadyExistingKey(key)
+	}
+
+	return c.set(key, value, ttl)
+}
+
+// Set sets the value of an item, regardless of wether or not the value is
+// already cached.
+//
+// ttl defines the number of seconds the value should be cached. If ttl is 0,
+// the item will be cached infinitely. If ttl is < 0, the value will be deleted
+// from the cache using the `Delete()` function.
+func (c *Cache) Set(key string, value []byte, ttl int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.set(key, value, ttl)
+}
+
+// SetMulti sets multiple values for their respective keys. This is a shorthand
+// to use `Set` multiple times.
+func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	results := make(map[string]error)
+	for key, value := range items {
+		results[key] = c.Set(key, value, ttl)
+	}
+
+	return results
+}
+
+// CompareAndReplace validates the token with the token in the store. If the
+// tokens match, we will replace the value and return true. If it doesn't, we
+// will not replace the value and return false.
+func (c *Cache) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, err := c.get(key)
+	if err != nil {
+		return err
+	}
+
+	if encoding.Md5Sum(current) != token {
+		return errors.NewCASConflict(key)
+	}
+
+	return c.set(key, value, ttl)
+}
+
+// Replace will update and only update the value of a cache key. If the key is
+// not previously used, we will return false.
+func (c *Cache) Replace(key string, value []byte, ttl int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, err := c.get(key); err != nil {
+		return err
+	}
+
+	return c.set(key, value, ttl)
+}
+
+// Get gets the value out of the data files associated with the provided key.
+func (c *Cache) Get(key string) ([]byte, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, err := c.get(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return value, encoding.Md5Sum(value), nil
+}
+
+// GetMulti gets multiple values from the cache and returns them as a map. It
+// uses `Get` internally to retrieve the data.
+func (c *Cache) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	items := make(map[string][]byte)
+	errs := make(map[string]error)
+	tokens := make(map[string]string)
+
+	for _, k := range keys {
+		items[k], tokens[k], errs[k] = c.Get(k)
+	}
+
+	return items, tokens, errs
+}
+
+// Increment adds a value of offset to the initial value. If the initial value
+// is already set, it will be added to the value currently stored in the cache.
+//
+// Initial value and offset can't be below 0.
+func (c *Cache) Increment(key string, initial, offset, ttl int64) error {
+	if initial < 0 || offset <= 0 {
+		return errors.NewInvalidRange(initial, offset)
+	}
+
+	return c.incrementOffset(key, initial, offset, ttl)
+}
+
+// Decrement subtracts a value of offset to the initial value. If the initial
+// value is already set, it will be added to the value currently stored in the
+// cache.
+//
+// Initial value and offset can't be below 0.
+func (c *Cache) Decrement(key string, initial, offset, ttl int64) error {
+	if initial < 0 || offset <= 0 {
+		return errors.NewInvalidRange(initial, offset)
+	}
+
+	return c.incrementOffset(key, initial, offset*-1, ttl)
+}
+
+// Flush will remove all the data files and start over with an empty one.
+func (c *Cache) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return errClosed
+	}
+
+	// The new data file is opened first, so the cache is left as it was when
+	// that fails.
+	old := c.fileIDs()
+	active, err := c.openFile(c.active.id + 1)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range old {
+		c.files[id].file.Close()
+		delete(c.files, id)
+	}
+
+	c.keydir = make(map[string]*entry)
+	c.active = active
+	c.liveBytes = 0
+	c.diskBytes = 0
+
+	for _, id := range old {
+		if err := c.removeFile(id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete will validate if the key actually is stored in the cache. If it is
+// stored, it will write a tombstone for the key so it stays removed after a
+// restart. If it is not stored, it will return false.
+func (c *Cache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.delete(key)
+}
+
+// DeleteMulti will delete multiple values at a time. It uses the `Delete`
+// method internally to do so. It will return a map of results to see if the
+// deletion is successful.
+func (c *Cache) DeleteMulti(keys []string) map[string]error {
+	results := make(map[string]error)
+
+	for _, key := range keys {
+		results[key] = c.Delete(key)
+	}
+
+	return results
+}
+
+// Touch will update the key's ttl to the given ttl value without altering the
+// value. As records are immutable, the value is written again with the new
+// expiry.
+func (c *Cache) Touch(key string, ttl int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, err := c.get(key)
+	if err != nil {
+		return err
+	}
+
+	return c.set(key, value, ttl)
+}
+
+// Merge rewrites all live records into a new set of data files, each with a
+// hint file, and removes the old files. Overwritten, deleted and expired
+// records are dropped in the process. Merge is called periodically in the
+// background, see `Options`, but can be called manually as well.
+func (c *Cache) Merge() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.merge()
+}
+
+// Close stops the background merge process and closes all data files. The
+// cache can't be used after it has been closed.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+
+	if c.done != nil {
+		close(c.done)
+	}
+
+	if err := c.active.file.Sync(); err != nil {
+		c.closeFiles()
+		return err
+	}
+
+	return c.closeFiles()
+}
+
+// get returns the current value for key. Expired keys are dropped from the key
+// directory; they'll be skipped on load and left out of the next merge.
+func (c *Cache) get(key string) ([]byte, error) {
+	if c.closed {
+		return nil, errClosed
+	}
+
+	e, ok := c.keydir[key]
+	if !ok {
+		return nil, errors.NewNotFound(key)
+	}
+
+	if e.expired(time.Now().UnixNano()) {
+		c.forget(key)
+		return nil, errors.NewNotFound(key)
+	}
+
+	df := c.files[e.fileID]
+	rec, err := readRecord(df.file, e.offset, df.size)
+	if err != nil {
+		return nil, err
+	}
+
+	return rec.value, nil
+}
+
+// set appends a new record for key and points the key directory to it.
+func (c *Cache) set(key string, value []byte, ttl int64) error {
+	if ttl < 0 {
+		return c.delete(key)
+	}
+
+	var expiry int64
+	if ttl > 0 {
+		expiry = time.Now().Add(time.Duration(ttl) * time.Second).UnixNano()
+	}
+
+	e, err := c.append(record{expiry: expiry, key: key, value: value})
+	if err != nil {
+		return err
+	}
+
+	c.forget(key)
+	c.keydir[key] = e
+	c.liveBytes += recordSize(key, e)
+
+	return nil
+}
+
+// delete appends a tombstone for key and removes it from the key directory.
+func (c *Cache) delete(key string) error {
+	e, ok := c.keydir[key]
+	if !ok || e.expired(time.Now().UnixNano()) {
+		c.forget(key)
+		return errors.NewNotFound(key)
+	}
+
+	if _, err := c.append(record{tombstone: true, key: key}); err != nil {
+		return err
+	}
+
+	c.forget(key)
+	return nil
+}
+
+// forget removes key from the key directory without writing anything to disk.
+func (c *Cache) forget(key string) {
+	if e, ok := c.keydir[key]; ok {
+		c.liveBytes -= recordSize(key, e)
+		delete(c.keydir, key)
+	}
+}
+
+// append writes the record to the active data file, starting a new data file
+// first if the record would push the active one over its size limit.
+func (c *Cache) append(rec record) (*entry, error) {
+	if c.closed {
+		return nil, errClosed
+	}
+
+	if c.active.size > 0 && c.active.size+rec.size() > c.opts.MaxFileSize {
+		if err := c.rotate(c.active.id + 1); err != nil {
+			return nil, err
+		}
+	}
+
+	offset := c.active.size
+	n, err := c.active.file.WriteAt(rec.encode(), offset)
+	c.active.size += int64(n)
+	c.diskBytes += int64(n)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.opts.SyncWrites {
+		if err := c.active.file.Sync(); err != nil {
+			return nil, err
+		}
+	}
+
+	return &entry{
+		fileID:    c.active.id,
+		offset:    offset,
+		valueSize: uint32(len(rec.value)),
+		expiry:    rec.expiry,
+	}, nil
+}
+
+// rotate syncs the current active data file and makes a new, empty data file
+// with the given id the active one.
+func (c *Cache) rotate(id int) error {
+	if c.active != nil {
+		if err := c.active.file.Sync(); err != nil {
+			return err
+		}
+	}
+
+	df, err := c.openFile(id)
+	if err != nil {
+		return err
+	}
+
+	c.active = df
+	return nil
+}
+
+// incrementOffset is a common incrementor method used between Increment and
+// Decrement. If the key isn't set before, we will set the initial value. If
+// there is a value present, we will add the given offset to that value and
+// update the value with the new TTL.
+func (c *Cache) incrementOffset(key string, initial, offset, ttl int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	current, err := c.get(key)
+	if err != nil {
+		return c.set(key, encoding.Int64Bytes(initial), ttl)
+	}
+
+	val, ok := encoding.BytesInt64(current)
+	if !ok {
+		return errors.NewEncoding(key)
+	}
+
+	val += offset
+	if val < 0 {
+		return errors.NewValueBelowZero(key)
+	}
+
+	return c.set(key, encoding.Int64Bytes(val), ttl)
+}
+
+// merge writes every live record to new data files, numbered after the
+// current ones, together with their hint files. Only when all of them are
+// synced are the old files removed, oldest first, so a crash halfway through
+// leaves a directory that loads into the same state.
+func (c *Cache) merge() error {
+	if c.closed {
+		return errClosed
+	}
+
+	old := c.fileIDs()
+	next := c.active.id + 1
+	now := time.Now().UnixNano()
+
+	keys := make([]string, 0, len(c.keydir))
+	for key, e := range c.keydir {
+		if e.expired(now) {
+			c.forget(key)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	keydir := make(map[string]*entry, len(keys))
+	var merged []*dataFile
+	var hints []byte
+	var out *dataFile
+	var size int64
+
+	// abort removes the files written so far, the old files still hold every
+	// record.
+	abort := func(err error) error {
+		for _, df := range merged {
+			df.file.Close()
+			delete(c.files, df.id)
+			c.removeFile(df.id)
+		}
+		return err
+	}
+
+	finish := func() error {
+		if out == nil {
+			return nil
+		}
+		if err := out.file.Sync(); err != nil {
+			return err
+		}
+		return writeFileSync(c.path(out.id, hintExt), hints)
+	}
+
+	for _, key := range keys {
+		e := c.keydir[key]
+		df := c.files[e.fileID]
+		rec, err := readRecord(df.file, e.offset, df.size)
+		if err != nil {
+			return abort(err)
+		}
+
+		if out == nil || (out.size > 0 && out.size+rec.size() > c.opts.MaxFileSize) {
+			if err := finish(); err != nil {
+				return abort(err)
+			}
+
+			if out, err = c.openFile(next + len(merged)); err != nil {
+				return abort(err)
+			}
+			merged = append(merged, out)
+			hints = hints[:0]
+		}
+
+		n, err := out.file.WriteAt(rec.encode(), out.size)
+		if err != nil {
+			return abort(err)
+		}
+
+		ne := &entry{
+			fileID:    out.id,
+			offset:    out.size,
+			valueSize: e.valueSize,
+			expiry:    e.expiry,
+		}
+		keydir[key] = ne
+		hints = append(hints, encodeHint(key, ne)...)
+		out.size += int64(n)
+		size += int64(n)
+	}
+
+	if err := finish(); err != nil {
+		return abort(err)
+	}
+
+	// Merged files are complete with their hint file, new writes go into a
+	// fresh data file. It is opened before the old files are released, so the
+	// cache is left as it was when that fails.
+	active, err := c.openFile(next + len(merged))
+	if err != nil {
+		return abort(err)
+	}
+
+	for _, id := range old {
+		c.files[id].file.Close()
+		delete(c.files, id)
+	}
+
+	c.keydir = keydir
+	c.liveBytes = size
+	c.diskBytes = size
+	c.active = active
+
+	for _, id := range old {
+		if err := c.removeFile(id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// needsMerge reports whether the share of dead bytes is over the merge ratio.
+func (c *Cache) needsMerge() bool {
+	if c.diskBytes == 0 {
+		return false
+	}
+
+	dead := c.diskBytes - c.liveBytes
+	return float64(dead)/float64(c.diskBytes) >= c.opts.MergeRatio
+}
+
+// mergeLoop periodically merges the data files when enough space can be
+// reclaimed.
+func (c *Cache) mergeLoop() {
+	ticker := time.NewTicker(c.opts.MergeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.mu.Lock()
+			if !c.closed && c.needsMerge() {
+				c.merge()
+			}
+			c.mu.Unlock()
+		}
+	}
+}
+
+// load builds the key directory from the files in the cache directory. Data
+// files are processed in order, using the hint file instead of the data file
+// when one is present.
+func (c *Cache) load() error {
+	names, err := filepath.Glob(filepath.Join(c.dir, "*"+dataExt))
+	if err != nil {
+		return err
+	}
+
+	var ids []int
+	for _, name := range names {
+		id, err := strconv.Atoi(strings.TrimSuffix(filepath.Base(name), dataExt))
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	now := time.Now().UnixNano()
+	for i, id := range ids {
+		df, err := c.openFile(id)
+		if err != nil {
+			return err
+		}
+		c.diskBytes += df.size
+
+		if ok, err := c.loadHints(df); err != nil {
+			return err
+		} else if ok {
+			continue
+		}
+
+		if err := c.loadData(df, i == len(ids)-1); err != nil {
+			return err
+		}
+	}
+
+	for key, e := range c.keydir {
+		if e.expired(now) {
+			delete(c.keydir, key)
+			continue
+		}
+		c.liveBytes += recordSize(key, e)
+	}
+
+	if len(ids) == 0 {
+		return c.rotate(1)
+	}
+
+	// A data file with a hint file is the output of a merge and shouldn't
+	// be appended to.
+	last := ids[len(ids)-1]
+	if _, err := os.Stat(c.path(last, hintExt)); err == nil {
+		return c.rotate(last + 1)
+	}
+
+	c.active = c.files[last]
+	return nil
+}
+
+// loadHints fills the key directory from the hint file belonging to the data
+// file. It returns false when there is no usable hint file.
+func (c *Cache) loadHints(df *dataFile) (bool, error) {
+	data, err := ioutil.ReadFile(c.path(df.id, hintExt))
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	hints, err := decodeHints(data)
+	if err != nil {
+		// A merge was interrupted while writing the hint file, the data file
+		// itself is still valid.
+		return false, nil
+	}
+
+	for _, h := range hints {
+		e := h.entry
+		e.fileID = df.id
+		c.keydir[h.key] = &e
+	}
+
+	return true, nil
+}
+
+// loadData fills the key directory by reading all the records in the data
+// file. If last is set, an incomplete or corrupt record is treated as a torn
+// write and the file is truncated right before it.
+func (c *Cache) loadData(df *dataFile, last bool) error {
+	var offset int64
+	for {
+		rec, err := readRecord(df.file, offset, df.size)
+		if err == io.EOF {
+			return nil
+		}
+
+		if err == errCorrupt {
+			if !last {
+				return fmt.Errorf("bitcask: corrupt record in %s at offset %d", c.path(df.id, dataExt), offset)
+			}
+
+			c.diskBytes -= df.size - offset
+			df.size = offset
+			return df.file.Truncate(offset)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if rec.tombstone {
+			delete(c.keydir, rec.key)
+		} else {
+			c.keydir[rec.key] = &entry{
+				fileID:    df.id,
+				offset:    offset,
+				valueSize: uint32(len(rec.value)),
+				expiry:    rec.expiry,
+			}
+		}
+
+		offset += rec.size()
+	}
+}
+
+// openFile opens, or creates, the data file with the given id and registers it
+// with the cache.
+func (c *Cache) openFile(id int) (*dataFile, error) {
+	f, err := os.OpenFile(c.path(id, dataExt), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	df := &dataFile{id: id, file: f, size: info.Size()}
+	c.files[id] = df
+
+	return df, nil
+}
+
+// removeFile removes the data file with the given id and its hint file.
+func (c *Cache) removeFile(id int) error {
+	if err := os.Remove(c.path(id, hintExt)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Remove(c.path(id, dataExt))
+}
+
+// closeFiles closes all open data files, returning the first error.
+func (c *Cache) closeFiles() error {
+	var err error
+	for _, df := range c.files {
+		if cerr := df.file.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// fileIDs returns the ids of all open data files in ascending order.
+func (c *Cache) fileIDs() []int {
+	ids := make([]int, 0, len(c.files))
+	for id := range c.files {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+
+	return ids
+}
+
+func (c *Cache) path(id int, ext string) string {
+	return filepath.Join(c.dir, fmt.Sprintf("%09d%s", id, ext))
+}
+
+// recordSize returns the on-disk size of the record the entry points to.
+func recordSize(key string, e *entry) int64 {
+	return int64(recordHeaderSize+len(key)) + int64(e.valueSize)
+}
+
+// writeFileSync writes data to the named file and syncs it to disk.
+func writeFileSync(name string, data []byte) error {
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,324 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package bitcask_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jelmersnoeck/cacher/bitcask"
+)
+
+func TestReopen(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	cache := open(t, dir, bitcask.Options{})
+	cache.Set("key1", []byte("value1"), 0)
+	cache.Set("key2", []byte("value2"), 0)
+	cache.Set("key1", []byte("value3"), 0)
+	cache.Delete("key2")
+	cache.Close()
+
+	cache = open(t, dir, bitcask.Options{})
+	defer cache.Close()
+
+	if v, _, err := cache.Get("key1"); err != nil || string(v) != "value3" {
+		t.Errorf("Expected `key1` to equal `value3` after reopening, got `%s`.", v)
+		t.FailNow()
+	}
+
+	if _, _, err := cache.Get("key2"); err == nil {
+		t.Errorf("Expected `key2` to stay deleted after reopening.")
+		t.FailNow()
+	}
+}
+
+func TestTornWrite(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	cache := open(t, dir, bitcask.Options{})
+	cache.Set("key1", []byte("value1"), 0)
+	cache.Set("key2", []byte("value2"), 0)
+	cache.Close()
+
+	// Cut the last record in half, as if the process died during the write.
+	name := dataFiles(t, dir)[0]
+	info, _ := os.Stat(name)
+	if err := os.Truncate(name, info.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+
+	cache = open(t, dir, bitcask.Options{})
+	if v, _, err := cache.Get("key1"); err != nil || string(v) != "value1" {
+		t.Errorf("Expected `key1` to survive the torn write.")
+		t.FailNow()
+	}
+
+	if _, _, err := cache.Get("key2"); err == nil {
+		t.Errorf("Expected the torn record for `key2` to be discarded.")
+		t.FailNow()
+	}
+
+	// New writes should follow the last valid record.
+	cache.Set("key3", []byte("value3"), 0)
+	cache.Close()
+
+	cache = open(t, dir, bitcask.Options{})
+	defer cache.Close()
+	if v, _, err := cache.Get("key3"); err != nil || string(v) != "value3" {
+		t.Errorf("Expected `key3` to be readable after the truncated file was reopened.")
+		t.FailNow()
+	}
+}
+
+func TestCorruptRecord(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	cache := open(t, dir, bitcask.Options{MaxFileSize: 32})
+	cache.Set("key1", []byte("value1"), 0)
+	cache.Set("key2", []byte("value2"), 0)
+	cache.Close()
+
+	// Flip a byte in the value of a record that isn't at the end of the log.
+	name := dataFiles(t, dir)[0]
+	data, _ := ioutil.ReadFile(name)
+	data[len(data)-1] ^= 0xff
+	ioutil.WriteFile(name, data, 0644)
+
+	if _, err := bitcask.Open(dir, bitcask.Options{MergeInterval: -1}); err == nil {
+		t.Errorf("Expected a checksum mismatch in an older data file to fail Open.")
+		t.FailNow()
+	}
+}
+
+func TestMerge(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	cache := open(t, dir, bitcask.Options{MaxFileSize: 64})
+	for i := 0; i < 10; i++ {
+		cache.Set("key1", []byte("overwritten value"), 0)
+	}
+	cache.Set("key2", []byte("value2"), 0)
+	cache.Set("key3", []byte("value3"), 0)
+	cache.Delete("key3")
+
+	before := diskSize(t, dir)
+	if err := cache.Merge(); err != nil {
+		t.Errorf("Expected merge to succeed, got `%s`.", err)
+		t.FailNow()
+	}
+
+	if after := diskSize(t, dir); after >= before {
+		t.Errorf("Expected merge to reclaim space, went from %d to %d bytes.", before, after)
+		t.FailNow()
+	}
+
+	hints, _ := filepath.Glob(filepath.Join(dir, "*.hint"))
+	if len(hints) == 0 {
+		t.Errorf("Expected merge to write hint files.")
+		t.FailNow()
+	}
+
+	cache.Set("key4", []byte("value4"), 0)
+	cache.Close()
+
+	cache = open(t, dir, bitcask.Options{MaxFileSize: 64})
+	defer cache.Close()
+
+	expected := map[string]string{
+		"key1": "overwritten value",
+		"key2": "value2",
+		"key4": "value4",
+	}
+	for key, value := range expected {
+		if v, _, err := cache.Get(key); err != nil || string(v) != value {
+			t.Errorf("Expected `%s` to equal `%s` after merging, got `%s`.", key, value, v)
+			t.FailNow()
+		}
+	}
+
+	if _, _, err := cache.Get("key3"); err == nil {
+		t.Errorf("Expected `key3` to stay deleted after merging.")
+		t.FailNow()
+	}
+}
+
+func TestCorruptSize(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	cache := open(t, dir, bitcask.Options{})
+	cache.Set("key1", []byte("value1"), 0)
+	cache.Set("key2", []byte("value2"), 0)
+	cache.Close()
+
+	// Make the last record declare the largest key and value sizes possible.
+	name := dataFiles(t, dir)[0]
+	data, _ := ioutil.ReadFile(name)
+	header := len(data) - len("key2value2") - 8
+	for i := header; i < header+8; i++ {
+		data[i] = 0xff
+	}
+	ioutil.WriteFile(name, data, 0644)
+
+	cache = open(t, dir, bitcask.Options{})
+	defer cache.Close()
+
+	if v, _, err := cache.Get("key1"); err != nil || string(v) != "value1" {
+		t.Errorf("Expected `key1` to survive the corrupt sizes.")
+		t.FailNow()
+	}
+
+	if _, _, err := cache.Get("key2"); err == nil {
+		t.Errorf("Expected the record with corrupt sizes to be discarded.")
+		t.FailNow()
+	}
+}
+
+func TestCorruptHint(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	cache := open(t, dir, bitcask.Options{})
+	cache.Set("key1", []byte("value1"), 0)
+	cache.Set("key2", []byte("value2"), 0)
+	cache.Merge()
+	cache.Close()
+
+	// Point the first hint at another offset, the data file is still valid.
+	hints, _ := filepath.Glob(filepath.Join(dir, "*.hint"))
+	if len(hints) != 1 {
+		t.Fatalf("Expected a hint file, got %v.", hints)
+	}
+	data, _ := ioutil.ReadFile(hints[0])
+	data[27] ^= 0x01
+	ioutil.WriteFile(hints[0], data, 0644)
+
+	cache = open(t, dir, bitcask.Options{})
+	defer cache.Close()
+
+	for key, value := range map[string]string{"key1": "value1", "key2": "value2"} {
+		if v, _, err := cache.Get(key); err != nil || string(v) != value {
+			t.Errorf("Expected `%s` to be loaded from the data file, got `%s` %v.", key, v, err)
+		}
+	}
+}
+
+func TestMergeFailure(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	cache := open(t, dir, bitcask.Options{})
+	defer cache.Close()
+	cache.Set("key1", []byte("value1"), 0)
+	cache.Set("key2", []byte("value2"), 0)
+
+	// Corrupt the value of key2 on disk, so the merge can't copy it.
+	name := dataFiles(t, dir)[0]
+	data, _ := ioutil.ReadFile(name)
+	data[len(data)-1] ^= 0xff
+	ioutil.WriteFile(name, data, 0644)
+
+	if err := cache.Merge(); err == nil {
+		t.Errorf("Expected the merge to fail on the corrupt record.")
+		t.FailNow()
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(files) != 1 || files[0] != name {
+		t.Errorf("Expected the files of the failed merge to be removed, got %v.", files)
+	}
+
+	if v, _, err := cache.Get("key1"); err != nil || string(v) != "value1" {
+		t.Errorf("Expected `key1` to be kept after the failed merge, got `%s` %v.", v, err)
+	}
+}
+
+func TestRotateFailure(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	cache := open(t, dir, bitcask.Options{})
+	cache.Set("key1", []byte("value1"), 0)
+
+	// A directory in the way of the next data files keeps them from being
+	// opened. The merge writes file 2 and fails to start file 3.
+	os.Mkdir(filepath.Join(dir, "000000002.data"), 0755)
+	if err := cache.Flush(); err == nil {
+		t.Errorf("Expected the flush to fail.")
+		t.FailNow()
+	}
+	os.Remove(filepath.Join(dir, "000000002.data"))
+
+	os.Mkdir(filepath.Join(dir, "000000003.data"), 0755)
+	if err := cache.Merge(); err == nil {
+		t.Errorf("Expected the merge to fail.")
+		t.FailNow()
+	}
+	os.Remove(filepath.Join(dir, "000000003.data"))
+
+	if err := cache.Set("key2", []byte("value2"), 0); err != nil {
+		t.Errorf("Expected writes to keep working, got `%s`.", err)
+	}
+
+	if v, _, err := cache.Get("key1"); err != nil || string(v) != "value1" {
+		t.Errorf("Expected `key1` to be kept, got `%s` %v.", v, err)
+	}
+
+	if err := cache.Close(); err != nil {
+		t.Errorf("Expected the cache to close, got `%s`.", err)
+	}
+
+	if _, _, err := cache.Get("key1"); err == nil || err.Error() != "bitcask: cache is closed" {
+		t.Errorf("Expected reads to fail once the cache is closed, got %v.", err)
+	}
+}
+
+func open(t *testing.T, dir string, opts bitcask.Options) *bitcask.Cache {
+	opts.MergeInterval = -1
+	cache, err := bitcask.Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cache
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "cacher-bitcask")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir
+}
+
+func dataFiles(t *testing.T, dir string) []string {
+	names, err := filepath.Glob(filepath.Join(dir, "*.data"))
+	if err != nil || len(names) == 0 {
+		t.Fatal("no data files found")
+	}
+
+	return names
+}
+
+func diskSize(t *testing.T, dir string) int64 {
+	var size int64
+	for _, name := range dataFiles(t, dir) {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size += info.Size()
+	}
+
+	return size
+}
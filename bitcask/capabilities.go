@@ -0,0 +1,75 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package bitcask
+
+import (
+	"context"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jelmersnoeck/cacher/stats"
+)
+
+// Scan calls fn for every key that starts with prefix and hasn't expired, in
+// sorted order, until fn returns false. The keys are collected up front, so fn
+// can use the cache.
+func (c *Cache) Scan(prefix string, fn func(key string) bool) error {
+	c.mu.Lock()
+	now := time.Now().UnixNano()
+	var keys []string
+	for key, e := range c.keydir {
+		if strings.HasPrefix(key, prefix) && !e.expired(now) {
+			keys = append(keys, key)
+		}
+	}
+	c.mu.Unlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !fn(key) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Stats returns the number of items in the cache that haven't expired and the
+// size of their values. Hits and misses aren't tracked.
+func (c *Cache) Stats() (stats.Stats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var s stats.Stats
+	now := time.Now().UnixNano()
+	for _, e := range c.keydir {
+		if !e.expired(now) {
+			s.Items++
+			s.Bytes += int64(e.valueSize)
+		}
+	}
+
+	return s, nil
+}
+
+// Ping checks whether the cache is still open and its directory can be
+// reached.
+func (c *Cache) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return errClosed
+	}
+
+	_, err := os.Stat(c.dir)
+	return err
+}
@@ -0,0 +1,52 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package bitcask
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/internal/dsn"
+)
+
+func init() {
+	cacher.Register("bitcask", open)
+}
+
+// open creates a cache from a URL like
+// `bitcask:///var/cache/app?max_file_size=64MB&merge_interval=1m&merge_ratio=0.5&sync=true`.
+// The path is the directory the data files are stored in, the parameters set
+// the fields of `Options`.
+func open(u *url.URL) (cacher.Cacher, error) {
+	dir := u.Host + u.Path
+	if dir == "" {
+		return nil, fmt.Errorf("bitcask: no directory given")
+	}
+
+	var opts Options
+	var err error
+	q := u.Query()
+
+	if opts.MaxFileSize, err = dsn.Size(q, "max_file_size"); err != nil {
+		return nil, fmt.Errorf("bitcask: %s", err)
+	}
+
+	if opts.MergeInterval, err = dsn.Duration(q, "merge_interval"); err != nil {
+		return nil, fmt.Errorf("bitcask: %s", err)
+	}
+
+	if ratio := q.Get("merge_ratio"); ratio != "" {
+		if _, err := fmt.Sscanf(ratio, "%g", &opts.MergeRatio); err != nil {
+			return nil, fmt.Errorf("bitcask: merge_ratio: invalid number `%s`", ratio)
+		}
+	}
+
+	if opts.SyncWrites, err = dsn.Bool(q, "sync"); err != nil {
+		return nil, fmt.Errorf("bitcask: %s", err)
+	}
+
+	return Open(dir, opts)
+}
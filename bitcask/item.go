@@ -0,0 +1,65 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package bitcask
+
+import (
+	"time"
+
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/internal/encoding"
+	"github.com/jelmersnoeck/cacher/item"
+)
+
+// GetItem gets the value associated with the provided key together with its
+// remaining TTL, which the key directory keeps next to the location of the
+// value. Bitcask doesn't keep track of when a value was written, so the
+// timestamps of the item are left empty.
+func (c *Cache) GetItem(key string) (*item.Item, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, err := c.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	it := &item.Item{
+		Key:   key,
+		Value: value,
+		Token: encoding.Md5Sum(value),
+		TTL:   item.NoExpiry,
+		Size:  len(value),
+	}
+
+	if e := c.keydir[key]; e.expiry != 0 {
+		it.Expiry = time.Unix(0, e.expiry)
+		it.TTL = it.Expiry.Sub(time.Now())
+	}
+
+	return it, nil
+}
+
+// TTL returns the time the item stored under key has left, or
+// `item.NoExpiry` if it doesn't expire. Only the key directory is read.
+func (c *Cache) TTL(key string) (time.Duration, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return 0, errClosed
+	}
+
+	now := time.Now().UnixNano()
+	e, ok := c.keydir[key]
+	if !ok || e.expired(now) {
+		return 0, errors.NewNotFound(key)
+	}
+
+	if e.expiry == 0 {
+		return item.NoExpiry, nil
+	}
+
+	return time.Duration(e.expiry - now), nil
+}
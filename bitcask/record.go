@@ -0,0 +1,163 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package bitcask
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// A data record is laid out as follows:
+//
+//	crc32 (4) | expiry (8) | flags (1) | key size (4) | value size (4) | key | value
+//
+// The checksum covers everything that follows it, so a record that was only
+// partially written to disk will fail validation when the file is loaded.
+const recordHeaderSize = 4 + 8 + 1 + 4 + 4
+
+// A hint record points to a record in the data file with the same file id:
+//
+//	crc32 (4) | expiry (8) | key size (4) | value size (4) | record offset (8) | key
+//
+// Like in a data record, the checksum covers everything that follows it.
+const hintHeaderSize = 4 + 8 + 4 + 4 + 8
+
+const flagTombstone byte = 1
+
+// errCorrupt is returned when a record doesn't pass its checksum or is cut off
+// before its declared length.
+var errCorrupt = errors.New("bitcask: corrupt record")
+
+// errClosed is returned when the cache is used after it has been closed.
+var errClosed = errors.New("bitcask: cache is closed")
+
+type record struct {
+	expiry    int64
+	tombstone bool
+	key       string
+	value     []byte
+}
+
+// size returns the number of bytes the record takes up on disk.
+func (r record) size() int64 {
+	return int64(recordHeaderSize + len(r.key) + len(r.value))
+}
+
+// encode serialises the record including its checksum.
+func (r record) encode() []byte {
+	buf := make([]byte, r.size())
+	binary.BigEndian.PutUint64(buf[4:12], uint64(r.expiry))
+	if r.tombstone {
+		buf[12] = flagTombstone
+	}
+	binary.BigEndian.PutUint32(buf[13:17], uint32(len(r.key)))
+	binary.BigEndian.PutUint32(buf[17:21], uint32(len(r.value)))
+	copy(buf[recordHeaderSize:], r.key)
+	copy(buf[recordHeaderSize+len(r.key):], r.value)
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	return buf
+}
+
+// readRecord reads the record starting at offset of a file of size bytes.
+// io.EOF is returned when offset is exactly at the end of the file, errCorrupt
+// when the data at offset is not a complete, valid record.
+func readRecord(r io.ReaderAt, offset, size int64) (record, error) {
+	header := make([]byte, recordHeaderSize)
+	if n, err := r.ReadAt(header, offset); err != nil {
+		if err == io.EOF && n == 0 {
+			return record{}, io.EOF
+		}
+		if err == io.EOF {
+			return record{}, errCorrupt
+		}
+		return record{}, err
+	}
+
+	keySize := binary.BigEndian.Uint32(header[13:17])
+	valueSize := binary.BigEndian.Uint32(header[17:21])
+
+	// A corrupt header could declare sizes of up to 8GB, which shouldn't be
+	// allocated before the checksum is verified.
+	if int64(keySize)+int64(valueSize) > size-offset-recordHeaderSize {
+		return record{}, errCorrupt
+	}
+
+	body := make([]byte, int64(keySize)+int64(valueSize))
+	if _, err := r.ReadAt(body, offset+recordHeaderSize); err != nil {
+		if err == io.EOF {
+			return record{}, errCorrupt
+		}
+		return record{}, err
+	}
+
+	crc := crc32.NewIEEE()
+	crc.Write(header[4:])
+	crc.Write(body)
+	if crc.Sum32() != binary.BigEndian.Uint32(header[0:4]) {
+		return record{}, errCorrupt
+	}
+
+	return record{
+		expiry:    int64(binary.BigEndian.Uint64(header[4:12])),
+		tombstone: header[12]&flagTombstone != 0,
+		key:       string(body[:keySize]),
+		value:     body[keySize:],
+	}, nil
+}
+
+// encodeHint serialises a hint record for the given key directory entry.
+func encodeHint(key string, e *entry) []byte {
+	buf := make([]byte, hintHeaderSize+len(key))
+	binary.BigEndian.PutUint64(buf[4:12], uint64(e.expiry))
+	binary.BigEndian.PutUint32(buf[12:16], uint32(len(key)))
+	binary.BigEndian.PutUint32(buf[16:20], e.valueSize)
+	binary.BigEndian.PutUint64(buf[20:28], uint64(e.offset))
+	copy(buf[hintHeaderSize:], key)
+	binary.BigEndian.PutUint32(buf[0:4], crc32.ChecksumIEEE(buf[4:]))
+
+	return buf
+}
+
+// hint is the decoded form of a hint record.
+type hint struct {
+	key string
+	entry
+}
+
+// decodeHints parses the content of a hint file. The file id of the returned
+// entries is left for the caller to fill in.
+func decodeHints(data []byte) ([]hint, error) {
+	var hints []hint
+	for len(data) > 0 {
+		if len(data) < hintHeaderSize {
+			return nil, errCorrupt
+		}
+
+		keySize := int64(binary.BigEndian.Uint32(data[12:16]))
+		if int64(len(data)) < hintHeaderSize+keySize {
+			return nil, errCorrupt
+		}
+
+		n := hintHeaderSize + int(keySize)
+		if crc32.ChecksumIEEE(data[4:n]) != binary.BigEndian.Uint32(data[0:4]) {
+			return nil, errCorrupt
+		}
+
+		hints = append(hints, hint{
+			key: string(data[hintHeaderSize:n]),
+			entry: entry{
+				expiry:    int64(binary.BigEndian.Uint64(data[4:12])),
+				valueSize: binary.BigEndian.Uint32(data[16:20]),
+				offset:    int64(binary.BigEndian.Uint64(data[20:28])),
+			},
+		})
+		data = data[n:]
+	}
+
+	return hints, nil
+}
@@ -0,0 +1,220 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package cacher
+
+import (
+	"context"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/stats"
+)
+
+// Scanner is implemented by caches that can list their keys.
+type Scanner interface {
+	// Scan calls fn for every key that starts with prefix, in no particular
+	// order, until fn returns false.
+	Scan(prefix string, fn func(key string) bool) error
+}
+
+// Stater is implemented by caches that report statistics about themselves.
+type Stater interface {
+	Stats() (stats.Stats, error)
+}
+
+// Closer is implemented by caches that hold resources, such as files or
+// connections, that should be released when the cache isn't used anymore.
+type Closer interface {
+	Close() error
+}
+
+// Pinger is implemented by caches that depend on a server, to check whether it
+// can be reached.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// Locker is implemented by caches that can hold locks, for example to make
+// sure only one process recomputes an item.
+type Locker interface {
+	// TryLock takes the lock named key for ttl, unless it is held already. The
+	// returned token identifies the holder to `Unlock()`.
+	TryLock(key string, ttl time.Duration) (token string, ok bool, err error)
+
+	// Unlock releases the lock named key if it is still held with token.
+	Unlock(key, token string) error
+}
+
+// Snapshotter is implemented by caches that can write all their items to a
+// stream and read them back, for example to start warm after a restart.
+type Snapshotter interface {
+	Snapshot(w io.Writer) error
+
+	// Restore replaces the items of the cache with the ones in a snapshot.
+	Restore(r io.Reader) error
+}
+
+// Capability is a set of optional interfaces a cache implements.
+type Capability uint
+
+// The optional interfaces, as reported by `Capabilities()`.
+const (
+	CanScan Capability = 1 << iota
+	CanStat
+	CanClose
+	CanPing
+	CanLock
+	CanSnapshot
+)
+
+var capabilityNames = []string{"Scan", "Stat", "Close", "Ping", "Lock", "Snapshot"}
+
+// Has reports whether all the capabilities in other are in c.
+func (c Capability) Has(other Capability) bool {
+	return c&other == other
+}
+
+func (c Capability) String() string {
+	var names []string
+	for i, name := range capabilityNames {
+		if c.Has(1 << uint(i)) {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return "none"
+	}
+
+	return strings.Join(names, "|")
+}
+
+// Capable is implemented by wrappers that implement the optional interfaces
+// only as far as the cache they wrap does, see `Forwarder`.
+type Capable interface {
+	Capabilities() Capability
+}
+
+// Capabilities returns the optional interfaces c supports. For a Capable cache
+// that is what it reports, for other caches the interfaces it implements.
+func Capabilities(c Cacher) Capability {
+	if cc, ok := c.(Capable); ok {
+		return cc.Capabilities()
+	}
+
+	var caps Capability
+	if _, ok := c.(Scanner); ok {
+		caps |= CanScan
+	}
+	if _, ok := c.(Stater); ok {
+		caps |= CanStat
+	}
+	if _, ok := c.(Closer); ok {
+		caps |= CanClose
+	}
+	if _, ok := c.(Pinger); ok {
+		caps |= CanPing
+	}
+	if _, ok := c.(Locker); ok {
+		caps |= CanLock
+	}
+	if _, ok := c.(Snapshotter); ok {
+		caps |= CanSnapshot
+	}
+
+	return caps
+}
+
+// Forwarder implements all the optional interfaces by forwarding them to the
+// cache it was created for. Wrappers embed it so they don't hide what the
+// wrapped cache can do; operations the wrapped cache doesn't support return an
+// `errors.Unsupported`, and `Capabilities()` reports what it does support.
+type Forwarder struct {
+	next Cacher
+}
+
+// NewForwarder creates a Forwarder to next.
+func NewForwarder(next Cacher) Forwarder {
+	return Forwarder{next}
+}
+
+// Capabilities returns the capabilities of the wrapped cache.
+func (f Forwarder) Capabilities() Capability {
+	return Capabilities(f.next)
+}
+
+// Scan forwards to the wrapped cache if it is a Scanner.
+func (f Forwarder) Scan(prefix string, fn func(key string) bool) error {
+	if s, ok := f.next.(Scanner); ok {
+		return s.Scan(prefix, fn)
+	}
+
+	return errors.NewUnsupported("Scan")
+}
+
+// Stats forwards to the wrapped cache if it is a Stater.
+func (f Forwarder) Stats() (stats.Stats, error) {
+	if s, ok := f.next.(Stater); ok {
+		return s.Stats()
+	}
+
+	return stats.Stats{}, errors.NewUnsupported("Stats")
+}
+
+// Close forwards to the wrapped cache if it is a Closer.
+func (f Forwarder) Close() error {
+	if c, ok := f.next.(Closer); ok {
+		return c.Close()
+	}
+
+	return errors.NewUnsupported("Close")
+}
+
+// Ping forwards to the wrapped cache if it is a Pinger.
+func (f Forwarder) Ping(ctx context.Context) error {
+	if p, ok := f.next.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+
+	return errors.NewUnsupported("Ping")
+}
+
+// TryLock forwards to the wrapped cache if it is a Locker.
+func (f Forwarder) TryLock(key string, ttl time.Duration) (string, bool, error) {
+	if l, ok := f.next.(Locker); ok {
+		return l.TryLock(key, ttl)
+	}
+
+	return "", false, errors.NewUnsupported("TryLock")
+}
+
+// Unlock forwards to the wrapped cache if it is a Locker.
+func (f Forwarder) Unlock(key, token string) error {
+	if l, ok := f.next.(Locker); ok {
+		return l.Unlock(key, token)
+	}
+
+	return errors.NewUnsupported("Unlock")
+}
+
+// Snapshot forwards to the wrapped cache if it is a Snapshotter.
+func (f Forwarder) Snapshot(w io.Writer) error {
+	if s, ok := f.next.(Snapshotter); ok {
+		return s.Snapshot(w)
+	}
+
+	return errors.NewUnsupported("Snapshot")
+}
+
+// Restore forwards to the wrapped cache if it is a Snapshotter.
+func (f Forwarder) Restore(r io.Reader) error {
+	if s, ok := f.next.(Snapshotter); ok {
+		return s.Restore(r)
+	}
+
+	return errors.NewUnsupported("Restore")
+}
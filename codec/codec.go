@@ -0,0 +1,91 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package codec turns values into the bytes stored in a cache and back, see
+// `cacher.Typed`.
+//
+// Codecs wrapped in `WithHeader()` prefix every value with a three byte header:
+// the magic bytes 0xc1 0xcd, followed by the byte the codec is registered
+// under. The bare codecs don't record how a value was encoded, so the header
+// lets `Detect()` and `Migrate()` read values written with any registered
+// codec, and the codec of a cache can be changed without flushing it.
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+)
+
+// Codec marshals values to bytes and unmarshals them again. Unmarshal takes a
+// pointer to the value to fill.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	// JSON stores values as JSON, see `encoding/json`.
+	JSON Codec = jsonCodec{}
+
+	// Gob stores values as gobs, see `encoding/gob`. Every value is encoded
+	// in a stream of its own, so the type information is stored with each of
+	// them.
+	Gob Codec = gobCodec{}
+
+	// Raw stores []byte and string values as they are.
+	Raw Codec = rawCodec{}
+)
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	}
+
+	return nil, fmt.Errorf("codec: raw can't marshal %T", v)
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch v := v.(type) {
+	case *[]byte:
+		*v = append([]byte(nil), data...)
+		return nil
+	case *string:
+		*v = string(data)
+		return nil
+	}
+
+	return fmt.Errorf("codec: raw can't unmarshal into %T", v)
+}
@@ -0,0 +1,230 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package codec_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jelmersnoeck/cacher/codec"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type point struct {
+	X, Y int
+	Tags []string
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := point{X: 1, Y: -2, Tags: []string{"a", "b"}}
+
+	for name, cd := range map[string]codec.Codec{"json": codec.JSON, "gob": codec.Gob, "msgpack": codec.MsgPack} {
+		data, err := cd.Marshal(in)
+		if err != nil {
+			t.Fatalf("%s: expected to marshal, got `%s`.", name, err)
+		}
+
+		var out point
+		if err := cd.Unmarshal(data, &out); err != nil {
+			t.Fatalf("%s: expected to unmarshal, got `%s`.", name, err)
+		}
+
+		if !reflect.DeepEqual(in, out) {
+			t.Errorf("%s: expected %v, got %v.", name, in, out)
+		}
+	}
+}
+
+func TestRaw(t *testing.T) {
+	data, err := codec.Raw.Marshal("hello")
+	if err != nil || string(data) != "hello" {
+		t.Fatalf("Expected the string as it is, got %q `%v`.", data, err)
+	}
+
+	var b []byte
+	if err := codec.Raw.Unmarshal(data, &b); err != nil || string(b) != "hello" {
+		t.Errorf("Expected the bytes as they are, got %q `%v`.", b, err)
+	}
+
+	if _, err := codec.Raw.Marshal(42); err == nil {
+		t.Errorf("Expected raw not to marshal an int.")
+	}
+
+	var n int
+	if err := codec.Raw.Unmarshal(data, &n); err == nil {
+		t.Errorf("Expected raw not to unmarshal into an int.")
+	}
+}
+
+func TestProtobuf(t *testing.T) {
+	data, err := codec.Protobuf.Marshal(wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatalf("Expected to marshal the message, got `%s`.", err)
+	}
+
+	// A Typed[*wrapperspb.StringValue] unmarshals into a pointer to a pointer.
+	var out *wrapperspb.StringValue
+	if err := codec.Protobuf.Unmarshal(data, &out); err != nil || out.GetValue() != "hello" {
+		t.Errorf("Expected `hello`, got %v `%v`.", out, err)
+	}
+
+	in := new(wrapperspb.StringValue)
+	if err := codec.Protobuf.Unmarshal(data, in); err != nil || in.GetValue() != "hello" {
+		t.Errorf("Expected `hello`, got %v `%v`.", in, err)
+	}
+
+	if _, err := codec.Protobuf.Marshal(point{}); err == nil {
+		t.Errorf("Expected protobuf not to marshal a struct that isn't a message.")
+	}
+}
+
+func TestHeader(t *testing.T) {
+	in := point{X: 1, Y: 2}
+	data, err := codec.WithHeader(codec.MsgPack).Marshal(in)
+	if err != nil || len(data) < 3 || data[2] != codec.MsgPackHeader {
+		t.Fatalf("Expected a MessagePack header, got %v `%v`.", data, err)
+	}
+
+	detected, ok := codec.Detect(data)
+	if !ok {
+		t.Fatalf("Expected the codec to be detected.")
+	}
+
+	var out point
+	if err := detected.Unmarshal(data, &out); err != nil || !reflect.DeepEqual(in, out) {
+		t.Errorf("Expected %v, got %v `%v`.", in, out, err)
+	}
+
+	if err := codec.WithHeader(codec.JSON).Unmarshal(data, &out); err == nil {
+		t.Errorf("Expected JSON not to unmarshal a MessagePack value.")
+	}
+
+	if _, ok := codec.Detect([]byte(`{"X":1}`)); ok {
+		t.Errorf("Expected JSON text not to have a header.")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	in := point{X: 1, Y: 2}
+	migrate := codec.Migrate(codec.MsgPack, codec.JSON)
+
+	old, _ := codec.JSON.Marshal(in)
+	headered, _ := codec.WithHeader(codec.Gob).Marshal(in)
+	for _, data := range [][]byte{old, headered} {
+		var out point
+		if err := migrate.Unmarshal(data, &out); err != nil || !reflect.DeepEqual(in, out) {
+			t.Errorf("Expected %v, got %v `%v`.", in, out, err)
+		}
+	}
+
+	data, _ := migrate.Marshal(in)
+	if data[2] != codec.MsgPackHeader {
+		t.Errorf("Expected values to be written as MessagePack, got header %#x.", data[2])
+	}
+
+	if err := codec.Migrate(codec.MsgPack, nil).Unmarshal(old, new(point)); err == nil {
+		t.Errorf("Expected a value without a header to fail without a fallback.")
+	}
+}
+
+func TestMigrateLegacy(t *testing.T) {
+	// Values written without a header start with any byte, like the ids the
+	// codecs are registered under.
+	for n := 1; n <= 5; n++ {
+		for name, fallback := range map[string]codec.Codec{"gob": codec.Gob, "msgpack": codec.MsgPack} {
+			data, _ := fallback.Marshal(n)
+			if _, ok := codec.Detect(data); ok {
+				t.Errorf("%s: expected %d without a header, got one in %v.", name, n, data)
+			}
+
+			var out int
+			if err := codec.Migrate(codec.JSON, fallback).Unmarshal(data, &out); err != nil || out != n {
+				t.Errorf("%s: expected %d, got %d `%v`.", name, n, out, err)
+			}
+		}
+	}
+
+	// A value that starts with a header by chance falls back when the detected
+	// codec can't unmarshal it.
+	data := append([]byte{0xc1, 0xcd, codec.JSONHeader}, "not json"...)
+	var out []byte
+	if err := codec.Migrate(codec.JSON, codec.Raw).Unmarshal(data, &out); err != nil || string(out) != string(data) {
+		t.Errorf("Expected the value as it is, got %q `%v`.", out, err)
+	}
+}
+
+type benchUser struct {
+	ID     int64
+	Name   string
+	Email  string
+	Admin  bool
+	Groups []string
+}
+
+var benchValue = benchUser{
+	ID:     42,
+	Name:   "Jane Doe",
+	Email:  "jane@example.com",
+	Admin:  true,
+	Groups: []string{"staff", "ops", "billing"},
+}
+
+// benchMessage is benchValue as a structpb.Struct, which stores its field names
+// like JSON does. A generated message is smaller and faster.
+func benchMessage() proto.Message {
+	m, _ := structpb.NewStruct(map[string]interface{}{
+		"ID":     42,
+		"Name":   "Jane Doe",
+		"Email":  "jane@example.com",
+		"Admin":  true,
+		"Groups": []interface{}{"staff", "ops", "billing"},
+	})
+	return m
+}
+
+func benchCodecs() map[string]struct {
+	codec codec.Codec
+	value interface{}
+	new   func() interface{}
+} {
+	return map[string]struct {
+		codec codec.Codec
+		value interface{}
+		new   func() interface{}
+	}{
+		"JSON":     {codec.JSON, benchValue, func() interface{} { return new(benchUser) }},
+		"Gob":      {codec.Gob, benchValue, func() interface{} { return new(benchUser) }},
+		"MsgPack":  {codec.MsgPack, benchValue, func() interface{} { return new(benchUser) }},
+		"Protobuf": {codec.Protobuf, benchMessage(), func() interface{} { return new(structpb.Struct) }},
+	}
+}
+
+func BenchmarkMarshal(b *testing.B) {
+	for name, bc := range benchCodecs() {
+		b.Run(name, func(b *testing.B) {
+			var data []byte
+			for i := 0; i < b.N; i++ {
+				data, _ = bc.codec.Marshal(bc.value)
+			}
+			b.ReportMetric(float64(len(data)), "bytes/value")
+		})
+	}
+}
+
+func BenchmarkUnmarshal(b *testing.B) {
+	for name, bc := range benchCodecs() {
+		b.Run(name, func(b *testing.B) {
+			data, _ := bc.codec.Marshal(bc.value)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := bc.codec.Unmarshal(data, bc.new()); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
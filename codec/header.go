@@ -0,0 +1,173 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// magic starts every header, followed by the byte the codec is registered
+// under. 0xc1 is never used by MessagePack and can't start a gob or JSON value,
+// so values written without a header aren't mistaken for values with one.
+var magic = []byte{0xc1, 0xcd}
+
+// The header bytes of the built-in codecs.
+const (
+	JSONHeader     byte = 0x01
+	GobHeader      byte = 0x02
+	RawHeader      byte = 0x03
+	MsgPackHeader  byte = 0x04
+	ProtobufHeader byte = 0x05
+)
+
+var (
+	mu      sync.RWMutex
+	byID    = make(map[byte]Codec)
+	headers = make(map[Codec]byte)
+)
+
+func init() {
+	Register(JSONHeader, JSON)
+	Register(GobHeader, Gob)
+	Register(RawHeader, Raw)
+	Register(MsgPackHeader, MsgPack)
+	Register(ProtobufHeader, Protobuf)
+}
+
+// Register makes c available to `WithHeader()` and `Detect()` under the header
+// byte id, which follows the magic bytes of the header. c has to be comparable,
+// like the built-in codecs. If Register is called twice with the same id or
+// codec, or if c is nil, it panics.
+func Register(id byte, c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if c == nil {
+		panic("codec: Register codec is nil")
+	}
+
+	if _, dup := byID[id]; dup {
+		panic(fmt.Sprintf("codec: Register called twice for header %#x", id))
+	}
+
+	if _, dup := headers[c]; dup {
+		panic(fmt.Sprintf("codec: Register called twice for codec %T", c))
+	}
+
+	byID[id] = c
+	headers[c] = id
+}
+
+// WithHeader returns a codec that marshals values with c and prefixes them
+// with a header naming the byte c is registered under, so `Detect()` can tell
+// which codec a value was written with. Unmarshal only accepts values with
+// that header. If c isn't registered, it panics.
+func WithHeader(c Codec) Codec {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	id, ok := headers[c]
+	if !ok {
+		panic(fmt.Sprintf("codec: WithHeader codec %T is not registered", c))
+	}
+
+	return headerCodec{id: id, codec: c}
+}
+
+// Detect returns the codec a value marshalled by `WithHeader()` was written
+// with, wrapped in `WithHeader()` so it can unmarshal the value as it is. It
+// returns false if the value doesn't start with a registered header.
+func Detect(data []byte) (Codec, bool) {
+	if len(data) <= len(magic) || !bytes.HasPrefix(data, magic) {
+		return nil, false
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+
+	id := data[len(magic)]
+	c, ok := byID[id]
+	if !ok {
+		return nil, false
+	}
+
+	return headerCodec{id: id, codec: c}, true
+}
+
+// Migrate returns a codec that marshals values with `WithHeader(to)`, and
+// unmarshals values written by any registered codec with a header. Values
+// without a registered header, or that the detected codec can't unmarshal, are
+// unmarshalled with fallback, unless it is nil. Using it with `cacher.Typed`
+// moves values to the new codec as they're written:
+//
+//	users := cacher.NewTyped[User](cache, codec.Migrate(codec.MsgPack, codec.JSON))
+func Migrate(to, fallback Codec) Codec {
+	return migrateCodec{to: WithHeader(to), fallback: fallback}
+}
+
+type headerCodec struct {
+	id    byte
+	codec Codec
+}
+
+func (c headerCodec) Marshal(v interface{}) ([]byte, error) {
+	data, err := c.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	header := append(append([]byte{}, magic...), c.id)
+	return append(header, data...), nil
+}
+
+func (c headerCodec) Unmarshal(data []byte, v interface{}) error {
+	n := len(magic)
+	if len(data) <= n || !bytes.HasPrefix(data, magic) || data[n] != c.id {
+		return fmt.Errorf("codec: value doesn't start with header %#x", c.id)
+	}
+
+	return c.codec.Unmarshal(data[n+1:], v)
+}
+
+type migrateCodec struct {
+	to       Codec
+	fallback Codec
+}
+
+func (c migrateCodec) Marshal(v interface{}) ([]byte, error) {
+	return c.to.Marshal(v)
+}
+
+func (c migrateCodec) Unmarshal(data []byte, v interface{}) error {
+	detected, ok := Detect(data)
+	if !ok && c.fallback == nil {
+		return errors.New("codec: value doesn't start with a known header")
+	}
+
+	if !ok {
+		return c.fallback.Unmarshal(data, v)
+	}
+
+	err := detected.Unmarshal(data, v)
+	if err == nil || c.fallback == nil {
+		return err
+	}
+
+	// The value may start with a header by chance. The detected codec might
+	// have filled in part of v, so it starts over from the zero value.
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv.Elem().Set(reflect.Zero(rv.Elem().Type()))
+	}
+
+	if c.fallback.Unmarshal(data, v) != nil {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package codec
+
+import (
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Protobuf stores `proto.Message` values in the Protocol Buffers wire format.
+// Unmarshal takes either a message, or a pointer to a message pointer, which
+// is what `cacher.Typed` passes for a Typed[*pb.Message]:
+//
+//	users := cacher.NewTyped[*pb.User](cache, codec.Protobuf)
+var Protobuf Codec = protobufCodec{}
+
+var messageType = reflect.TypeOf((*proto.Message)(nil)).Elem()
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("codec: protobuf can't marshal %T", v)
+	}
+
+	return proto.Marshal(m)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	if m, ok := v.(proto.Message); ok {
+		return proto.Unmarshal(data, m)
+	}
+
+	ptr := reflect.ValueOf(v)
+	if ptr.Kind() != reflect.Ptr || ptr.IsNil() || !ptr.Type().Elem().Implements(messageType) ||
+		ptr.Type().Elem().Kind() != reflect.Ptr {
+		return fmt.Errorf("codec: protobuf can't unmarshal into %T", v)
+	}
+
+	m := reflect.New(ptr.Type().Elem().Elem())
+	if err := proto.Unmarshal(data, m.Interface().(proto.Message)); err != nil {
+		return err
+	}
+
+	ptr.Elem().Set(m)
+	return nil
+}
@@ -0,0 +1,321 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package compress provides a cache that compresses the values it stores in
+// another cache.
+//
+// Values over a size threshold are compressed and stored behind a small header
+// naming the algorithm, so they're decompressed with the right algorithm even
+// after the configured one has changed. Smaller values, and values that don't
+// get smaller, are stored as they are. Increment and Decrement bypass the
+// compression, so counters keep working.
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/golang/snappy"
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/errors"
+)
+
+// Algorithm is a compression algorithm.
+type Algorithm byte
+
+// The supported algorithms. None marks values that are stored uncompressed
+// behind a header, because they'd otherwise be mistaken for one.
+const (
+	None Algorithm = iota
+	Gzip
+	Zlib
+	Snappy
+)
+
+func (a Algorithm) String() string {
+	switch a {
+	case None:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Zlib:
+		return "zlib"
+	case Snappy:
+		return "snappy"
+	}
+
+	return fmt.Sprintf("Algorithm(%d)", byte(a))
+}
+
+// magic starts the header of a value written by this package. It is followed
+// by the Algorithm.
+var magic = []byte{0xc0, 0x3d}
+
+const headerSize = 3
+
+// Options configures a Cache.
+type Options struct {
+	// Algorithm compresses the values. Defaults to Snappy, which is fast but
+	// compresses less than Gzip and Zlib.
+	Algorithm Algorithm
+
+	// Threshold is the size in bytes from which values are compressed.
+	// Defaults to 1KB.
+	Threshold int
+
+	// Level is the compression level of Gzip and Zlib, from 1 for the fastest
+	// to 9 for the best compression. Defaults to their default level.
+	Level int
+}
+
+// Cache is a caching implementation that compresses the values it stores in
+// another cache. The optional interfaces of that cache, such as
+// `cacher.Closer`, are forwarded.
+type Cache struct {
+	cacher.Forwarder
+
+	next cacher.Cacher
+	opts Options
+}
+
+// New creates a new instance of Cache which stores the values in next.
+func New(next cacher.Cacher, opts Options) *Cache {
+	if opts.Algorithm == None {
+		opts.Algorithm = Snappy
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = 1024
+	}
+	if opts.Level == 0 {
+		opts.Level = gzip.DefaultCompression
+	}
+
+	cache := new(Cache)
+	cache.Forwarder = cacher.NewForwarder(next)
+	cache.next = next
+	cache.opts = opts
+
+	return cache
+}
+
+// Add compresses the value and adds it to the cache.
+func (c *Cache) Add(key string, value []byte, ttl int64) error {
+	data, err := c.compress(value)
+	if err != nil {
+		return err
+	}
+
+	return c.next.Add(key, data, ttl)
+}
+
+// CompareAndReplace compresses the value and replaces it if token matches.
+// The token is the one returned by `Get()`.
+func (c *Cache) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	data, err := c.compress(value)
+	if err != nil {
+		return err
+	}
+
+	return c.next.CompareAndReplace(token, key, data, ttl)
+}
+
+// Set compresses the value and sets it in the cache.
+func (c *Cache) Set(key string, value []byte, ttl int64) error {
+	data, err := c.compress(value)
+	if err != nil {
+		return err
+	}
+
+	return c.next.Set(key, data, ttl)
+}
+
+// SetMulti compresses the values and sets them in the cache.
+func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	results := make(map[string]error)
+	compressed := make(map[string][]byte)
+	for key, value := range items {
+		data, err := c.compress(value)
+		if err != nil {
+			results[key] = err
+			continue
+		}
+		compressed[key] = data
+	}
+
+	for key, err := range c.next.SetMulti(compressed, ttl) {
+		results[key] = err
+	}
+
+	return results
+}
+
+// Replace compresses the value and replaces it in the cache.
+func (c *Cache) Replace(key string, value []byte, ttl int64) error {
+	data, err := c.compress(value)
+	if err != nil {
+		return err
+	}
+
+	return c.next.Replace(key, data, ttl)
+}
+
+// Increment increments the counter in the cache, which isn't compressed.
+func (c *Cache) Increment(key string, initial, offset, ttl int64) error {
+	return c.next.Increment(key, initial, offset, ttl)
+}
+
+// Decrement decrements the counter in the cache, which isn't compressed.
+func (c *Cache) Decrement(key string, initial, offset, ttl int64) error {
+	return c.next.Decrement(key, initial, offset, ttl)
+}
+
+// Delete deletes the item from the cache.
+func (c *Cache) Delete(key string) error {
+	return c.next.Delete(key)
+}
+
+// DeleteMulti deletes the items from the cache.
+func (c *Cache) DeleteMulti(keys []string) map[string]error {
+	return c.next.DeleteMulti(keys)
+}
+
+// Get gets the value from the cache and decompresses it. A value that can't be
+// decompressed returns an `errors.Decode`.
+func (c *Cache) Get(key string) ([]byte, string, error) {
+	data, token, err := c.next.Get(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	value, err := decompress(data)
+	if err != nil {
+		return nil, "", errors.NewDecode(key, err)
+	}
+
+	return value, token, nil
+}
+
+// GetMulti gets the values from the cache and decompresses them.
+func (c *Cache) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	items, tokens, errs := c.next.GetMulti(keys)
+	if errs == nil {
+		errs = make(map[string]error)
+	}
+
+	for key, data := range items {
+		if errs[key] != nil {
+			continue
+		}
+
+		value, err := decompress(data)
+		if err != nil {
+			errs[key] = errors.NewDecode(key, err)
+			delete(items, key)
+			delete(tokens, key)
+			continue
+		}
+		items[key] = value
+	}
+
+	return items, tokens, errs
+}
+
+// Flush removes all the items from the cache.
+func (c *Cache) Flush() error {
+	return c.next.Flush()
+}
+
+// Touch updates the ttl of the item.
+func (c *Cache) Touch(key string, ttl int64) error {
+	return c.next.Touch(key, ttl)
+}
+
+// compress returns the value to store for value: compressed behind a header
+// if it is over the threshold and gets smaller, as it is otherwise.
+func (c *Cache) compress(value []byte) ([]byte, error) {
+	if len(value) >= c.opts.Threshold {
+		var buf bytes.Buffer
+		buf.Write(magic)
+		buf.WriteByte(byte(c.opts.Algorithm))
+
+		if err := c.write(&buf, value); err != nil {
+			return nil, err
+		}
+
+		if buf.Len() < len(value) {
+			return buf.Bytes(), nil
+		}
+	}
+
+	if !bytes.HasPrefix(value, magic) {
+		return value, nil
+	}
+
+	data := make([]byte, headerSize+len(value))
+	copy(data, magic)
+	data[len(magic)] = byte(None)
+	copy(data[headerSize:], value)
+
+	return data, nil
+}
+
+// write compresses value into w with the configured algorithm.
+func (c *Cache) write(w io.Writer, value []byte) error {
+	var zw io.WriteCloser
+	var err error
+	switch c.opts.Algorithm {
+	case Gzip:
+		zw, err = gzip.NewWriterLevel(w, c.opts.Level)
+	case Zlib:
+		zw, err = zlib.NewWriterLevel(w, c.opts.Level)
+	case Snappy:
+		_, err = w.Write(snappy.Encode(nil, value))
+		return err
+	default:
+		return fmt.Errorf("compress: unknown algorithm %s", c.opts.Algorithm)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := zw.Write(value); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+// decompress returns the value stored as data, which may or may not have a
+// header.
+func decompress(data []byte) ([]byte, error) {
+	if len(data) < headerSize || !bytes.HasPrefix(data, magic) {
+		return data, nil
+	}
+
+	body := data[headerSize:]
+	var r io.ReadCloser
+	var err error
+	switch Algorithm(data[len(magic)]) {
+	case None:
+		return body, nil
+	case Gzip:
+		r, err = gzip.NewReader(bytes.NewReader(body))
+	case Zlib:
+		r, err = zlib.NewReader(bytes.NewReader(body))
+	case Snappy:
+		return snappy.Decode(nil, body)
+	default:
+		return nil, fmt.Errorf("compress: unknown algorithm %s", Algorithm(data[len(magic)]))
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
@@ -0,0 +1,95 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package compress_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jelmersnoeck/cacher/compress"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/memory"
+)
+
+var large = bytes.Repeat([]byte("compressible "), 200)
+
+func TestAlgorithms(t *testing.T) {
+	for _, algorithm := range []compress.Algorithm{compress.Gzip, compress.Zlib, compress.Snappy} {
+		store := memory.New(0)
+		cache := compress.New(store, compress.Options{Algorithm: algorithm})
+
+		cache.Set("key1", large, 0)
+
+		stored, _, _ := store.Get("key1")
+		if len(stored) >= len(large) {
+			t.Errorf("%s: expected the value to be compressed, got %d bytes.", algorithm, len(stored))
+			t.FailNow()
+		}
+
+		if value, _, err := cache.Get("key1"); err != nil || !bytes.Equal(value, large) {
+			t.Errorf("%s: expected the value to be decompressed, got `%v`.", algorithm, err)
+			t.FailNow()
+		}
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	store := memory.New(0)
+	cache := compress.New(store, compress.Options{Threshold: 1 << 20})
+
+	cache.Set("key1", large, 0)
+	if stored, _, _ := store.Get("key1"); !bytes.Equal(stored, large) {
+		t.Errorf("Expected a value under the threshold to be stored as it is.")
+		t.FailNow()
+	}
+
+	// A value that looks like a header has to survive the round trip.
+	header := []byte{0xc0, 0x3d, 0x01, 'x'}
+	cache.Set("key2", header, 0)
+	if value, _, _ := cache.Get("key2"); !bytes.Equal(value, header) {
+		t.Errorf("Expected %v, got %v.", header, value)
+		t.FailNow()
+	}
+}
+
+func TestSwitchAlgorithm(t *testing.T) {
+	store := memory.New(0)
+	compress.New(store, compress.Options{Algorithm: compress.Gzip}).Set("key1", large, 0)
+
+	cache := compress.New(store, compress.Options{Algorithm: compress.Snappy})
+	cache.Set("key2", large, 0)
+
+	items, _, errs := cache.GetMulti([]string{"key1", "key2"})
+	for _, key := range []string{"key1", "key2"} {
+		if errs[key] != nil || !bytes.Equal(items[key], large) {
+			t.Errorf("Expected `%s` to be decompressed, got `%v`.", key, errs[key])
+			t.FailNow()
+		}
+	}
+}
+
+func TestCounters(t *testing.T) {
+	cache := compress.New(memory.New(0), compress.Options{Threshold: 1})
+
+	cache.Increment("counter", 5, 1, 0)
+	cache.Increment("counter", 5, 10, 0)
+	cache.Decrement("counter", 0, 3, 0)
+
+	if value, _, _ := cache.Get("counter"); string(value) != "12" {
+		t.Errorf("Expected the counter to be 12, got `%s`.", value)
+		t.FailNow()
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	store := memory.New(0)
+	cache := compress.New(store, compress.Options{})
+
+	store.Set("key1", []byte{0xc0, 0x3d, byte(compress.Gzip), 'x'}, 0)
+	if _, _, err := cache.Get("key1"); !errors.Is(err, errors.ErrDecode) {
+		t.Errorf("Expected a decode error, got `%v`.", err)
+		t.FailNow()
+	}
+}
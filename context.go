@@ -0,0 +1,161 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package cacher
+
+import "context"
+
+// ContextCacher is a Cacher that also accepts a context for every operation.
+// Once the context is cancelled or its deadline has passed, operations return
+// the error of the context instead of waiting for the backend.
+type ContextCacher interface {
+	Cacher
+
+	AddCtx(ctx context.Context, key string, value []byte, ttl int64) error
+	CompareAndReplaceCtx(ctx context.Context, token, key string, value []byte, ttl int64) error
+	SetCtx(ctx context.Context, key string, value []byte, ttl int64) error
+	SetMultiCtx(ctx context.Context, keys map[string][]byte, ttl int64) map[string]error
+	ReplaceCtx(ctx context.Context, key string, value []byte, ttl int64) error
+	IncrementCtx(ctx context.Context, key string, initial, offset, ttl int64) error
+	DecrementCtx(ctx context.Context, key string, initial, offset, ttl int64) error
+	DeleteCtx(ctx context.Context, key string) error
+	DeleteMultiCtx(ctx context.Context, keys []string) map[string]error
+	GetCtx(ctx context.Context, key string) ([]byte, string, error)
+	GetMultiCtx(ctx context.Context, keys []string) (map[string][]byte, map[string]string, map[string]error)
+	FlushCtx(ctx context.Context) error
+	TouchCtx(ctx context.Context, key string, ttl int64) error
+}
+
+// WithContext returns c as a ContextCacher. Caches that support contexts
+// natively are returned as they are. Other caches are wrapped so that the
+// context is checked before every operation, an operation that has started
+// can't be interrupted.
+func WithContext(c Cacher) ContextCacher {
+	if cc, ok := c.(ContextCacher); ok {
+		return cc
+	}
+
+	return contextAdapter{c, NewForwarder(c)}
+}
+
+type contextAdapter struct {
+	Cacher
+	Forwarder
+}
+
+func (a contextAdapter) AddCtx(ctx context.Context, key string, value []byte, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return a.Add(key, value, ttl)
+}
+
+func (a contextAdapter) CompareAndReplaceCtx(ctx context.Context, token, key string, value []byte, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return a.CompareAndReplace(token, key, value, ttl)
+}
+
+func (a contextAdapter) SetCtx(ctx context.Context, key string, value []byte, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return a.Set(key, value, ttl)
+}
+
+func (a contextAdapter) SetMultiCtx(ctx context.Context, items map[string][]byte, ttl int64) map[string]error {
+	if err := ctx.Err(); err != nil {
+		results := make(map[string]error)
+		for key := range items {
+			results[key] = err
+		}
+		return results
+	}
+
+	return a.SetMulti(items, ttl)
+}
+
+func (a contextAdapter) ReplaceCtx(ctx context.Context, key string, value []byte, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return a.Replace(key, value, ttl)
+}
+
+func (a contextAdapter) IncrementCtx(ctx context.Context, key string, initial, offset, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return a.Increment(key, initial, offset, ttl)
+}
+
+func (a contextAdapter) DecrementCtx(ctx context.Context, key string, initial, offset, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return a.Decrement(key, initial, offset, ttl)
+}
+
+func (a contextAdapter) DeleteCtx(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return a.Delete(key)
+}
+
+func (a contextAdapter) DeleteMultiCtx(ctx context.Context, keys []string) map[string]error {
+	if err := ctx.Err(); err != nil {
+		results := make(map[string]error)
+		for _, key := range keys {
+			results[key] = err
+		}
+		return results
+	}
+
+	return a.DeleteMulti(keys)
+}
+
+func (a contextAdapter) GetCtx(ctx context.Context, key string) ([]byte, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	return a.Get(key)
+}
+
+func (a contextAdapter) GetMultiCtx(ctx context.Context, keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	if err := ctx.Err(); err != nil {
+		errs := make(map[string]error)
+		for _, key := range keys {
+			errs[key] = err
+		}
+		return make(map[string][]byte), make(map[string]string), errs
+	}
+
+	return a.GetMulti(keys)
+}
+
+func (a contextAdapter) FlushCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return a.Flush()
+}
+
+func (a contextAdapter) TouchCtx(ctx context.Context, key string, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return a.Touch(key, ttl)
+}
@@ -0,0 +1,283 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package encrypt provides a cache that encrypts the values it stores in
+// another cache with AES-256-GCM, so they can be kept in a shared cache.
+//
+// Every value is sealed in an envelope that records the ID of the key it was
+// encrypted with. Values are always written with the current key and read
+// with whichever key they were written with, so keys can be rotated by adding
+// a new current key and keeping the old ones until the values written with
+// them have expired.
+package encrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/errors"
+)
+
+// version is the first byte of every envelope, followed by the key ID as a big
+// endian uint32, the nonce and the sealed value.
+const version = 1
+
+const headerSize = 5
+
+// Key is an AES-256 key and the ID it is known by in the envelopes.
+type Key struct {
+	ID     uint32
+	Secret []byte
+}
+
+// Options configures a Cache.
+type Options struct {
+	// Keys are the keys values can be read with. The first key is the
+	// current one, which all values are written with.
+	Keys []Key
+
+	// BindKey authenticates the cache key with every value, so a value that
+	// is copied to another key fails to decrypt.
+	BindKey bool
+}
+
+// Cache is a caching implementation that encrypts the values it stores in
+// another cache. Values that fail to decrypt return an `errors.Tampered`.
+// Counters can't be encrypted, so Increment and Decrement aren't supported.
+// The optional interfaces of the other cache, such as `cacher.Closer`, are
+// forwarded.
+type Cache struct {
+	cacher.Forwarder
+
+	next    cacher.Cacher
+	current uint32
+	aeads   map[uint32]cipher.AEAD
+	bindKey bool
+}
+
+// New creates a new instance of Cache which stores the values in next. It
+// returns an error if there are no keys, if a key isn't 32 bytes long or if
+// two keys have the same ID.
+func New(next cacher.Cacher, opts Options) (*Cache, error) {
+	if len(opts.Keys) == 0 {
+		return nil, fmt.Errorf("encrypt: no keys")
+	}
+
+	cache := new(Cache)
+	cache.Forwarder = cacher.NewForwarder(next)
+	cache.next = next
+	cache.current = opts.Keys[0].ID
+	cache.aeads = make(map[uint32]cipher.AEAD)
+	cache.bindKey = opts.BindKey
+
+	for _, key := range opts.Keys {
+		if len(key.Secret) != 32 {
+			return nil, fmt.Errorf("encrypt: key %d is %d bytes, expected 32", key.ID, len(key.Secret))
+		}
+
+		if _, dup := cache.aeads[key.ID]; dup {
+			return nil, fmt.Errorf("encrypt: duplicate key %d", key.ID)
+		}
+
+		block, err := aes.NewCipher(key.Secret)
+		if err != nil {
+			return nil, err
+		}
+
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		cache.aeads[key.ID] = aead
+	}
+
+	return cache, nil
+}
+
+// Add encrypts the value and adds it to the cache.
+func (c *Cache) Add(key string, value []byte, ttl int64) error {
+	data, err := c.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	return c.next.Add(key, data, ttl)
+}
+
+// CompareAndReplace encrypts the value and replaces it if token matches. The
+// token is the one returned by `Get()`.
+func (c *Cache) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	data, err := c.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	return c.next.CompareAndReplace(token, key, data, ttl)
+}
+
+// Set encrypts the value and sets it in the cache.
+func (c *Cache) Set(key string, value []byte, ttl int64) error {
+	data, err := c.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	return c.next.Set(key, data, ttl)
+}
+
+// SetMulti encrypts the values and sets them in the cache.
+func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	results := make(map[string]error)
+	sealed := make(map[string][]byte)
+	for key, value := range items {
+		data, err := c.seal(key, value)
+		if err != nil {
+			results[key] = err
+			continue
+		}
+		sealed[key] = data
+	}
+
+	for key, err := range c.next.SetMulti(sealed, ttl) {
+		results[key] = err
+	}
+
+	return results
+}
+
+// Replace encrypts the value and replaces it in the cache.
+func (c *Cache) Replace(key string, value []byte, ttl int64) error {
+	data, err := c.seal(key, value)
+	if err != nil {
+		return err
+	}
+
+	return c.next.Replace(key, data, ttl)
+}
+
+// Increment isn't supported, the cache can't add to an encrypted value.
+func (c *Cache) Increment(key string, initial, offset, ttl int64) error {
+	return errors.NewUnsupported("Increment")
+}
+
+// Decrement isn't supported, the cache can't subtract from an encrypted value.
+func (c *Cache) Decrement(key string, initial, offset, ttl int64) error {
+	return errors.NewUnsupported("Decrement")
+}
+
+// Delete deletes the item from the cache.
+func (c *Cache) Delete(key string) error {
+	return c.next.Delete(key)
+}
+
+// DeleteMulti deletes the items from the cache.
+func (c *Cache) DeleteMulti(keys []string) map[string]error {
+	return c.next.DeleteMulti(keys)
+}
+
+// Get gets the value from the cache and decrypts it.
+func (c *Cache) Get(key string) ([]byte, string, error) {
+	data, token, err := c.next.Get(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	value, err := c.open(key, data)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return value, token, nil
+}
+
+// GetMulti gets the values from the cache and decrypts them.
+func (c *Cache) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	items, tokens, errs := c.next.GetMulti(keys)
+	if errs == nil {
+		errs = make(map[string]error)
+	}
+
+	for key, data := range items {
+		if errs[key] != nil {
+			continue
+		}
+
+		value, err := c.open(key, data)
+		if err != nil {
+			errs[key] = err
+			delete(items, key)
+			delete(tokens, key)
+			continue
+		}
+		items[key] = value
+	}
+
+	return items, tokens, errs
+}
+
+// Flush removes all the items from the cache.
+func (c *Cache) Flush() error {
+	return c.next.Flush()
+}
+
+// Touch updates the ttl of the item.
+func (c *Cache) Touch(key string, ttl int64) error {
+	return c.next.Touch(key, ttl)
+}
+
+// seal encrypts value for key with the current key.
+func (c *Cache) seal(key string, value []byte) ([]byte, error) {
+	aead := c.aeads[c.current]
+
+	data := make([]byte, headerSize+aead.NonceSize(), headerSize+aead.NonceSize()+len(value)+aead.Overhead())
+	data[0] = version
+	binary.BigEndian.PutUint32(data[1:headerSize], c.current)
+
+	nonce := data[headerSize:]
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return aead.Seal(data, nonce, value, c.additionalData(key, data[:headerSize])), nil
+}
+
+// open decrypts the envelope stored under key.
+func (c *Cache) open(key string, data []byte) ([]byte, error) {
+	if len(data) < headerSize || data[0] != version {
+		return nil, errors.NewTampered(key)
+	}
+
+	// A key this cache doesn't have can't authenticate the value either, so
+	// it can't be told apart from a forged key ID.
+	aead, ok := c.aeads[binary.BigEndian.Uint32(data[1:headerSize])]
+	if !ok {
+		return nil, errors.NewTampered(key)
+	}
+
+	if len(data) < headerSize+aead.NonceSize() {
+		return nil, errors.NewTampered(key)
+	}
+
+	nonce := data[headerSize : headerSize+aead.NonceSize()]
+	value, err := aead.Open(nil, nonce, data[headerSize+aead.NonceSize():], c.additionalData(key, data[:headerSize]))
+	if err != nil {
+		return nil, errors.NewTampered(key)
+	}
+
+	return value, nil
+}
+
+// additionalData is what is authenticated next to the value: the header of
+// the envelope, and the cache key if it is bound.
+func (c *Cache) additionalData(key string, header []byte) []byte {
+	if !c.bindKey {
+		return header
+	}
+
+	return append(append([]byte(nil), header...), key...)
+}
@@ -0,0 +1,134 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package encrypt_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jelmersnoeck/cacher/encrypt"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/memory"
+)
+
+var (
+	oldKey = encrypt.Key{ID: 1, Secret: bytes.Repeat([]byte{1}, 32)}
+	newKey = encrypt.Key{ID: 2, Secret: bytes.Repeat([]byte{2}, 32)}
+)
+
+func TestEncrypt(t *testing.T) {
+	store := memory.New(0)
+	cache, err := encrypt.New(store, encrypt.Options{Keys: []encrypt.Key{oldKey}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache.Set("key1", []byte("secret"), 0)
+
+	if stored, _, _ := store.Get("key1"); bytes.Contains(stored, []byte("secret")) {
+		t.Errorf("Expected the value to be encrypted, got %q.", stored)
+		t.FailNow()
+	}
+
+	value, token, err := cache.Get("key1")
+	if err != nil || string(value) != "secret" {
+		t.Errorf("Expected `secret`, got `%s` and `%v`.", value, err)
+		t.FailNow()
+	}
+
+	if err := cache.CompareAndReplace(token, "key1", []byte("other"), 0); err != nil {
+		t.Errorf("Expected CompareAndReplace to succeed, got `%v`.", err)
+		t.FailNow()
+	}
+
+	items, _, errs := cache.GetMulti([]string{"key1", "key2"})
+	if string(items["key1"]) != "other" || !errors.Is(errs["key2"], errors.ErrNotFound) {
+		t.Errorf("Expected `other` and a missing key2, got `%s` and `%v`.", items["key1"], errs["key2"])
+		t.FailNow()
+	}
+}
+
+func TestRotate(t *testing.T) {
+	store := memory.New(0)
+	old, _ := encrypt.New(store, encrypt.Options{Keys: []encrypt.Key{oldKey}})
+	old.Set("key1", []byte("value1"), 0)
+
+	cache, _ := encrypt.New(store, encrypt.Options{Keys: []encrypt.Key{newKey, oldKey}})
+	cache.Set("key2", []byte("value2"), 0)
+
+	items, _, errs := cache.GetMulti([]string{"key1", "key2"})
+	if errs["key1"] != nil || errs["key2"] != nil || string(items["key1"]) != "value1" || string(items["key2"]) != "value2" {
+		t.Errorf("Expected both values to decrypt, got `%v` and `%v`.", errs["key1"], errs["key2"])
+		t.FailNow()
+	}
+
+	// The old cache doesn't know the new key.
+	if _, _, err := old.Get("key2"); !errors.Is(err, errors.ErrTampered) {
+		t.Errorf("Expected a tampered error for an unknown key, got `%v`.", err)
+		t.FailNow()
+	}
+}
+
+func TestTampered(t *testing.T) {
+	store := memory.New(0)
+	cache, _ := encrypt.New(store, encrypt.Options{Keys: []encrypt.Key{oldKey}, BindKey: true})
+
+	cache.Set("key1", []byte("value1"), 0)
+	stored, _, _ := store.Get("key1")
+
+	flipped := append([]byte(nil), stored...)
+	flipped[len(flipped)-1] ^= 1
+	store.Set("key2", flipped, 0)
+	store.Set("key3", stored, 0)
+	store.Set("key4", []byte("plain"), 0)
+
+	for _, key := range []string{"key2", "key3", "key4"} {
+		_, _, err := cache.Get(key)
+		if !errors.Is(err, errors.ErrTampered) {
+			t.Errorf("Expected `%s` to be tampered, got `%v`.", key, err)
+			t.FailNow()
+		}
+
+		var tampered errors.Tampered
+		if !errors.As(err, &tampered) || tampered.Key != key {
+			t.Errorf("Expected an errors.Tampered for `%s`, got `%v`.", key, err)
+			t.FailNow()
+		}
+	}
+
+	// Without BindKey a value can be moved to another key.
+	unbound, _ := encrypt.New(store, encrypt.Options{Keys: []encrypt.Key{oldKey}})
+	unbound.Set("key1", []byte("value1"), 0)
+	stored, _, _ = store.Get("key1")
+	store.Set("key3", stored, 0)
+	if value, _, err := unbound.Get("key3"); err != nil || string(value) != "value1" {
+		t.Errorf("Expected the moved value to decrypt, got `%v`.", err)
+		t.FailNow()
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []encrypt.Options{
+		{},
+		{Keys: []encrypt.Key{{ID: 1, Secret: []byte("short")}}},
+		{Keys: []encrypt.Key{oldKey, {ID: 1, Secret: newKey.Secret}}},
+	}
+
+	for i, opts := range tests {
+		if _, err := encrypt.New(memory.New(0), opts); err == nil {
+			t.Errorf("%d: expected an error.", i)
+			t.FailNow()
+		}
+	}
+}
+
+func TestCounters(t *testing.T) {
+	cache, _ := encrypt.New(memory.New(0), encrypt.Options{Keys: []encrypt.Key{oldKey}})
+
+	if err := cache.Increment("counter", 0, 1, 0); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Expected Increment to be unsupported, got `%v`.", err)
+		t.FailNow()
+	}
+}
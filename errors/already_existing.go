@@ -2,18 +2,23 @@ package errors
 
 import "fmt"
 
-// AlreadyExisingKey is an error type used for when the key is expected not to
-// exist in the cache yet, but it is already present.
+// AlreadyExistingKey is an error type used for when the key is expected not to
+// exist in the cache yet, but it is already present. It matches ErrExists.
 type AlreadyExistingKey struct {
-	key string
+	Key string
 }
 
 func (e AlreadyExistingKey) Error() string {
-	return fmt.Sprintf("Key `%s` already exists.", e.key)
+	return fmt.Sprintf("Key `%s` already exists.", e.Key)
+}
+
+// Is reports whether target is ErrExists.
+func (e AlreadyExistingKey) Is(target error) bool {
+	return target == ErrExists
 }
 
 func NewAlreadyExistingKey(key string) error {
 	return AlreadyExistingKey{
-		key: key,
+		Key: key,
 	}
 }
@@ -0,0 +1,25 @@
+package errors
+
+import "fmt"
+
+// CASConflict errors are used when a value is compared and replaced with a
+// token that no longer matches the stored value, because it has been changed
+// in the meantime. It matches ErrCASConflict.
+type CASConflict struct {
+	Key string
+}
+
+func (e CASConflict) Error() string {
+	return fmt.Sprintf("Key `%s` was changed since its token was read.", e.Key)
+}
+
+// Is reports whether target is ErrCASConflict.
+func (e CASConflict) Is(target error) bool {
+	return target == ErrCASConflict
+}
+
+func NewCASConflict(key string) error {
+	return CASConflict{
+		Key: key,
+	}
+}
@@ -0,0 +1,32 @@
+package errors
+
+import "fmt"
+
+// Decode errors are used when a stored value can't be turned back into the
+// type it was read as, because it was written with a different codec or type.
+// It matches ErrDecode, Err is the error of the codec.
+type Decode struct {
+	Key string
+	Err error
+}
+
+func (e Decode) Error() string {
+	return fmt.Sprintf("Value for key `%s` could not be decoded: %s", e.Key, e.Err)
+}
+
+// Is reports whether target is ErrDecode.
+func (e Decode) Is(target error) bool {
+	return target == ErrDecode
+}
+
+// Unwrap returns the error of the codec.
+func (e Decode) Unwrap() error {
+	return e.Err
+}
+
+func NewDecode(key string, err error) error {
+	return Decode{
+		Key: key,
+		Err: err,
+	}
+}
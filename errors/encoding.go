@@ -2,17 +2,23 @@ package errors
 
 import "fmt"
 
-// Encoding is an error used when encoding data to bytes fails.
+// Encoding is an error used when the stored value can't be decoded to a
+// number to increment or decrement it. It matches ErrNotNumeric.
 type Encoding struct {
-	key string
+	Key string
 }
 
 func (e Encoding) Error() string {
-	return fmt.Sprintf("Value for key `%s` could not be encoded.", e.key)
+	return fmt.Sprintf("Value for key `%s` could not be encoded.", e.Key)
+}
+
+// Is reports whether target is ErrNotNumeric.
+func (e Encoding) Is(target error) bool {
+	return target == ErrNotNumeric
 }
 
 func NewEncoding(key string) error {
 	return Encoding{
-		key: key,
+		Key: key,
 	}
 }
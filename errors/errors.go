@@ -0,0 +1,70 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package errors defines the errors returned by the caches. Every cache
+// returns the same kind of error for the same situation, which can be checked
+// against the sentinel errors with `Is()`:
+//
+//	if errors.Is(err, errors.ErrNotFound) {
+//		// load the value
+//	}
+//
+// The errors are typed, so the key they're about is available through `As()`:
+//
+//	var nf errors.NotFound
+//	if errors.As(err, &nf) {
+//		log.Printf("missing %s", nf.Key)
+//	}
+package errors
+
+import stderrors "errors"
+
+var (
+	// ErrNotFound is matched by errors for a key that isn't in the cache, or
+	// has expired.
+	ErrNotFound = stderrors.New("cacher: key not found")
+
+	// ErrExists is matched by errors for a key that is expected not to be in
+	// the cache yet, but is.
+	ErrExists = stderrors.New("cacher: key already exists")
+
+	// ErrCASConflict is matched by errors for a compare and replace with a
+	// token that doesn't match the stored value anymore.
+	ErrCASConflict = stderrors.New("cacher: token doesn't match")
+
+	// ErrNotNumeric is matched by errors for incrementing or decrementing a
+	// value that isn't a number.
+	ErrNotNumeric = stderrors.New("cacher: value is not numeric")
+
+	// ErrUnderflow is matched by errors for decrementing a value below 0.
+	ErrUnderflow = stderrors.New("cacher: value would drop below 0")
+
+	// ErrInvalidRange is matched by errors for an increment or decrement with
+	// a negative initial value or an offset that isn't positive.
+	ErrInvalidRange = stderrors.New("cacher: invalid range")
+
+	// ErrDecode is matched by errors for a stored value that can't be
+	// decoded to the type it is read as.
+	ErrDecode = stderrors.New("cacher: value can't be decoded")
+
+	// ErrTampered is matched by errors for a stored value that fails
+	// authentication.
+	ErrTampered = stderrors.New("cacher: value failed authentication")
+
+	// ErrUnsupported is matched by errors for something a cache can't do.
+	ErrUnsupported = stderrors.New("cacher: not supported")
+)
+
+// Is reports whether any error in err's chain matches target, see the
+// standard library's `errors.Is()`.
+func Is(err, target error) bool {
+	return stderrors.Is(err, target)
+}
+
+// As finds the first error in err's chain that matches target, and if so,
+// sets target to that error value and returns true, see the standard library's
+// `errors.As()`.
+func As(err error, target interface{}) bool {
+	return stderrors.As(err, target)
+}
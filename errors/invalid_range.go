@@ -3,23 +3,28 @@ package errors
 import "fmt"
 
 // InvalidRange errors are used when we want to increment or decrement a value
-// which is out of range of the specific operation.
+// which is out of range of the specific operation. It matches ErrInvalidRange.
 type InvalidRange struct {
-	initial int64
-	offset  int64
+	Initial int64
+	Offset  int64
 }
 
 func (e InvalidRange) Error() string {
 	return fmt.Sprintf(
-		"The range `%s` to `%s` is not supported.",
-		e.initial,
-		e.offset,
+		"The range `%d` to `%d` is not supported.",
+		e.Initial,
+		e.Offset,
 	)
 }
 
+// Is reports whether target is ErrInvalidRange.
+func (e InvalidRange) Is(target error) bool {
+	return target == ErrInvalidRange
+}
+
 func NewInvalidRange(initial, offset int64) error {
 	return InvalidRange{
-		initial: initial,
-		offset:  offset,
+		Initial: initial,
+		Offset:  offset,
 	}
 }
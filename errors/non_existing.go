@@ -2,18 +2,25 @@ package errors
 
 import "fmt"
 
-// NonExistingKey errors are used when we look if a key exists. It is not to be
-// confused with the `NotFound` error.
+// NonExistingKey errors are used when we look if a key exists. It matches
+// ErrNotFound, like the `NotFound` error.
+//
+// Deprecated: the caches return `NotFound` for every missing key.
 type NonExistingKey struct {
-	key string
+	Key string
 }
 
 func (e NonExistingKey) Error() string {
-	return fmt.Sprintf("Key `%s` does not exist.", e.key)
+	return fmt.Sprintf("Key `%s` does not exist.", e.Key)
+}
+
+// Is reports whether target is ErrNotFound.
+func (e NonExistingKey) Is(target error) bool {
+	return target == ErrNotFound
 }
 
 func NewNonExistingKey(key string) error {
 	return NonExistingKey{
-		key: key,
+		Key: key,
 	}
 }
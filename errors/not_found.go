@@ -3,17 +3,22 @@ package errors
 import "fmt"
 
 // NotFound errors are used when we expect the data to be in the cache already
-// but it isn't.
+// but it isn't. It matches ErrNotFound.
 type NotFound struct {
-	key string
+	Key string
 }
 
 func (e NotFound) Error() string {
-	return fmt.Sprintf("Key `%s` was not found.", e.key)
+	return fmt.Sprintf("Key `%s` was not found.", e.Key)
+}
+
+// Is reports whether target is ErrNotFound.
+func (e NotFound) Is(target error) bool {
+	return target == ErrNotFound
 }
 
 func NewNotFound(key string) error {
 	return NotFound{
-		key: key,
+		Key: key,
 	}
 }
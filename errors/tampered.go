@@ -0,0 +1,25 @@
+package errors
+
+import "fmt"
+
+// Tampered errors are used when a stored value fails authentication, because
+// it was changed, moved to another key or not written by the cache that reads
+// it. It matches ErrTampered.
+type Tampered struct {
+	Key string
+}
+
+func (e Tampered) Error() string {
+	return fmt.Sprintf("Value for key `%s` failed authentication.", e.Key)
+}
+
+// Is reports whether target is ErrTampered.
+func (e Tampered) Is(target error) bool {
+	return target == ErrTampered
+}
+
+func NewTampered(key string) error {
+	return Tampered{
+		Key: key,
+	}
+}
@@ -0,0 +1,24 @@
+package errors
+
+import "fmt"
+
+// Unsupported errors are used when a cache is asked to do something it can't,
+// such as a combination of options. It matches ErrUnsupported.
+type Unsupported struct {
+	Feature string
+}
+
+func (e Unsupported) Error() string {
+	return fmt.Sprintf("`%s` is not supported.", e.Feature)
+}
+
+// Is reports whether target is ErrUnsupported.
+func (e Unsupported) Is(target error) bool {
+	return target == ErrUnsupported
+}
+
+func NewUnsupported(feature string) error {
+	return Unsupported{
+		Feature: feature,
+	}
+}
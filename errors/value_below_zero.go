@@ -3,17 +3,22 @@ package errors
 import "fmt"
 
 // ValueBelowZero errors are used for incrementing issues where the value drops
-// below zero, which is not supported by all caches.
+// below zero, which is not supported by all caches. It matches ErrUnderflow.
 type ValueBelowZero struct {
-	key string
+	Key string
 }
 
 func (e ValueBelowZero) Error() string {
-	return fmt.Sprintf("Value for key `%s` dropped below 0.", e.key)
+	return fmt.Sprintf("Value for key `%s` dropped below 0.", e.Key)
+}
+
+// Is reports whether target is ErrUnderflow.
+func (e ValueBelowZero) Is(target error) bool {
+	return target == ErrUnderflow
 }
 
 func NewValueBelowZero(key string) error {
 	return ValueBelowZero{
-		key: key,
+		Key: key,
 	}
 }
@@ -0,0 +1,75 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package cacher
+
+import "github.com/jelmersnoeck/cacher/expiry"
+
+// ExpiryCacher is a Cacher that also accepts an `expiry.Expiry` instead of a
+// ttl in seconds, so items can expire after a `time.Duration` or at a given
+// time.
+//
+//	cache.SetUntil("key", value, expiry.In(1500*time.Millisecond))
+//	cache.SetUntil("key", value, expiry.At(midnight))
+//	cache.SetUntil("key", value, expiry.Never)
+type ExpiryCacher interface {
+	Cacher
+
+	AddUntil(key string, value []byte, exp expiry.Expiry) error
+	CompareAndReplaceUntil(token, key string, value []byte, exp expiry.Expiry) error
+	SetUntil(key string, value []byte, exp expiry.Expiry) error
+	SetMultiUntil(keys map[string][]byte, exp expiry.Expiry) map[string]error
+	ReplaceUntil(key string, value []byte, exp expiry.Expiry) error
+	IncrementUntil(key string, initial, offset int64, exp expiry.Expiry) error
+	DecrementUntil(key string, initial, offset int64, exp expiry.Expiry) error
+	TouchUntil(key string, exp expiry.Expiry) error
+}
+
+// WithExpiry returns c as an ExpiryCacher. Caches that support expiries
+// natively are returned as they are, with millisecond precision or better.
+// Other caches are wrapped so the expiry is rounded up to whole seconds.
+func WithExpiry(c Cacher) ExpiryCacher {
+	if ec, ok := c.(ExpiryCacher); ok {
+		return ec
+	}
+
+	return expiryAdapter{c, NewForwarder(c)}
+}
+
+type expiryAdapter struct {
+	Cacher
+	Forwarder
+}
+
+func (a expiryAdapter) AddUntil(key string, value []byte, exp expiry.Expiry) error {
+	return a.Add(key, value, exp.Seconds())
+}
+
+func (a expiryAdapter) CompareAndReplaceUntil(token, key string, value []byte, exp expiry.Expiry) error {
+	return a.CompareAndReplace(token, key, value, exp.Seconds())
+}
+
+func (a expiryAdapter) SetUntil(key string, value []byte, exp expiry.Expiry) error {
+	return a.Set(key, value, exp.Seconds())
+}
+
+func (a expiryAdapter) SetMultiUntil(items map[string][]byte, exp expiry.Expiry) map[string]error {
+	return a.SetMulti(items, exp.Seconds())
+}
+
+func (a expiryAdapter) ReplaceUntil(key string, value []byte, exp expiry.Expiry) error {
+	return a.Replace(key, value, exp.Seconds())
+}
+
+func (a expiryAdapter) IncrementUntil(key string, initial, offset int64, exp expiry.Expiry) error {
+	return a.Increment(key, initial, offset, exp.Seconds())
+}
+
+func (a expiryAdapter) DecrementUntil(key string, initial, offset int64, exp expiry.Expiry) error {
+	return a.Decrement(key, initial, offset, exp.Seconds())
+}
+
+func (a expiryAdapter) TouchUntil(key string, exp expiry.Expiry) error {
+	return a.Touch(key, exp.Seconds())
+}
@@ -0,0 +1,96 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package expiry describes when a cached item expires, either after a
+// duration or at a given time, as an alternative to a ttl in seconds.
+package expiry
+
+import "time"
+
+// Expiry is the moment an item expires. The zero value is `Never`.
+type Expiry struct {
+	at time.Time
+}
+
+// Never is the Expiry of items that don't expire.
+var Never = Expiry{}
+
+// In returns the Expiry d from now. When d is 0 or negative, the item is
+// expired straight away, which removes it from the cache.
+func In(d time.Duration) Expiry {
+	return Expiry{time.Now().Add(d)}
+}
+
+// At returns the Expiry at t. The zero time means `Never`.
+func At(t time.Time) Expiry {
+	return Expiry{t}
+}
+
+// FromSeconds converts a ttl as accepted by `cacher.Cacher` into an Expiry: 0
+// means Never, a negative ttl means the item is already expired.
+func FromSeconds(ttl int64) Expiry {
+	if ttl == 0 {
+		return Never
+	}
+
+	if ttl < 0 {
+		return Expiry{time.Unix(0, 0)}
+	}
+
+	return In(time.Duration(ttl) * time.Second)
+}
+
+// IsNever reports whether e is `Never`.
+func (e Expiry) IsNever() bool {
+	return e.at.IsZero()
+}
+
+// Time returns the moment of expiry, or the zero time for `Never`.
+func (e Expiry) Time() time.Time {
+	return e.at
+}
+
+// Remaining returns the time left until e, which is 0 or negative once e has
+// passed. It is meaningless for `Never`.
+func (e Expiry) Remaining() time.Duration {
+	return e.at.Sub(time.Now())
+}
+
+// Expired reports whether e has passed.
+func (e Expiry) Expired() bool {
+	return !e.IsNever() && !time.Now().Before(e.at)
+}
+
+// Seconds converts e back into a ttl as accepted by `cacher.Cacher`, rounding
+// the time left up to whole seconds. It returns 0 for `Never` and -1 once e
+// has passed.
+func (e Expiry) Seconds() int64 {
+	if e.IsNever() {
+		return 0
+	}
+
+	return ceil(e.Remaining(), time.Second)
+}
+
+// Milliseconds is like `Seconds()`, but rounds up to whole milliseconds.
+func (e Expiry) Milliseconds() int64 {
+	if e.IsNever() {
+		return 0
+	}
+
+	return ceil(e.Remaining(), time.Millisecond)
+}
+
+func ceil(d, unit time.Duration) int64 {
+	if d <= 0 {
+		return -1
+	}
+
+	n := int64(d / unit)
+	if d%unit != 0 {
+		n++
+	}
+
+	return n
+}
@@ -0,0 +1,58 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package expiry_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jelmersnoeck/cacher/expiry"
+)
+
+func TestFromSeconds(t *testing.T) {
+	if !expiry.FromSeconds(0).IsNever() {
+		t.Errorf("Expected a ttl of 0 to never expire.")
+	}
+
+	if !expiry.FromSeconds(-1).Expired() {
+		t.Errorf("Expected a negative ttl to be expired.")
+	}
+
+	if s := expiry.FromSeconds(5).Seconds(); s != 5 {
+		t.Errorf("Expected a ttl of 5 to convert back to 5 seconds, got %d.", s)
+	}
+}
+
+func TestRounding(t *testing.T) {
+	e := expiry.In(1500 * time.Millisecond)
+
+	if s := e.Seconds(); s != 2 {
+		t.Errorf("Expected 1.5s to round up to 2 seconds, got %d.", s)
+	}
+
+	if ms := e.Milliseconds(); ms < 1499 || ms > 1500 {
+		t.Errorf("Expected 1.5s to be about 1500 milliseconds, got %d.", ms)
+	}
+
+	if s := expiry.In(-time.Second).Seconds(); s != -1 {
+		t.Errorf("Expected a passed expiry to convert to -1, got %d.", s)
+	}
+
+	if s := expiry.Never.Seconds(); s != 0 {
+		t.Errorf("Expected Never to convert to 0, got %d.", s)
+	}
+}
+
+func TestAt(t *testing.T) {
+	at := time.Now().Add(time.Hour)
+
+	if e := expiry.At(at); e.Time() != at || e.IsNever() || e.Expired() {
+		t.Errorf("Expected the expiry to be at %s.", at)
+	}
+
+	if !expiry.At(time.Time{}).IsNever() {
+		t.Errorf("Expected the zero time to never expire.")
+	}
+}
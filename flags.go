@@ -0,0 +1,144 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package cacher
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// FlagCacher is a Cacher that stores a uint32 of flags with every item, like
+// memcached does, for example to mark how the value is encoded. Items written
+// without flags have flags 0.
+type FlagCacher interface {
+	Cacher
+
+	AddWithFlags(key string, value []byte, flags uint32, ttl int64) error
+	SetWithFlags(key string, value []byte, flags uint32, ttl int64) error
+	ReplaceWithFlags(key string, value []byte, flags uint32, ttl int64) error
+	CompareAndReplaceWithFlags(token, key string, value []byte, flags uint32, ttl int64) error
+	GetWithFlags(key string) ([]byte, string, uint32, error)
+	GetMultiWithFlags(keys []string) (map[string][]byte, map[string]string, map[string]uint32, map[string]error)
+}
+
+// WithFlags returns c as a FlagCacher. Caches that store flags natively are
+// returned as they are. Other caches are wrapped so non-zero flags are stored
+// in a header in front of the value. Values with flags 0 are stored as they
+// are, but all the items with flags should be accessed through the wrapper.
+func WithFlags(c Cacher) FlagCacher {
+	if fc, ok := c.(FlagCacher); ok {
+		return fc
+	}
+
+	return flagsAdapter{c, NewForwarder(c)}
+}
+
+// flagsMagic starts the header of a value with flags. It is followed by the
+// flags as a big endian uint32.
+var flagsMagic = []byte{0xca, 0xf1}
+
+const flagsHeaderSize = 6
+
+type flagsAdapter struct {
+	Cacher
+	Forwarder
+}
+
+func (a flagsAdapter) Add(key string, value []byte, ttl int64) error {
+	return a.AddWithFlags(key, value, 0, ttl)
+}
+
+func (a flagsAdapter) AddWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	return a.Cacher.Add(key, encodeFlags(value, flags), ttl)
+}
+
+func (a flagsAdapter) Set(key string, value []byte, ttl int64) error {
+	return a.SetWithFlags(key, value, 0, ttl)
+}
+
+func (a flagsAdapter) SetWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	return a.Cacher.Set(key, encodeFlags(value, flags), ttl)
+}
+
+func (a flagsAdapter) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	encoded := make(map[string][]byte)
+	for key, value := range items {
+		encoded[key] = encodeFlags(value, 0)
+	}
+
+	return a.Cacher.SetMulti(encoded, ttl)
+}
+
+func (a flagsAdapter) Replace(key string, value []byte, ttl int64) error {
+	return a.ReplaceWithFlags(key, value, 0, ttl)
+}
+
+func (a flagsAdapter) ReplaceWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	return a.Cacher.Replace(key, encodeFlags(value, flags), ttl)
+}
+
+func (a flagsAdapter) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	return a.CompareAndReplaceWithFlags(token, key, value, 0, ttl)
+}
+
+func (a flagsAdapter) CompareAndReplaceWithFlags(token, key string, value []byte, flags uint32, ttl int64) error {
+	return a.Cacher.CompareAndReplace(token, key, encodeFlags(value, flags), ttl)
+}
+
+func (a flagsAdapter) Get(key string) ([]byte, string, error) {
+	value, token, _, err := a.GetWithFlags(key)
+	return value, token, err
+}
+
+func (a flagsAdapter) GetWithFlags(key string) ([]byte, string, uint32, error) {
+	data, token, err := a.Cacher.Get(key)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	value, flags := decodeFlags(data)
+	return value, token, flags, nil
+}
+
+func (a flagsAdapter) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	items, tokens, _, errs := a.GetMultiWithFlags(keys)
+	return items, tokens, errs
+}
+
+func (a flagsAdapter) GetMultiWithFlags(keys []string) (map[string][]byte, map[string]string, map[string]uint32, map[string]error) {
+	items, tokens, errs := a.Cacher.GetMulti(keys)
+
+	flags := make(map[string]uint32)
+	for key, data := range items {
+		if errs[key] == nil {
+			items[key], flags[key] = decodeFlags(data)
+		}
+	}
+
+	return items, tokens, flags, errs
+}
+
+// encodeFlags prefixes value with a header holding flags. Values without flags
+// are only prefixed when they could be mistaken for a header.
+func encodeFlags(value []byte, flags uint32) []byte {
+	if flags == 0 && !bytes.HasPrefix(value, flagsMagic) {
+		return value
+	}
+
+	data := make([]byte, flagsHeaderSize+len(value))
+	copy(data, flagsMagic)
+	binary.BigEndian.PutUint32(data[len(flagsMagic):], flags)
+	copy(data[flagsHeaderSize:], value)
+
+	return data
+}
+
+func decodeFlags(data []byte) ([]byte, uint32) {
+	if len(data) < flagsHeaderSize || !bytes.HasPrefix(data, flagsMagic) {
+		return data, 0
+	}
+
+	return data[flagsHeaderSize:], binary.BigEndian.Uint32(data[len(flagsMagic):])
+}
@@ -0,0 +1,13 @@
+module github.com/jelmersnoeck/cacher
+
+go 1.23
+
+require (
+	github.com/bradfitz/gomemcache v0.0.0-20230905024940-24af94b03874
+	github.com/garyburd/redigo v1.6.0
+	github.com/golang/snappy v1.0.0
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	google.golang.org/protobuf v1.36.9
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
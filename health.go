@@ -0,0 +1,99 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package cacher
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Close closes c if it is a Closer. Caches that hold no resources return nil.
+func Close(c Cacher) error {
+	if Capabilities(c).Has(CanClose) {
+		return c.(Closer).Close()
+	}
+
+	return nil
+}
+
+// Ping checks c if it is a Pinger. Caches that can't be checked are assumed to
+// be available, unless ctx is done.
+func Ping(ctx context.Context, c Cacher) error {
+	if Capabilities(c).Has(CanPing) {
+		return c.(Pinger).Ping(ctx)
+	}
+
+	return ctx.Err()
+}
+
+// Health is the status of a single cache, as reported by `HealthHandler()`.
+type Health struct {
+	Status  string  `json:"status"`
+	Error   string  `json:"error,omitempty"`
+	Latency float64 `json:"latency_ms"`
+}
+
+// HealthHandler returns a handler that pings all caches concurrently and
+// reports the status and latency of each of them as JSON, keyed by name:
+//
+//	{"status":"ok","caches":{"redis":{"status":"ok","latency_ms":0.41}}}
+//
+// The response is 200 OK when all caches can be reached and 503 Service
+// Unavailable otherwise, so it can be used as a readiness probe. Every ping is
+// limited to timeout, a timeout of 0 only uses the deadline of the request.
+func HealthHandler(caches map[string]Cacher, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		var mu sync.Mutex
+		var wg sync.WaitGroup
+		healthy := true
+		results := make(map[string]Health)
+
+		wg.Add(len(caches))
+		for name, c := range caches {
+			go func(name string, c Cacher) {
+				defer wg.Done()
+
+				start := time.Now()
+				err := Ping(ctx, c)
+				h := Health{
+					Status:  "ok",
+					Latency: float64(time.Since(start)) / float64(time.Millisecond),
+				}
+				if err != nil {
+					h.Status = "unavailable"
+					h.Error = err.Error()
+				}
+
+				mu.Lock()
+				defer mu.Unlock()
+				results[name] = h
+				healthy = healthy && err == nil
+			}(name, c)
+		}
+		wg.Wait()
+
+		status, code := "ok", http.StatusOK
+		if !healthy {
+			status, code = "unavailable", http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(struct {
+			Status string            `json:"status"`
+			Caches map[string]Health `json:"caches"`
+		}{status, results})
+	})
+}
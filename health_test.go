@@ -0,0 +1,71 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package cacher_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/bitcask"
+	"github.com/jelmersnoeck/cacher/memory"
+	"github.com/jelmersnoeck/cacher/noop"
+)
+
+type healthResponse struct {
+	Status string
+	Caches map[string]cacher.Health
+}
+
+func checkHealth(t *testing.T, h http.Handler) (int, healthResponse) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+
+	var resp healthResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("Expecting a JSON response, got %s.", err)
+	}
+
+	return rec.Code, resp
+}
+
+func TestHealthHandler(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "cacher-bitcask")
+	bitcaskCache, _ := bitcask.Open(dir, bitcask.Options{MergeInterval: -1})
+
+	h := cacher.HealthHandler(map[string]cacher.Cacher{
+		"memory":  memory.New(0),
+		"bitcask": bitcaskCache,
+		"noop":    noop.New(),
+	}, time.Second)
+
+	code, resp := checkHealth(t, h)
+	if code != http.StatusOK || resp.Status != "ok" {
+		t.Errorf("Expecting all caches to be healthy, got %d %s.", code, resp.Status)
+	}
+
+	if len(resp.Caches) != 3 || resp.Caches["bitcask"].Status != "ok" {
+		t.Errorf("Expecting the status of every cache, got %v.", resp.Caches)
+	}
+
+	bitcaskCache.Close()
+
+	code, resp = checkHealth(t, h)
+	if code != http.StatusServiceUnavailable || resp.Status != "unavailable" {
+		t.Errorf("Expecting the handler to report a closed cache, got %d %s.", code, resp.Status)
+	}
+
+	if health := resp.Caches["bitcask"]; health.Status != "unavailable" || health.Error == "" {
+		t.Errorf("Expecting bitcask to be unavailable, got %v.", health)
+	}
+
+	if resp.Caches["memory"].Status != "ok" {
+		t.Errorf("Expecting memory to stay healthy, got %v.", resp.Caches["memory"])
+	}
+}
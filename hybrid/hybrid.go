@@ -0,0 +1,418 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package hybrid provides a cache that keeps its hot items in memory and spills
+// the items evicted from memory to disk.
+//
+// When the memory tier goes over its limit, the least recently used items are
+// demoted to the disk tier instead of being dropped. Accessing a demoted item
+// promotes it back into memory. Items keep their expiry when they move between
+// tiers.
+package hybrid
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/bitcask"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/internal/encoding"
+	"github.com/jelmersnoeck/cacher/memory"
+	"github.com/jelmersnoeck/cacher/stats"
+)
+
+// Cache is a caching implementation that combines a memory cache with a
+// Bitcask cache on disk.
+type Cache struct {
+	// Forwarder forwards the locks to the memory tier.
+	cacher.Forwarder
+
+	mu        sync.Mutex
+	memory    *memory.Cache
+	disk      *bitcask.Cache
+	diskLimit int64
+
+	// Demoted keys in the order they were written to disk, with the size they
+	// take up there, so the oldest ones can be dropped when the disk tier is
+	// over its limit.
+	diskKeys  []string
+	diskSizes map[string]int64
+	diskSize  int64
+}
+
+// New creates a new instance of Cache. The size of mem determines how much data
+// is kept in memory, diskLimit is the number of bytes of values that can be
+// spilled to disk before the oldest spilled values are dropped.
+//
+// The disk cache is used as scratch space and is flushed, so it shouldn't be
+// shared with anything else.
+func New(mem *memory.Cache, disk *bitcask.Cache, diskLimit int64) (*Cache, error) {
+	if err := disk.Flush(); err != nil {
+		return nil, err
+	}
+
+	cache := new(Cache)
+	cache.Forwarder = cacher.NewForwarder(mem)
+	cache.memory = mem
+	cache.disk = disk
+	cache.diskLimit = diskLimit
+	cache.diskSizes = make(map[string]int64)
+	mem.OnEvict(cache.demote)
+
+	return cache, nil
+}
+
+// Add an item to the cache. If the item is already cached in either tier, the
+// value won't be overwritten.
+func (c *Cache) Add(key string, value []byte, ttl int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.promote(key)
+	return c.memory.Add(key, value, ttl)
+}
+
+// CompareAndReplace validates the token with the token in the store. If the
+// tokens match, we will replace the value and return true. If it doesn't, we
+// will not replace the value and return false.
+func (c *Cache) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.promote(key)
+	return c.memory.CompareAndReplace(token, key, value, ttl)
+}
+
+// Set stores the value in memory, removing a copy on disk if there is one.
+func (c *Cache) Set(key string, value []byte, ttl int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.forget(key)
+	return c.memory.Set(key, value, ttl)
+}
+
+// SetMulti sets multiple values for their respective keys. This is a shorthand
+// to use `Set` multiple times.
+func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	results := make(map[string]error)
+	for key, value := range items {
+		results[key] = c.Set(key, value, ttl)
+	}
+
+	return results
+}
+
+// Replace will update and only update the value of a cache key. If the key is
+// not previously used, we will return false.
+func (c *Cache) Replace(key string, value []byte, ttl int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.promote(key)
+	return c.memory.Replace(key, value, ttl)
+}
+
+// Increment adds a value of offset to the initial value. If the initial value
+// is already set, it will be added to the value currently stored in the cache.
+func (c *Cache) Increment(key string, initial, offset, ttl int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.promote(key)
+	return c.memory.Increment(key, initial, offset, ttl)
+}
+
+// Decrement subtracts a value of offset to the initial value. If the initial
+// value is already set, it will be added to the value currently stored in the
+// cache.
+func (c *Cache) Decrement(key string, initial, offset, ttl int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.promote(key)
+	return c.memory.Decrement(key, initial, offset, ttl)
+}
+
+// Delete removes the item from whichever tier it is stored in.
+func (c *Cache) Delete(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.forget(key) {
+		c.memory.Delete(key)
+		return nil
+	}
+
+	return c.memory.Delete(key)
+}
+
+// DeleteMulti will delete multiple values at a time. It uses the `Delete`
+// method internally to do so. It will return a map of results to see if the
+// deletion is successful.
+func (c *Cache) DeleteMulti(keys []string) map[string]error {
+	results := make(map[string]error)
+
+	for _, key := range keys {
+		results[key] = c.Delete(key)
+	}
+
+	return results
+}
+
+// Get gets the value from memory. If it has been demoted to disk, it is
+// promoted back into memory.
+func (c *Cache) Get(key string) ([]byte, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// A value that doesn't fit in memory is demoted again straight away, so
+	// return what was read from disk.
+	if value, ok := c.promote(key); ok {
+		return value, encoding.Md5Sum(value), nil
+	}
+
+	return c.memory.Get(key)
+}
+
+// GetMulti gets multiple values from the cache and returns them as a map. It
+// uses `Get` internally to retrieve the data.
+func (c *Cache) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	items := make(map[string][]byte)
+	errs := make(map[string]error)
+	tokens := make(map[string]string)
+
+	for _, k := range keys {
+		items[k], tokens[k], errs[k] = c.Get(k)
+	}
+
+	return items, tokens, errs
+}
+
+// Flush removes all the items from both tiers.
+func (c *Cache) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.diskKeys = nil
+	c.diskSizes = make(map[string]int64)
+	c.diskSize = 0
+	c.memory.Flush()
+
+	return c.disk.Flush()
+}
+
+// Touch will update the key's ttl to the given ttl value without altering the
+// value.
+func (c *Cache) Touch(key string, ttl int64) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.promote(key)
+	return c.memory.Touch(key, ttl)
+}
+
+// OnDisk reports whether the item has been demoted to the disk tier, without
+// promoting it.
+func (c *Cache) OnDisk(key string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.diskSizes[key]
+	return ok
+}
+
+// Scan calls fn for every key that starts with prefix in either tier, in no
+// particular order, until fn returns false.
+func (c *Cache) Scan(prefix string, fn func(key string) bool) error {
+	var keys []string
+	collect := func(key string) bool {
+		keys = append(keys, key)
+		return true
+	}
+
+	c.mu.Lock()
+	c.memory.Scan(prefix, collect)
+	err := c.disk.Scan(prefix, collect)
+	c.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		if !fn(key) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Stats returns the number of items in both tiers and the size of their
+// values. Hits and misses aren't counted.
+func (c *Cache) Stats() (stats.Stats, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	mem, _ := c.memory.Stats()
+	disk, err := c.disk.Stats()
+	if err != nil {
+		return stats.Stats{}, err
+	}
+
+	return stats.Stats{Items: mem.Items + disk.Items, Bytes: mem.Bytes + disk.Bytes}, nil
+}
+
+// Snapshot isn't supported, as the items on disk are dropped when the cache is
+// created.
+func (c *Cache) Snapshot(w io.Writer) error {
+	return errors.NewUnsupported("Snapshot")
+}
+
+// Restore isn't supported, see `Snapshot()`.
+func (c *Cache) Restore(r io.Reader) error {
+	return errors.NewUnsupported("Restore")
+}
+
+// Capabilities returns what the cache supports: everything but snapshots.
+func (c *Cache) Capabilities() cacher.Capability {
+	return cacher.CanScan | cacher.CanStat | cacher.CanClose | cacher.CanPing | cacher.CanLock
+}
+
+// Close closes the disk tier and empties the memory tier.
+func (c *Cache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	err := c.disk.Close()
+	if merr := c.memory.Close(); err == nil {
+		err = merr
+	}
+
+	return err
+}
+
+// Ping checks whether the disk tier can still be used.
+func (c *Cache) Ping(ctx context.Context) error {
+	return c.disk.Ping(ctx)
+}
+
+// demote is called by the memory tier for every item it evicts. The item is
+// written to disk together with its exact expiry.
+func (c *Cache) demote(key string, value []byte, expiry time.Time) {
+	var ttl int64
+	if !expiry.IsZero() {
+		ttl = ceilSeconds(expiry.Sub(time.Now()))
+	}
+
+	c.forget(key)
+	if int64(len(value)) > c.diskLimit {
+		return
+	}
+
+	if err := c.disk.Set(key, encode(value, expiry), ttl); err != nil {
+		return
+	}
+
+	c.diskKeys = append(c.diskKeys, key)
+	c.diskSizes[key] = int64(len(value))
+	c.diskSize += int64(len(value))
+
+	for c.diskSize > c.diskLimit {
+		c.forget(c.diskKeys[0])
+	}
+}
+
+// promote moves the item from disk back into memory, if it is stored there. It
+// returns the promoted value and whether there was one.
+func (c *Cache) promote(key string) ([]byte, bool) {
+	if _, ok := c.diskSizes[key]; !ok {
+		return nil, false
+	}
+
+	data, _, err := c.disk.Get(key)
+	c.forget(key)
+	if err != nil {
+		return nil, false
+	}
+
+	value, expiry := decode(data)
+	var ttl int64
+	if !expiry.IsZero() {
+		remaining := expiry.Sub(time.Now())
+		if remaining <= 0 {
+			return nil, false
+		}
+		ttl = ceilSeconds(remaining)
+	}
+
+	c.memory.Set(key, value, ttl)
+	return value, true
+}
+
+// forget removes the item from the disk tier. It returns false if the item
+// wasn't stored on disk.
+func (c *Cache) forget(key string) bool {
+	size, ok := c.diskSizes[key]
+	if !ok {
+		return false
+	}
+
+	for i, k := range c.diskKeys {
+		if k == key {
+			c.diskKeys = append(c.diskKeys[:i], c.diskKeys[i+1:]...)
+			break
+		}
+	}
+
+	delete(c.diskSizes, key)
+	c.diskSize -= size
+	c.disk.Delete(key)
+
+	return true
+}
+
+// encode prefixes the value with its expiry in nanoseconds, 0 meaning no
+// expiry, so the expiry isn't rounded to seconds on disk.
+func encode(value []byte, expiry time.Time) []byte {
+	data := make([]byte, 8+len(value))
+	if !expiry.IsZero() {
+		binary.BigEndian.PutUint64(data, uint64(expiry.UnixNano()))
+	}
+	copy(data[8:], value)
+
+	return data
+}
+
+func decode(data []byte) ([]byte, time.Time) {
+	if len(data) < 8 {
+		return nil, time.Time{}
+	}
+
+	var expiry time.Time
+	if nsec := int64(binary.BigEndian.Uint64(data)); nsec != 0 {
+		expiry = time.Unix(0, nsec)
+	}
+
+	return data[8:], expiry
+}
+
+// ceilSeconds rounds d up to whole seconds, as that is the precision of the
+// ttl the tiers accept.
+func ceilSeconds(d time.Duration) int64 {
+	secs := int64(d / time.Second)
+	if d%time.Second != 0 {
+		secs++
+	}
+	if secs < 1 {
+		secs = 1
+	}
+
+	return secs
+}
@@ -0,0 +1,130 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package hybrid_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jelmersnoeck/cacher/bitcask"
+	"github.com/jelmersnoeck/cacher/hybrid"
+	"github.com/jelmersnoeck/cacher/memory"
+)
+
+func TestDemotion(t *testing.T) {
+	cache, cleanup := newCache(t, 10, 100)
+	defer cleanup()
+
+	cache.Set("key1", []byte("value1"), 0)
+	cache.Set("key2", []byte("value2"), 0)
+
+	if !cache.OnDisk("key1") {
+		t.Errorf("Expected `key1` to be demoted to disk.")
+		t.FailNow()
+	}
+
+	if cache.OnDisk("key2") {
+		t.Errorf("Expected `key2` to stay in memory.")
+		t.FailNow()
+	}
+}
+
+func TestPromotion(t *testing.T) {
+	cache, cleanup := newCache(t, 10, 100)
+	defer cleanup()
+
+	cache.Set("key1", []byte("value1"), 0)
+	cache.Set("key2", []byte("value2"), 0)
+
+	v, token, err := cache.Get("key1")
+	if err != nil || string(v) != "value1" {
+		t.Errorf("Expected `key1` to be read from disk, got `%s`.", v)
+		t.FailNow()
+	}
+
+	if cache.OnDisk("key1") || !cache.OnDisk("key2") {
+		t.Errorf("Expected `key1` to be promoted and `key2` to be demoted.")
+		t.FailNow()
+	}
+
+	if err := cache.CompareAndReplace(token, "key1", []byte("value3"), 0); err != nil {
+		t.Errorf("Expected the token to survive promotion, got `%s`.", err)
+		t.FailNow()
+	}
+}
+
+func TestDiskLimit(t *testing.T) {
+	cache, cleanup := newCache(t, 10, 12)
+	defer cleanup()
+
+	cache.Set("key1", []byte("value1"), 0)
+	cache.Set("key2", []byte("value2"), 0)
+	cache.Set("key3", []byte("value3"), 0)
+	cache.Set("key4", []byte("value4"), 0)
+
+	if _, _, err := cache.Get("key1"); err == nil {
+		t.Errorf("Expected `key1` to be dropped from the full disk tier.")
+		t.FailNow()
+	}
+
+	for _, key := range []string{"key2", "key3", "key4"} {
+		if _, _, err := cache.Get(key); err != nil {
+			t.Errorf("Expected `%s` to be present in one of the tiers.", key)
+			t.FailNow()
+		}
+	}
+}
+
+func TestTTLAcrossTiers(t *testing.T) {
+	cache, cleanup := newCache(t, 10, 100)
+	defer cleanup()
+
+	cache.Set("key1", []byte("value1"), 1)
+	cache.Set("key2", []byte("value2"), 2)
+	cache.Set("key3", []byte("value3"), 0)
+
+	// key1 expires while it is on disk.
+	time.Sleep(1100 * time.Millisecond)
+	if _, _, err := cache.Get("key1"); err == nil {
+		t.Errorf("Expected `key1` to expire on disk.")
+		t.FailNow()
+	}
+
+	// key2 is promoted with the time it had left.
+	if _, _, err := cache.Get("key2"); err != nil {
+		t.Errorf("Expected `key2` to be promoted before it expires.")
+		t.FailNow()
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+	if _, _, err := cache.Get("key2"); err == nil {
+		t.Errorf("Expected `key2` to keep its expiry after promotion.")
+		t.FailNow()
+	}
+}
+
+func newCache(t *testing.T, memoryLimit uintptr, diskLimit int64) (*hybrid.Cache, func()) {
+	dir, err := ioutil.TempDir("", "cacher-hybrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disk, err := bitcask.Open(dir, bitcask.Options{MergeInterval: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := hybrid.New(memory.New(memoryLimit), disk, diskLimit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return cache, func() {
+		disk.Close()
+		os.RemoveAll(dir)
+	}
+}
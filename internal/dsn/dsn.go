@@ -0,0 +1,106 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package dsn provides helper methods to read the query parameters of the URLs
+// passed to `cacher.Open()`.
+package dsn
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var units = []struct {
+	suffix string
+	size   int64
+}{
+	{"kib", 1 << 10}, {"mib", 1 << 20}, {"gib", 1 << 30}, {"tib", 1 << 40},
+	{"kb", 1 << 10}, {"mb", 1 << 20}, {"gb", 1 << 30}, {"tb", 1 << 40},
+	{"k", 1 << 10}, {"m", 1 << 20}, {"g", 1 << 30}, {"t", 1 << 40},
+	{"b", 1},
+}
+
+// ParseSize parses a number of bytes with an optional unit, like `512`, `64MB`
+// or `1GiB`. Units are powers of 1024 and are case insensitive.
+func ParseSize(s string) (int64, error) {
+	lower := strings.ToLower(strings.TrimSpace(s))
+	multiplier := int64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(lower, unit.suffix) {
+			lower = strings.TrimSpace(strings.TrimSuffix(lower, unit.suffix))
+			multiplier = unit.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseInt(lower, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size `%s`", s)
+	}
+
+	return n * multiplier, nil
+}
+
+// Size reads the named query parameter as a size, see `ParseSize()`. If the
+// parameter isn't present, 0 is returned.
+func Size(q url.Values, name string) (int64, error) {
+	if q.Get(name) == "" {
+		return 0, nil
+	}
+
+	n, err := ParseSize(q.Get(name))
+	if err != nil {
+		return 0, fmt.Errorf("%s: %s", name, err)
+	}
+
+	return n, nil
+}
+
+// Int reads the named query parameter as an integer. If the parameter isn't
+// present, 0 is returned.
+func Int(q url.Values, name string) (int, error) {
+	if q.Get(name) == "" {
+		return 0, nil
+	}
+
+	n, err := strconv.Atoi(q.Get(name))
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid number `%s`", name, q.Get(name))
+	}
+
+	return n, nil
+}
+
+// Duration reads the named query parameter as a duration, like `30s` or `5m`.
+// If the parameter isn't present, 0 is returned.
+func Duration(q url.Values, name string) (time.Duration, error) {
+	if q.Get(name) == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(q.Get(name))
+	if err != nil {
+		return 0, fmt.Errorf("%s: invalid duration `%s`", name, q.Get(name))
+	}
+
+	return d, nil
+}
+
+// Bool reads the named query parameter as a boolean. If the parameter isn't
+// present, false is returned.
+func Bool(q url.Values, name string) (bool, error) {
+	if q.Get(name) == "" {
+		return false, nil
+	}
+
+	b, err := strconv.ParseBool(q.Get(name))
+	if err != nil {
+		return false, fmt.Errorf("%s: invalid boolean `%s`", name, q.Get(name))
+	}
+
+	return b, nil
+}
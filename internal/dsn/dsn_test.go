@@ -0,0 +1,37 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package dsn_test
+
+import (
+	"testing"
+
+	"github.com/jelmersnoeck/cacher/internal/dsn"
+)
+
+func TestParseSize(t *testing.T) {
+	sizes := map[string]int64{
+		"512":   512,
+		"512b":  512,
+		"64MB":  64 << 20,
+		"64mb":  64 << 20,
+		"1GiB":  1 << 30,
+		"10k":   10 << 10,
+		" 2 TB": 2 << 40,
+	}
+
+	for s, expected := range sizes {
+		if n, err := dsn.ParseSize(s); err != nil || n != expected {
+			t.Errorf("Expected `%s` to equal %d, got %d.", s, expected, n)
+			t.FailNow()
+		}
+	}
+
+	for _, s := range []string{"", "MB", "-1MB", "1.5GB", "12XB"} {
+		if _, err := dsn.ParseSize(s); err == nil {
+			t.Errorf("Expected `%s` not to be a valid size.", s)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,21 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package encoding
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// RandomToken returns a random hex string that is practically unique, for
+// example to identify the holder of a lock.
+func RandomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,265 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package sqltest provides a fake `database/sql` driver that understands the
+// statements issued by the sql cache, so it can be tested without a database.
+//
+// The driver is registered as `cacher-fake`. Connections opened with the same
+// data source name share the same table.
+package sqltest
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// DriverName is the name the fake driver is registered under.
+const DriverName = "cacher-fake"
+
+var placeholder = regexp.MustCompile(`\$[0-9]+`)
+
+var fake = &fakeDriver{tables: make(map[string]*table)}
+
+func init() {
+	sql.Register(DriverName, fake)
+}
+
+type fakeDriver struct {
+	mu     sync.Mutex
+	tables map[string]*table
+}
+
+func (d *fakeDriver) Open(name string) (driver.Conn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	t, ok := d.tables[name]
+	if !ok {
+		t = &table{rows: make(map[string]*row)}
+		d.tables[name] = t
+	}
+
+	return &conn{table: t}, nil
+}
+
+type row struct {
+	value   []byte
+	counter interface{}
+	version int64
+	expires int64
+}
+
+func (r *row) live(now int64) bool {
+	return r.expires == 0 || r.expires > now
+}
+
+type table struct {
+	mu   sync.Mutex
+	rows map[string]*row
+}
+
+type conn struct {
+	table *table
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{table: c.table, query: placeholder.ReplaceAllString(query, "?")}, nil
+}
+
+func (c *conn) Close() error              { return nil }
+func (c *conn) Begin() (driver.Tx, error) { return tx{}, nil }
+
+// tx applies every statement immediately, which is good enough for testing.
+type tx struct{}
+
+func (tx) Commit() error   { return nil }
+func (tx) Rollback() error { return nil }
+
+type stmt struct {
+	table *table
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	_, n, err := s.run(args)
+	return driver.RowsAffected(n), err
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	res, _, err := s.run(args)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// run executes the statement against the table, returning the selected rows
+// and the number of affected rows.
+func (s *stmt) run(args []driver.Value) (*rows, int64, error) {
+	t := s.table
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	q := s.query
+	switch {
+	case strings.HasPrefix(q, "CREATE"):
+		return &rows{}, 0, nil
+
+	case strings.HasPrefix(q, "SELECT") && strings.Contains(q, " IN ("):
+		keys, now := args[:len(args)-1], args[len(args)-1].(int64)
+		res := &rows{columns: []string{"cache_key", "value", "counter", "version"}}
+		for _, k := range keys {
+			if r, ok := t.rows[k.(string)]; ok && r.live(now) {
+				res.values = append(res.values, []driver.Value{k, r.value, r.counter, r.version})
+			}
+		}
+		return res, 0, nil
+
+	case strings.HasPrefix(q, "SELECT"):
+		key, now := args[0].(string), args[1].(int64)
+		res := &rows{columns: []string{"value", "counter", "version", "expires_at"}}
+		if r, ok := t.rows[key]; ok && r.live(now) {
+			res.values = append(res.values, []driver.Value{r.value, r.counter, r.version, r.expires})
+		}
+		return res, 0, nil
+
+	case strings.HasPrefix(q, "INSERT"):
+		key := args[0].(string)
+		upsert := strings.Contains(q, "DO UPDATE") || strings.Contains(q, "ON DUPLICATE KEY")
+		r, ok := t.rows[key]
+		if ok && !upsert {
+			return &rows{}, 0, nil
+		}
+
+		if ok {
+			r.value, r.counter, r.expires = copyBytes(args[1]), args[2], args[4].(int64)
+			r.version++
+		} else {
+			t.rows[key] = &row{copyBytes(args[1]), args[2], args[3].(int64), args[4].(int64)}
+		}
+		return &rows{}, 1, nil
+
+	case strings.HasPrefix(q, "UPDATE") && strings.Contains(q, "counter = counter + ?"):
+		offset, expires, key, now := args[0].(int64), args[1].(int64), args[2].(string), args[3].(int64)
+		res := &rows{columns: []string{"counter"}}
+		r, ok := t.rows[key]
+		if !ok || !r.live(now) || r.counter == nil || r.counter.(int64)+offset < 0 {
+			return res, 0, nil
+		}
+
+		r.counter = r.counter.(int64) + offset
+		r.expires = expires
+		r.version++
+		res.values = append(res.values, []driver.Value{r.counter})
+		return res, 1, nil
+
+	case strings.HasPrefix(q, "UPDATE") && strings.Contains(q, "SET expires_at = ?"):
+		expires, key, now := args[0].(int64), args[1].(string), args[2].(int64)
+		r, ok := t.rows[key]
+		if !ok || !r.live(now) {
+			return &rows{}, 0, nil
+		}
+
+		r.expires = expires
+		return &rows{}, 1, nil
+
+	case strings.HasPrefix(q, "UPDATE"):
+		key, now := args[3].(string), args[4].(int64)
+		r, ok := t.rows[key]
+		if !ok || !r.live(now) {
+			return &rows{}, 0, nil
+		}
+
+		if strings.Contains(q, "AND version = ?") && r.version != args[5].(int64) {
+			return &rows{}, 0, nil
+		}
+
+		r.value, r.counter, r.expires = copyBytes(args[0]), args[1], args[2].(int64)
+		r.version++
+		return &rows{}, 1, nil
+
+	case strings.HasPrefix(q, "DELETE") && !strings.Contains(q, "WHERE"):
+		n := int64(len(t.rows))
+		t.rows = make(map[string]*row)
+		return &rows{}, n, nil
+
+	case strings.HasPrefix(q, "DELETE") && strings.Contains(q, "cache_key = ? AND ("):
+		key, now := args[0].(string), args[1].(int64)
+		if r, ok := t.rows[key]; ok && r.live(now) {
+			delete(t.rows, key)
+			return &rows{}, 1, nil
+		}
+		return &rows{}, 0, nil
+
+	case strings.HasPrefix(q, "DELETE") && strings.Contains(q, "cache_key = ?"):
+		key, now := args[0].(string), args[1].(int64)
+		if r, ok := t.rows[key]; ok && !r.live(now) {
+			delete(t.rows, key)
+			return &rows{}, 1, nil
+		}
+		return &rows{}, 0, nil
+
+	case strings.HasPrefix(q, "DELETE"):
+		now := args[0].(int64)
+		var n int64
+		for key, r := range t.rows {
+			if !r.live(now) {
+				delete(t.rows, key)
+				n++
+			}
+		}
+		return &rows{}, n, nil
+	}
+
+	return nil, 0, errors.New("sqltest: unsupported statement: " + q)
+}
+
+// Len returns the number of rows, including expired ones, stored for the
+// given data source name.
+func Len(name string) int {
+	fake.mu.Lock()
+	t, ok := fake.tables[name]
+	fake.mu.Unlock()
+
+	if !ok {
+		return 0
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.rows)
+}
+
+type rows struct {
+	columns []string
+	values  [][]driver.Value
+}
+
+func (r *rows) Columns() []string { return r.columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if len(r.values) == 0 {
+		return io.EOF
+	}
+
+	copy(dest, r.values[0])
+	r.values = r.values[1:]
+	return nil
+}
+
+func copyBytes(v driver.Value) []byte {
+	b, _ := v.([]byte)
+	return append([]byte(nil), b...)
+}
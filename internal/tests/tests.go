@@ -37,9 +37,10 @@ func Compare(t *testing.T, cache cacher.Cacher, key string, value interface{}) {
 }
 
 // NotPresent ensures that the given key is not present in the given cache. If
-// it is present, the test will fail and print an error message.
+// it is present, the test will fail and print an error message. A key is
+// present when Get succeeds.
 func NotPresent(t *testing.T, cache cacher.Cacher, key string) {
-	if _, _, err := cache.Get(key); err != nil {
+	if _, _, err := cache.Get(key); err == nil {
 		FailMsg(t, cache, "Expected `"+key+"` not to be present")
 	}
 }
@@ -0,0 +1,22 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package cacher
+
+import (
+	"time"
+
+	"github.com/jelmersnoeck/cacher/item"
+)
+
+// ItemGetter is implemented by caches that can return the metadata of an item,
+// such as its remaining TTL, next to its value.
+type ItemGetter interface {
+	// GetItem gets the value stored under key together with its metadata.
+	GetItem(key string) (*item.Item, error)
+
+	// TTL returns the time the item stored under key has left, or
+	// `item.NoExpiry` if it doesn't expire.
+	TTL(key string) (time.Duration, error)
+}
@@ -0,0 +1,35 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package item describes a cached item together with its metadata.
+package item
+
+import "time"
+
+// NoExpiry is the TTL of items that don't expire.
+const NoExpiry time.Duration = -1
+
+// Item is a cached value together with what the cache knows about it. Caches
+// that don't keep track of a timestamp leave it as the zero time.
+type Item struct {
+	Key   string
+	Value []byte
+	Token string
+	Flags uint32
+
+	// TTL is the time the item had left when it was read, or NoExpiry.
+	TTL time.Duration
+
+	// Expiry is the moment the item expires, or the zero time if it doesn't.
+	Expiry time.Time
+
+	// Created is when the current value was written.
+	Created time.Time
+
+	// Accessed is when the item was read before this read.
+	Accessed time.Time
+
+	// Size is the size of the value in bytes.
+	Size int
+}
@@ -0,0 +1,354 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package cacher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/expiry"
+	"github.com/jelmersnoeck/cacher/internal/encoding"
+)
+
+// fetchMagic starts the header of a value stored with a Beta. It is followed by
+// the load time and the expiry, 0 meaning none, in nanoseconds as big endian
+// int64.
+var fetchMagic = []byte{0xc0, 0x8f}
+
+const fetchHeaderSize = 18
+
+// errLoadPanicked is returned to the callers waiting for a load that panicked.
+var errLoadPanicked = fmt.Errorf("cacher: load panicked")
+
+// LoaderOptions configures a Loader.
+type LoaderOptions struct {
+	// NegativeTTL is how long an error returned by a load is returned for the
+	// same key without loading it again. Errors aren't kept if it is 0.
+	NegativeTTL time.Duration
+
+	// Lease makes the processes sharing the cache load a key one at a time.
+	// Before loading, a process takes a lease on the key, which expires after
+	// Lease in case the process dies while loading. The other processes poll
+	// the cache until the value shows up or the lease is gone. Leases aren't
+	// used if it is 0.
+	//
+	// The lease is the lock `cacher:lease:<key>` when the cache is a Locker.
+	// Otherwise it is an item by that name, added to the cache and deleted
+	// after a check that it is still held, so the cache has to implement Add
+	// atomically. Items expire in whole seconds, so such a lease lasts at
+	// least a second.
+	Lease time.Duration
+
+	// PollInterval is how often a process waiting for a lease checks the
+	// cache. Defaults to 50ms.
+	PollInterval time.Duration
+
+	// StaleTTL is how long a value is kept as a stale copy after it expires,
+	// under `cacher:stale:<key>`. A process waiting for a lease returns the
+	// stale copy instead of polling. Values without a ttl have no stale copy.
+	StaleTTL time.Duration
+
+	// Beta turns on probabilistic early expiration (XFetch). Values are stored
+	// with the time it took to load them, and a read treats a value as
+	// expired a little before its ttl, with a chance that grows as the ttl
+	// runs out and with the load time. Values that are read often are
+	// reloaded before they expire, at different times for different keys.
+	// 1 is a good default, higher values reload earlier. Values stored this
+	// way should only be read through the Loader. It is off if Beta is 0.
+	Beta float64
+}
+
+// Loader reads items through a cache, loading the items that miss:
+//
+//	loader := cacher.NewLoader(cache, cacher.LoaderOptions{})
+//	value, err := loader.GetOrLoad("user:1", 60, func() ([]byte, error) {
+//		return db.LoadUser(1)
+//	})
+//
+// Concurrent misses of the same key within a process share a single load. A
+// Loader is safe for concurrent use when its cache is.
+type Loader struct {
+	cache Cacher
+	opts  LoaderOptions
+
+	mu       sync.Mutex
+	calls    map[string]*loadCall
+	negative map[string]negativeEntry
+}
+
+type loadCall struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+type negativeEntry struct {
+	err   error
+	until time.Time
+}
+
+// NewLoader creates a new instance of Loader which reads through c.
+func NewLoader(c Cacher, opts LoaderOptions) *Loader {
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = 50 * time.Millisecond
+	}
+
+	loader := new(Loader)
+	loader.cache = c
+	loader.opts = opts
+	loader.calls = make(map[string]*loadCall)
+	loader.negative = make(map[string]negativeEntry)
+
+	return loader
+}
+
+// GetOrLoad gets the value of key from the cache. When it misses, it calls
+// load and sets the loaded value in the cache with ttl. Callers that miss the
+// same key while it is being loaded wait for that load and get its result.
+// With a Lease, that goes for the callers in other processes too.
+//
+// The cache is treated as best effort: any error reading it is a miss, and the
+// loaded value is returned even if it can't be set.
+func (l *Loader) GetOrLoad(key string, ttl int64, load func() ([]byte, error)) ([]byte, error) {
+	// A value that expires early is still valid. Only the caller that reloads
+	// it waits, and it falls back on the value when the load fails.
+	var current []byte
+	data, _, err := l.cache.Get(key)
+	if err == nil {
+		value, delta, exp := l.decode(data)
+		if !l.expireEarly(delta, exp) {
+			return value, nil
+		}
+		current = value
+	} else {
+		data = nil
+	}
+
+	l.mu.Lock()
+	if entry, ok := l.negative[key]; ok {
+		if time.Now().Before(entry.until) {
+			l.mu.Unlock()
+			if current != nil {
+				return current, nil
+			}
+			return nil, entry.err
+		}
+		delete(l.negative, key)
+	}
+
+	if call, ok := l.calls[key]; ok {
+		l.mu.Unlock()
+		if current != nil {
+			return current, nil
+		}
+		<-call.done
+		return call.value, call.err
+	}
+
+	call := &loadCall{done: make(chan struct{})}
+	l.calls[key] = call
+	l.mu.Unlock()
+
+	l.load(call, key, ttl, data, load)
+	if call.err != nil && current != nil {
+		return current, nil
+	}
+
+	return call.value, call.err
+}
+
+// load runs load for call and stores the result. seen is what the cache held
+// for key when it was read, if anything. The call is finished even if load
+// panics, so the callers waiting for it don't block forever.
+func (l *Loader) load(call *loadCall, key string, ttl int64, seen []byte, load func() ([]byte, error)) {
+	defer func() {
+		l.mu.Lock()
+		delete(l.calls, key)
+		if call.err != nil && l.opts.NegativeTTL > 0 {
+			l.remember(key, call.err)
+		}
+		l.mu.Unlock()
+
+		close(call.done)
+	}()
+
+	call.err = errLoadPanicked
+	if l.opts.Lease > 0 {
+		call.value, call.err = l.loadLeased(key, ttl, seen, load)
+		return
+	}
+
+	call.value, call.err = l.loadAndSet(key, ttl, load)
+}
+
+// loadLeased loads key once it holds the lease on it. While another process
+// holds the lease, it polls the cache for the value that process sets, unless
+// there is a stale copy to return.
+func (l *Loader) loadLeased(key string, ttl int64, seen []byte, load func() ([]byte, error)) ([]byte, error) {
+	leaseKey := "cacher:lease:" + key
+	for {
+		release, ok, err := l.lease(leaseKey)
+		if ok {
+			defer release()
+
+			// The previous holder may have set the value just before it
+			// released the lease.
+			if data, _, err := l.cache.Get(key); err == nil && !bytes.Equal(data, seen) {
+				value, _, _ := l.decode(data)
+				return value, nil
+			}
+
+			return l.loadAndSet(key, ttl, load)
+		}
+
+		// Without a working lease, loading is better than waiting.
+		if err != nil {
+			return l.loadAndSet(key, ttl, load)
+		}
+
+		if data, _, err := l.cache.Get("cacher:stale:" + key); err == nil {
+			value, _, _ := l.decode(data)
+			return value, nil
+		}
+
+		time.Sleep(l.opts.PollInterval)
+		if data, _, err := l.cache.Get(key); err == nil {
+			value, _, _ := l.decode(data)
+			return value, nil
+		}
+	}
+}
+
+// loadAndSet calls load and sets the loaded value in the cache, with a stale
+// copy if there is a StaleTTL.
+func (l *Loader) loadAndSet(key string, ttl int64, load func() ([]byte, error)) ([]byte, error) {
+	start := time.Now()
+	value, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	data := l.encode(value, time.Since(start), ttl)
+	l.cache.Set(key, data, ttl)
+	if l.opts.StaleTTL > 0 && ttl > 0 {
+		l.cache.Set("cacher:stale:"+key, data, ttl+expiry.In(l.opts.StaleTTL).Seconds())
+	}
+
+	return value, nil
+}
+
+// expireEarly reports whether a value that took delta to load and expires at
+// exp should be reloaded now, following XFetch: the value is reloaded when
+// now - delta * beta * ln(rand) reaches its expiry.
+func (l *Loader) expireEarly(delta time.Duration, exp time.Time) bool {
+	if l.opts.Beta <= 0 || exp.IsZero() {
+		return false
+	}
+
+	gap := -float64(delta) * l.opts.Beta * math.Log(1-rand.Float64())
+	return !time.Now().Add(time.Duration(gap)).Before(exp)
+}
+
+// encode prefixes value with the time it took to load and its expiry, when
+// there is a Beta. Without one, values are only prefixed when they could be
+// mistaken for a header.
+func (l *Loader) encode(value []byte, delta time.Duration, ttl int64) []byte {
+	if l.opts.Beta <= 0 {
+		if !bytes.HasPrefix(value, fetchMagic) {
+			return value
+		}
+		delta, ttl = 0, 0
+	}
+
+	var exp int64
+	if ttl > 0 {
+		exp = time.Now().Add(time.Duration(ttl) * time.Second).UnixNano()
+	}
+
+	data := make([]byte, fetchHeaderSize+len(value))
+	copy(data, fetchMagic)
+	binary.BigEndian.PutUint64(data[len(fetchMagic):], uint64(delta))
+	binary.BigEndian.PutUint64(data[len(fetchMagic)+8:], uint64(exp))
+	copy(data[fetchHeaderSize:], value)
+
+	return data
+}
+
+// decode splits data into the value, the time it took to load and its
+// expiry. The header is stripped with or without a Beta, so loaders with
+// different options can share a cache. Without a header, data is the value as
+// it is.
+func (l *Loader) decode(data []byte) ([]byte, time.Duration, time.Time) {
+	if len(data) < fetchHeaderSize || !bytes.HasPrefix(data, fetchMagic) {
+		return data, 0, time.Time{}
+	}
+
+	delta := time.Duration(binary.BigEndian.Uint64(data[len(fetchMagic):]))
+
+	var exp time.Time
+	if nsec := int64(binary.BigEndian.Uint64(data[len(fetchMagic)+8:])); nsec != 0 {
+		exp = time.Unix(0, nsec)
+	}
+
+	return data[fetchHeaderSize:], delta, exp
+}
+
+// lease takes the lease named leaseKey and returns the function that releases
+// it. It returns false if another process holds the lease.
+func (l *Loader) lease(leaseKey string) (func(), bool, error) {
+	if Capabilities(l.cache).Has(CanLock) {
+		locker := l.cache.(Locker)
+		token, ok, err := locker.TryLock(leaseKey, l.opts.Lease)
+		if !ok || err != nil {
+			return nil, false, err
+		}
+
+		return func() { locker.Unlock(leaseKey, token) }, true, nil
+	}
+
+	token, err := encoding.RandomToken()
+	if err != nil {
+		return nil, false, err
+	}
+
+	err = l.cache.Add(leaseKey, []byte(token), expiry.In(l.opts.Lease).Seconds())
+	if errors.Is(err, errors.ErrExists) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return func() { l.release(leaseKey, token) }, true, nil
+}
+
+// release gives up a lease held as an item, unless it has expired and been
+// taken by another process in the meantime. Caches that aren't a Locker can't
+// check and delete the item at once, so a lease taken between the two is
+// released too.
+func (l *Loader) release(leaseKey, token string) {
+	if value, _, err := l.cache.Get(leaseKey); err == nil && string(value) == token {
+		l.cache.Delete(leaseKey)
+	}
+}
+
+// remember keeps err as the result for key for the negative ttl, dropping the
+// errors that have expired. It is called with mu held.
+func (l *Loader) remember(key string, err error) {
+	now := time.Now()
+	for k, entry := range l.negative {
+		if !now.Before(entry.until) {
+			delete(l.negative, k)
+		}
+	}
+
+	l.negative[key] = negativeEntry{err: err, until: now.Add(l.opts.NegativeTTL)}
+}
@@ -0,0 +1,369 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package cacher_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/internal/tests"
+	"github.com/jelmersnoeck/cacher/memory"
+	"github.com/jelmersnoeck/cacher/noop"
+	rcache "github.com/jelmersnoeck/cacher/redis"
+)
+
+func TestGetOrLoad(t *testing.T) {
+	for _, cache := range testDrivers() {
+		loader := cacher.NewLoader(cache, cacher.LoaderOptions{})
+
+		var loads int
+		load := func() ([]byte, error) {
+			loads++
+			return []byte("loaded"), nil
+		}
+
+		for i := 0; i < 3; i++ {
+			value, err := loader.GetOrLoad("key1", 0, load)
+			if err != nil || string(value) != "loaded" {
+				tests.FailMsg(t, cache, "Expecting `loaded`, got `%s` %v.", value, err)
+			}
+		}
+
+		if loads != 1 {
+			tests.FailMsg(t, cache, "Expecting a single load, got %d.", loads)
+		}
+		tests.Compare(t, cache, "key1", "loaded")
+
+		cache.Set("key2", []byte("cached"), 0)
+		if value, _ := loader.GetOrLoad("key2", 0, load); string(value) != "cached" {
+			tests.FailMsg(t, cache, "Expecting the cached value, got `%s`.", value)
+		}
+	}
+}
+
+func TestGetOrLoadConcurrent(t *testing.T) {
+	cache := noop.NewRecorder()
+	loader := cacher.NewLoader(cache, cacher.LoaderOptions{})
+
+	var loads int32
+	started := make(chan struct{})
+	release := make(chan struct{})
+	load := func() ([]byte, error) {
+		if atomic.AddInt32(&loads, 1) == 1 {
+			close(started)
+		}
+		<-release
+		return []byte("loaded"), nil
+	}
+
+	const n = 50
+	var wg sync.WaitGroup
+	values := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = loader.GetOrLoad("key1", 0, load)
+		}(i)
+	}
+
+	// Give the other callers time to join the load before it finishes.
+	<-started
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if loads != 1 {
+		t.Errorf("Expecting %d concurrent misses to load once, got %d loads.", n, loads)
+	}
+
+	for i, value := range values {
+		if string(value) != "loaded" {
+			t.Errorf("Expecting caller %d to get `loaded`, got `%s`.", i, value)
+		}
+	}
+
+	if sets := cache.CallsTo("Set"); len(sets) != 1 {
+		t.Errorf("Expecting the value to be set once, got %d.", len(sets))
+	}
+}
+
+func TestGetOrLoadError(t *testing.T) {
+	loadErr := fmt.Errorf("database is down")
+
+	var loads int
+	load := func() ([]byte, error) {
+		loads++
+		return nil, loadErr
+	}
+
+	loader := cacher.NewLoader(noop.New(), cacher.LoaderOptions{})
+	loader.GetOrLoad("key1", 0, load)
+	if _, err := loader.GetOrLoad("key1", 0, load); err != loadErr || loads != 2 {
+		t.Errorf("Expecting errors not to be kept, got %v after %d loads.", err, loads)
+	}
+
+	loads = 0
+	loader = cacher.NewLoader(noop.New(), cacher.LoaderOptions{NegativeTTL: 50 * time.Millisecond})
+	loader.GetOrLoad("key1", 0, load)
+	if _, err := loader.GetOrLoad("key1", 0, load); err != loadErr || loads != 1 {
+		t.Errorf("Expecting the error to be kept, got %v after %d loads.", err, loads)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	loader.GetOrLoad("key1", 0, load)
+	if loads != 2 {
+		t.Errorf("Expecting the error to expire, got %d loads.", loads)
+	}
+}
+
+func TestGetOrLoadPanic(t *testing.T) {
+	loader := cacher.NewLoader(noop.New(), cacher.LoaderOptions{})
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Expecting the panic to be passed on.")
+			}
+		}()
+
+		loader.GetOrLoad("key1", 0, func() ([]byte, error) {
+			panic("boom")
+		})
+	}()
+
+	value, err := loader.GetOrLoad("key1", 0, func() ([]byte, error) {
+		return []byte("loaded"), nil
+	})
+	if err != nil || string(value) != "loaded" {
+		t.Errorf("Expecting the key to load after a panic, got `%s` %v.", value, err)
+	}
+}
+
+func TestGetOrLoadLease(t *testing.T) {
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) {
+		return redis.Dial("tcp", ":6379")
+	}}
+	cache := rcache.NewPool(pool, rcache.Options{Prefix: "lease:"})
+	cache.Flush()
+
+	var loads int32
+	load := func() ([]byte, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(100 * time.Millisecond)
+		return []byte("loaded"), nil
+	}
+
+	// Every loader stands in for a process sharing the cache.
+	const n = 20
+	var wg sync.WaitGroup
+	values := make([][]byte, n)
+	for i := 0; i < n; i++ {
+		loader := cacher.NewLoader(cache, cacher.LoaderOptions{
+			Lease:        time.Second,
+			PollInterval: 10 * time.Millisecond,
+		})
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], _ = loader.GetOrLoad("key1", 60, load)
+		}(i)
+	}
+	wg.Wait()
+
+	if loads != 1 {
+		t.Errorf("Expecting %d processes to load once, got %d loads.", n, loads)
+	}
+
+	for i, value := range values {
+		if string(value) != "loaded" {
+			t.Errorf("Expecting process %d to get `loaded`, got `%s`.", i, value)
+		}
+	}
+
+	if _, ok, _ := cache.TryLock("cacher:lease:key1", time.Second); !ok {
+		t.Errorf("Expecting the lease to be released.")
+	}
+}
+
+func TestGetOrLoadLeaseTimeout(t *testing.T) {
+	cache := memory.New(0)
+	loader := cacher.NewLoader(cache, cacher.LoaderOptions{
+		Lease:        time.Second,
+		PollInterval: 10 * time.Millisecond,
+	})
+
+	// A process that died while loading leaves its lease behind.
+	cache.TryLock("cacher:lease:key1", 300*time.Millisecond)
+
+	start := time.Now()
+	value, err := loader.GetOrLoad("key1", 60, func() ([]byte, error) {
+		return []byte("loaded"), nil
+	})
+	if err != nil || string(value) != "loaded" {
+		t.Errorf("Expecting `loaded`, got `%s` %v.", value, err)
+	}
+
+	if waited := time.Since(start); waited < 250*time.Millisecond || waited > 900*time.Millisecond {
+		t.Errorf("Expecting to wait for the lease to expire, waited %s.", waited)
+	}
+}
+
+func TestGetOrLoadStale(t *testing.T) {
+	// The cache isn't a Locker, so leases are items.
+	cache := struct{ cacher.Cacher }{memory.New(0)}
+	loader := cacher.NewLoader(cache, cacher.LoaderOptions{
+		Lease:    time.Second,
+		StaleTTL: time.Minute,
+	})
+
+	var loads int
+	load := func() ([]byte, error) {
+		loads++
+		return []byte(fmt.Sprintf("value%d", loads)), nil
+	}
+
+	loader.GetOrLoad("key1", 1, load)
+	time.Sleep(1100 * time.Millisecond)
+	tests.NotPresent(t, cache, "key1")
+
+	// Another process is loading the key, so the stale copy is returned.
+	cache.Add("cacher:lease:key1", []byte("other"), 1)
+	if value, _ := loader.GetOrLoad("key1", 1, load); string(value) != "value1" || loads != 1 {
+		t.Errorf("Expecting the stale value1, got `%s` after %d loads.", value, loads)
+	}
+
+	cache.Delete("cacher:lease:key1")
+	if value, _ := loader.GetOrLoad("key1", 1, load); string(value) != "value2" {
+		t.Errorf("Expecting a fresh value2, got `%s`.", value)
+	}
+}
+
+func TestGetOrLoadXFetch(t *testing.T) {
+	cache := memory.New(0)
+
+	var loads int
+	load := func() ([]byte, error) {
+		loads++
+		time.Sleep(time.Millisecond)
+		return []byte(fmt.Sprintf("value%d", loads)), nil
+	}
+
+	// A small beta leaves values until they're close to their expiry.
+	loader := cacher.NewLoader(cache, cacher.LoaderOptions{Beta: 1})
+	for i := 0; i < 10; i++ {
+		if value, _ := loader.GetOrLoad("key1", 60, load); string(value) != "value1" {
+			t.Errorf("Expecting value1, got `%s`.", value)
+		}
+	}
+
+	// A huge beta reloads values long before they expire.
+	loader = cacher.NewLoader(cache, cacher.LoaderOptions{Beta: 1e9})
+	for i := 0; i < 10; i++ {
+		loader.GetOrLoad("key2", 60, load)
+	}
+	if loads < 5 {
+		t.Errorf("Expecting values to be reloaded early, got %d loads.", loads)
+	}
+
+	// When an early reload fails, the value is still valid.
+	value, err := loader.GetOrLoad("key2", 60, func() ([]byte, error) {
+		return nil, fmt.Errorf("database is down")
+	})
+	if err != nil || string(value) != fmt.Sprintf("value%d", loads) {
+		t.Errorf("Expecting the current value, got `%s` %v.", value, err)
+	}
+
+	// A loader without a Beta reads the values of one with a Beta.
+	loader = cacher.NewLoader(cache, cacher.LoaderOptions{})
+	if value, _ := loader.GetOrLoad("key1", 60, load); string(value) != "value1" {
+		t.Errorf("Expecting value1 without its header, got %q.", value)
+	}
+
+	// Values that look like a header are kept as they are.
+	header := string([]byte{0xc0, 0x8f}) + "12345678abcdefghvalue"
+	loader.GetOrLoad("key3", 60, func() ([]byte, error) {
+		return []byte(header), nil
+	})
+	if value, _ := loader.GetOrLoad("key3", 60, load); string(value) != header {
+		t.Errorf("Expecting the value as it was loaded, got %q.", value)
+	}
+}
+
+// TestGetOrLoadXFetchSimulation reads a set of keys that were loaded at the same
+// time, one reader per key, across their expiry. Without XFetch, they all
+// expire and reload at once. With it, they're reloaded before their expiry at
+// different times, so fewer reloads run at the same time.
+func TestGetOrLoadXFetchSimulation(t *testing.T) {
+	without := simulateReloads(0)
+	with := simulateReloads(2)
+
+	t.Logf("Concurrent reloads: %d without XFetch, %d with XFetch.", without, with)
+	if with*2 > without {
+		t.Errorf("Expecting XFetch to at least halve the concurrent reloads, got %d and %d.", without, with)
+	}
+}
+
+// simulateReloads returns the largest number of reloads that ran at the same
+// time, after the keys were first loaded together.
+func simulateReloads(beta float64) int32 {
+	pool := &redis.Pool{MaxIdle: 64, Dial: func() (redis.Conn, error) {
+		return redis.Dial("tcp", ":6379")
+	}}
+	defer pool.Close()
+	cache := rcache.NewPool(pool, rcache.Options{Prefix: "xfetch:"})
+	cache.Flush()
+	loader := cacher.NewLoader(cache, cacher.LoaderOptions{Beta: beta})
+
+	const keys = 50
+	var measure int32
+	var running, peak int32
+	load := func() ([]byte, error) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+
+		for atomic.LoadInt32(&measure) == 1 {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+
+		time.Sleep(20 * time.Millisecond)
+		return []byte("value"), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < keys; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			loader.GetOrLoad(key, 1, load)
+		}(fmt.Sprintf("key%d", i))
+	}
+	wg.Wait()
+	atomic.StoreInt32(&measure, 1)
+
+	deadline := time.Now().Add(1500 * time.Millisecond)
+	for i := 0; i < keys; i++ {
+		wg.Add(1)
+		go func(key string) {
+			defer wg.Done()
+			for time.Now().Before(deadline) {
+				loader.GetOrLoad(key, 1, load)
+				time.Sleep(5 * time.Millisecond)
+			}
+		}(fmt.Sprintf("key%d", i))
+	}
+	wg.Wait()
+
+	return atomic.LoadInt32(&peak)
+}
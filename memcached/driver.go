@@ -0,0 +1,59 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package memcached
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/internal/dsn"
+)
+
+func init() {
+	cacher.Register("memcached", open)
+}
+
+// open creates a cache from a URL like
+// `memcached://a:11211,b:11211?timeout=100ms&pool=20`. The host lists the
+// servers, which default to port 11211. `timeout` sets the read and write
+// timeout and `pool` the number of idle connections kept per server.
+func open(u *url.URL) (cacher.Cacher, error) {
+	var servers []string
+	for _, server := range strings.Split(u.Host, ",") {
+		if server == "" {
+			continue
+		}
+		if !strings.Contains(server, ":") {
+			server += ":11211"
+		}
+		servers = append(servers, server)
+	}
+
+	if len(servers) == 0 {
+		servers = []string{"localhost:11211"}
+	}
+
+	ss := new(memcache.ServerList)
+	if err := ss.SetServers(servers...); err != nil {
+		return nil, fmt.Errorf("memcached: %s", err)
+	}
+
+	q := u.Query()
+	client := memcache.NewFromSelector(ss)
+
+	var err error
+	if client.Timeout, err = dsn.Duration(q, "timeout"); err != nil {
+		return nil, fmt.Errorf("memcached: %s", err)
+	}
+
+	if client.MaxIdleConns, err = dsn.Int(q, "pool"); err != nil {
+		return nil, fmt.Errorf("memcached: %s", err)
+	}
+
+	return New(client), nil
+}
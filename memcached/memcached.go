@@ -0,0 +1,318 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package memcached provides a cache that stores its items on one or more
+// memcached servers, through the `github.com/bradfitz/gomemcache/memcache`
+// client.
+package memcached
+
+import (
+	"context"
+	"time"
+
+	"github.com/bradfitz/gomemcache/memcache"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/internal/encoding"
+)
+
+// maxRelative is the longest ttl memcached takes as a number of seconds. Longer
+// ttls are sent as the unix time of the expiry.
+const maxRelative = 30 * 24 * 60 * 60
+
+// Cache is a caching implementation that stores the data in memcached. Items
+// carry their flags natively, so it implements `cacher.FlagCacher`.
+type Cache struct {
+	client *memcache.Client
+}
+
+// New creates a new instance of Cache which stores its items through client. The
+// keys are spread over the servers of the client. It is safe for concurrent
+// use.
+func New(client *memcache.Client) *Cache {
+	cache := new(Cache)
+	cache.client = client
+
+	return cache
+}
+
+// Add an item to the cache. If the item is already cached, the value won't be
+// overwritten.
+//
+// ttl defines the number of seconds the value should be cached. If ttl is 0,
+// the item will be cached infinitely.
+func (c *Cache) Add(key string, value []byte, ttl int64) error {
+	return c.AddWithFlags(key, value, 0, ttl)
+}
+
+// AddWithFlags is `Add()` with flags.
+func (c *Cache) AddWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	err := c.client.Add(item(key, value, flags, ttl))
+	if err == memcache.ErrNotStored {
+		return errors.NewAlreadyExistingKey(key)
+	}
+
+	return convert(key, err)
+}
+
+// Set sets the value of an item, regardless of wether or not the value is
+// already cached.
+//
+// ttl defines the number of seconds the value should be cached. If ttl is 0,
+// the item will be cached infinitely.
+func (c *Cache) Set(key string, value []byte, ttl int64) error {
+	return c.SetWithFlags(key, value, 0, ttl)
+}
+
+// SetWithFlags is `Set()` with flags.
+func (c *Cache) SetWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	return convert(key, c.client.Set(item(key, value, flags, ttl)))
+}
+
+// SetMulti sets multiple values for their respective keys. This is a shorthand
+// to use `Set` multiple times.
+func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	results := make(map[string]error)
+	for key, value := range items {
+		results[key] = c.Set(key, value, ttl)
+	}
+
+	return results
+}
+
+// Replace will update and only update the value of a cache key. If the key is
+// not previously used, we will return false.
+func (c *Cache) Replace(key string, value []byte, ttl int64) error {
+	return c.ReplaceWithFlags(key, value, 0, ttl)
+}
+
+// ReplaceWithFlags is `Replace()` with flags.
+func (c *Cache) ReplaceWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	err := c.client.Replace(item(key, value, flags, ttl))
+	if err == memcache.ErrNotStored {
+		return errors.NewNotFound(key)
+	}
+
+	return convert(key, err)
+}
+
+// CompareAndReplace validates the token with the token in the store. If the
+// tokens match, we will replace the value and return true. If it doesn't, we
+// will not replace the value and return false.
+func (c *Cache) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	return c.CompareAndReplaceWithFlags(token, key, value, 0, ttl)
+}
+
+// CompareAndReplaceWithFlags is `CompareAndReplace()` with flags. The item is
+// read to check the token and written back with the CAS id it was read with, so
+// the write fails if the item changes in the meantime.
+func (c *Cache) CompareAndReplaceWithFlags(token, key string, value []byte, flags uint32, ttl int64) error {
+	it, err := c.client.Get(key)
+	if err != nil {
+		return convert(key, err)
+	}
+
+	if encoding.Md5Sum(it.Value) != token {
+		return errors.NewCASConflict(key)
+	}
+
+	it.Value, it.Flags, it.Expiration = value, flags, expiration(ttl)
+	return c.compareAndSwap(key, it)
+}
+
+// Get gets the value out of the cache associated with the provided key.
+func (c *Cache) Get(key string) ([]byte, string, error) {
+	value, token, _, err := c.GetWithFlags(key)
+	return value, token, err
+}
+
+// GetWithFlags is `Get()` that also returns the flags of the item.
+func (c *Cache) GetWithFlags(key string) ([]byte, string, uint32, error) {
+	it, err := c.client.Get(key)
+	if err != nil {
+		return nil, "", 0, convert(key, err)
+	}
+
+	return it.Value, encoding.Md5Sum(it.Value), it.Flags, nil
+}
+
+// GetMulti gets multiple values from the cache and returns them as a map. The
+// keys are fetched with a single request per server.
+func (c *Cache) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	items, tokens, _, errs := c.GetMultiWithFlags(keys)
+	return items, tokens, errs
+}
+
+// GetMultiWithFlags is `GetMulti()` that also returns the flags of the items.
+func (c *Cache) GetMultiWithFlags(keys []string) (map[string][]byte, map[string]string, map[string]uint32, map[string]error) {
+	items := make(map[string][]byte)
+	tokens := make(map[string]string)
+	flags := make(map[string]uint32)
+	errs := make(map[string]error)
+
+	found, err := c.client.GetMulti(keys)
+	for _, key := range keys {
+		it, ok := found[key]
+		switch {
+		case err != nil:
+			errs[key] = err
+		case !ok:
+			errs[key] = errors.NewNotFound(key)
+		default:
+			items[key], tokens[key], flags[key], errs[key] = it.Value, encoding.Md5Sum(it.Value), it.Flags, nil
+		}
+	}
+
+	return items, tokens, flags, errs
+}
+
+// Increment adds a value of offset to the initial value. If the initial value
+// is already set, it will be added to the value currently stored in the cache.
+//
+// Initial value and offset can't be below 0.
+func (c *Cache) Increment(key string, initial, offset, ttl int64) error {
+	if initial < 0 || offset <= 0 {
+		return errors.NewInvalidRange(initial, offset)
+	}
+
+	return c.incrementOffset(key, initial, offset, ttl)
+}
+
+// Decrement subtracts a value of offset to the initial value. If the initial
+// value is already set, it will be added to the value currently stored in the
+// cache.
+//
+// Initial value and offset can't be below 0.
+func (c *Cache) Decrement(key string, initial, offset, ttl int64) error {
+	if initial < 0 || offset <= 0 {
+		return errors.NewInvalidRange(initial, offset)
+	}
+
+	return c.incrementOffset(key, initial, offset*-1, ttl)
+}
+
+// Flush removes all the items from every server of the client, including the
+// items that were not written through this cache.
+func (c *Cache) Flush() error {
+	return c.client.FlushAll()
+}
+
+// Delete will validate if the key actually is stored in the cache. If it is
+// stored, it will remove the item from the cache. If it is not stored, it will
+// return false.
+func (c *Cache) Delete(key string) error {
+	return convert(key, c.client.Delete(key))
+}
+
+// DeleteMulti will delete multiple values at a time. It uses the `Delete`
+// method internally to do so. It will return a map of results to see if the
+// deletion is successful.
+func (c *Cache) DeleteMulti(keys []string) map[string]error {
+	results := make(map[string]error)
+	for _, key := range keys {
+		results[key] = c.Delete(key)
+	}
+
+	return results
+}
+
+// Touch will update the key's ttl to the given ttl value without altering the
+// value.
+func (c *Cache) Touch(key string, ttl int64) error {
+	return convert(key, c.client.Touch(key, expiration(ttl)))
+}
+
+// Close closes the idle connections of the client.
+func (c *Cache) Close() error {
+	return c.client.Close()
+}
+
+// Ping checks whether every server of the client can be reached.
+func (c *Cache) Ping(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.client.Ping()
+}
+
+// incrementOffset is a common incrementor method used between Increment and
+// Decrement. If the key isn't set before, we will set the initial value. If
+// there is a value present, we will add the given offset to that value and
+// update the value with the new TTL.
+//
+// memcached's own `incr` and `decr` can't keep the ttl up to date or refuse to
+// go below zero, so the counter is read and written back with its CAS id. When
+// it changes in the meantime, the increment is tried again.
+func (c *Cache) incrementOffset(key string, initial, offset, ttl int64) error {
+	for {
+		it, err := c.client.Get(key)
+		if err == memcache.ErrCacheMiss {
+			err = c.client.Add(item(key, encoding.Int64Bytes(initial), 0, ttl))
+			if err == memcache.ErrNotStored {
+				continue
+			}
+			return convert(key, err)
+		} else if err != nil {
+			return err
+		}
+
+		val, ok := encoding.BytesInt64(it.Value)
+		if !ok {
+			return errors.NewEncoding(key)
+		}
+
+		val += offset
+		if val < 0 {
+			return errors.NewValueBelowZero(key)
+		}
+
+		it.Value, it.Expiration = encoding.Int64Bytes(val), expiration(ttl)
+		err = c.compareAndSwap(key, it)
+		if !errors.Is(err, errors.ErrCASConflict) && !errors.Is(err, errors.ErrNotFound) {
+			return err
+		}
+	}
+}
+
+// compareAndSwap writes it back, unless it changed or was removed since it was
+// read.
+func (c *Cache) compareAndSwap(key string, it *memcache.Item) error {
+	switch err := c.client.CompareAndSwap(it); err {
+	case memcache.ErrCASConflict:
+		return errors.NewCASConflict(key)
+	case memcache.ErrNotStored, memcache.ErrCacheMiss:
+		return errors.NewNotFound(key)
+	default:
+		return err
+	}
+}
+
+// item creates the memcache item for key.
+func item(key string, value []byte, flags uint32, ttl int64) *memcache.Item {
+	return &memcache.Item{Key: key, Value: value, Flags: flags, Expiration: expiration(ttl)}
+}
+
+// expiration converts ttl into the expiration memcached takes: a number of
+// seconds up to 30 days, the unix time of the expiry beyond that. A negative
+// ttl expires the item straight away.
+func expiration(ttl int64) int32 {
+	if ttl > maxRelative {
+		return int32(time.Now().Unix() + ttl)
+	}
+
+	if ttl < 0 {
+		return -1
+	}
+
+	return int32(ttl)
+}
+
+// convert turns a cache miss of the client into an errors.NotFound.
+func convert(key string, err error) error {
+	if err == memcache.ErrCacheMiss {
+		return errors.NewNotFound(key)
+	}
+
+	return err
+}
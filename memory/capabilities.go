@@ -0,0 +1,178 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package memory
+
+import (
+	"context"
+	"encoding/gob"
+	"io"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/internal/encoding"
+	"github.com/jelmersnoeck/cacher/stats"
+)
+
+type lock struct {
+	token  string
+	expiry time.Time
+}
+
+// snapshotItem is how an item is written to a snapshot.
+type snapshotItem struct {
+	Key     string
+	Value   []byte
+	Expiry  time.Time
+	Expire  bool
+	Flags   uint32
+	Tags    []string
+	Created time.Time
+}
+
+// Scan calls fn for every key that starts with prefix and hasn't expired, from
+// the least to the most recently used, until fn returns false.
+func (c *Cache) Scan(prefix string, fn func(key string) bool) error {
+	keys := make([]string, len(c.keys))
+	copy(keys, c.keys)
+
+	now := time.Now()
+	for _, key := range keys {
+		cached, ok := c.items[key]
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+
+		if cached.expire && !now.Before(cached.expiry) {
+			continue
+		}
+
+		if !fn(key) {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Stats returns the number of items in the cache, the size of their values and
+// the number of hits and misses of `Get()` and `GetItem()`. Expired items are
+// counted until they are read or evicted.
+func (c *Cache) Stats() (stats.Stats, error) {
+	return stats.Stats{
+		Items:  int64(len(c.items)),
+		Bytes:  int64(c.size),
+		Hits:   atomic.LoadInt64(&c.hits),
+		Misses: atomic.LoadInt64(&c.misses),
+	}, nil
+}
+
+// Close empties the cache, so the memory it holds can be reclaimed. The cache
+// can still be used afterwards.
+func (c *Cache) Close() error {
+	return c.Flush()
+}
+
+// Ping reports whether the cache can be used. A memory cache is always
+// available, so this only returns the error of ctx.
+func (c *Cache) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
+
+// TryLock takes the lock named key for ttl, unless it is held already. Locks
+// are kept apart from the items, so key can be the key of an item.
+func (c *Cache) TryLock(key string, ttl time.Duration) (string, bool, error) {
+	c.locksMu.Lock()
+	defer c.locksMu.Unlock()
+
+	if held, ok := c.locks[key]; ok && time.Now().Before(held.expiry) {
+		return "", false, nil
+	}
+
+	token, err := encoding.RandomToken()
+	if err != nil {
+		return "", false, err
+	}
+
+	c.locks[key] = lock{token: token, expiry: time.Now().Add(ttl)}
+	return token, true, nil
+}
+
+// Unlock releases the lock named key if it is still held with token. If the
+// lock has expired or is held by someone else, it returns an
+// `errors.NotFound`.
+func (c *Cache) Unlock(key, token string) error {
+	c.locksMu.Lock()
+	defer c.locksMu.Unlock()
+
+	held, ok := c.locks[key]
+	if !ok || held.token != token || !time.Now().Before(held.expiry) {
+		return errors.NewNotFound(key)
+	}
+
+	delete(c.locks, key)
+	return nil
+}
+
+// Snapshot writes all the items that haven't expired to w, together with their
+// expiry, flags and tags.
+func (c *Cache) Snapshot(w io.Writer) error {
+	items := make([]snapshotItem, 0, len(c.keys))
+	now := time.Now()
+	for _, key := range c.keys {
+		cached := c.items[key]
+		if cached.expire && !now.Before(cached.expiry) {
+			continue
+		}
+
+		items = append(items, snapshotItem{
+			Key:     key,
+			Value:   cached.value,
+			Expiry:  cached.expiry,
+			Expire:  cached.expire,
+			Flags:   cached.flags,
+			Tags:    cached.tags,
+			Created: cached.created,
+		})
+	}
+
+	return gob.NewEncoder(w).Encode(items)
+}
+
+// Restore replaces the items in the cache with the ones written by
+// `Snapshot()`. Items that have expired since are skipped, and the limit of
+// the cache applies as it does for `Set()`.
+func (c *Cache) Restore(r io.Reader) error {
+	var items []snapshotItem
+	if err := gob.NewDecoder(r).Decode(&items); err != nil {
+		return err
+	}
+
+	c.Flush()
+
+	now := time.Now()
+	for _, it := range items {
+		if it.Expire && !now.Before(it.Expiry) {
+			continue
+		}
+
+		c.items[it.Key] = &cachedItem{
+			value:    it.Value,
+			expiry:   it.Expiry,
+			expire:   it.Expire,
+			token:    encoding.Md5Sum(it.Value),
+			flags:    it.Flags,
+			tags:     it.Tags,
+			created:  it.Created,
+			accessed: now,
+		}
+		c.keys = append(c.keys, it.Key)
+		c.size += uintptr(len(it.Value))
+	}
+
+	c.evict()
+	return nil
+}
@@ -0,0 +1,133 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package memory
+
+import "context"
+
+// The memory cache never blocks, so the context only needs to be checked before
+// an operation starts. Operations on multiple keys check it for every key.
+
+// AddCtx is `Add()` with a context.
+func (c *Cache) AddCtx(ctx context.Context, key string, value []byte, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.Add(key, value, ttl)
+}
+
+// CompareAndReplaceCtx is `CompareAndReplace()` with a context.
+func (c *Cache) CompareAndReplaceCtx(ctx context.Context, token, key string, value []byte, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.CompareAndReplace(token, key, value, ttl)
+}
+
+// SetCtx is `Set()` with a context.
+func (c *Cache) SetCtx(ctx context.Context, key string, value []byte, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.Set(key, value, ttl)
+}
+
+// SetMultiCtx is `SetMulti()` with a context.
+func (c *Cache) SetMultiCtx(ctx context.Context, items map[string][]byte, ttl int64) map[string]error {
+	results := make(map[string]error)
+	for key, value := range items {
+		results[key] = c.SetCtx(ctx, key, value, ttl)
+	}
+
+	return results
+}
+
+// ReplaceCtx is `Replace()` with a context.
+func (c *Cache) ReplaceCtx(ctx context.Context, key string, value []byte, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.Replace(key, value, ttl)
+}
+
+// IncrementCtx is `Increment()` with a context.
+func (c *Cache) IncrementCtx(ctx context.Context, key string, initial, offset, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.Increment(key, initial, offset, ttl)
+}
+
+// DecrementCtx is `Decrement()` with a context.
+func (c *Cache) DecrementCtx(ctx context.Context, key string, initial, offset, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.Decrement(key, initial, offset, ttl)
+}
+
+// DeleteCtx is `Delete()` with a context.
+func (c *Cache) DeleteCtx(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.Delete(key)
+}
+
+// DeleteMultiCtx is `DeleteMulti()` with a context.
+func (c *Cache) DeleteMultiCtx(ctx context.Context, keys []string) map[string]error {
+	results := make(map[string]error)
+	for _, key := range keys {
+		results[key] = c.DeleteCtx(ctx, key)
+	}
+
+	return results
+}
+
+// GetCtx is `Get()` with a context.
+func (c *Cache) GetCtx(ctx context.Context, key string) ([]byte, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, "", err
+	}
+
+	return c.Get(key)
+}
+
+// GetMultiCtx is `GetMulti()` with a context.
+func (c *Cache) GetMultiCtx(ctx context.Context, keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	items := make(map[string][]byte)
+	errs := make(map[string]error)
+	tokens := make(map[string]string)
+
+	for _, k := range keys {
+		items[k], tokens[k], errs[k] = c.GetCtx(ctx, k)
+	}
+
+	return items, tokens, errs
+}
+
+// FlushCtx is `Flush()` with a context.
+func (c *Cache) FlushCtx(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.Flush()
+}
+
+// TouchCtx is `Touch()` with a context.
+func (c *Cache) TouchCtx(ctx context.Context, key string, ttl int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.Touch(key, ttl)
+}
@@ -0,0 +1,55 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package memory
+
+import "github.com/jelmersnoeck/cacher/expiry"
+
+// Every item carries flags, which are 0 for items written without them. This
+// includes the counters written by Increment and Decrement.
+
+// AddWithFlags is `Add()` that stores flags with the item.
+func (c *Cache) AddWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	return c.add(key, value, flags, expiry.FromSeconds(ttl))
+}
+
+// SetWithFlags is `Set()` that stores flags with the item.
+func (c *Cache) SetWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	return c.set(key, value, flags, expiry.FromSeconds(ttl))
+}
+
+// ReplaceWithFlags is `Replace()` that stores flags with the item.
+func (c *Cache) ReplaceWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	return c.replace(key, value, flags, expiry.FromSeconds(ttl))
+}
+
+// CompareAndReplaceWithFlags is `CompareAndReplace()` that stores flags with
+// the item.
+func (c *Cache) CompareAndReplaceWithFlags(token, key string, value []byte, flags uint32, ttl int64) error {
+	return c.compareAndReplace(token, key, value, flags, expiry.FromSeconds(ttl))
+}
+
+// GetWithFlags is `Get()` that also returns the flags of the item.
+func (c *Cache) GetWithFlags(key string) ([]byte, string, uint32, error) {
+	value, token, err := c.Get(key)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	return value, token, c.items[key].flags, nil
+}
+
+// GetMultiWithFlags is `GetMulti()` that also returns the flags of the items.
+func (c *Cache) GetMultiWithFlags(keys []string) (map[string][]byte, map[string]string, map[string]uint32, map[string]error) {
+	items := make(map[string][]byte)
+	tokens := make(map[string]string)
+	flags := make(map[string]uint32)
+	errs := make(map[string]error)
+
+	for _, k := range keys {
+		items[k], tokens[k], flags[k], errs[k] = c.GetWithFlags(k)
+	}
+
+	return items, tokens, flags, errs
+}
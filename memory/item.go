@@ -0,0 +1,57 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package memory
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/jelmersnoeck/cacher/item"
+)
+
+// GetItem gets the value associated with the provided key together with its
+// metadata.
+func (c *Cache) GetItem(key string) (*item.Item, error) {
+	if err := c.exists(key); err != nil {
+		atomic.AddInt64(&c.misses, 1)
+		return nil, err
+	}
+
+	atomic.AddInt64(&c.hits, 1)
+	cached := c.items[key]
+	it := &item.Item{
+		Key:      key,
+		Value:    cached.value,
+		Token:    cached.token,
+		Flags:    cached.flags,
+		TTL:      item.NoExpiry,
+		Created:  cached.created,
+		Accessed: cached.accessed,
+		Size:     len(cached.value),
+	}
+
+	if cached.expire {
+		it.TTL = cached.expiry.Sub(time.Now())
+		it.Expiry = cached.expiry
+	}
+
+	cached.accessed = time.Now()
+	return it, nil
+}
+
+// TTL returns the time the item stored under key has left, or
+// `item.NoExpiry` if it doesn't expire.
+func (c *Cache) TTL(key string) (time.Duration, error) {
+	if err := c.exists(key); err != nil {
+		return 0, err
+	}
+
+	cached := c.items[key]
+	if !cached.expire {
+		return item.NoExpiry, nil
+	}
+
+	return cached.expiry.Sub(time.Now()), nil
+}
@@ -6,32 +6,53 @@ package memory
 
 import (
 	"runtime"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/expiry"
 	"github.com/jelmersnoeck/cacher/internal/encoding"
+	"github.com/jelmersnoeck/cacher/option"
 )
 
 type cachedItem struct {
-	value  []byte
-	expiry time.Time
-	expire bool
-	token  string
+	value    []byte
+	expiry   time.Time
+	expire   bool
+	token    string
+	flags    uint32
+	tags     []string
+	created  time.Time
+	accessed time.Time
 }
 
 // Cache is a caching implementation that stores the data in memory. The
 // cache will be emptied when the application has run.
+//
+// The items aren't safe for concurrent use, but the locks and the hit and miss
+// counters are, so the cache can be shared as a `cacher.Locker`.
 type Cache struct {
-	items map[string]*cachedItem
-	keys  []string
-	limit uintptr
-	size  uintptr
+	// hits and misses are updated atomically, and come first to be 64-bit
+	// aligned.
+	hits   int64
+	misses int64
+
+	items   map[string]*cachedItem
+	keys    []string
+	limit   uintptr
+	size    uintptr
+	onEvict func(key string, value []byte, expiry time.Time)
+
+	locksMu sync.Mutex
+	locks   map[string]lock
 }
 
 // New creates a new instance of Cache and initiates the storage map.
 func New(limit uintptr) *Cache {
 	cache := new(Cache)
 	cache.items = make(map[string]*cachedItem)
+	cache.locks = make(map[string]lock)
 	if limit == 0 {
 		// 10% of system memory
 		var memStats runtime.MemStats
@@ -50,11 +71,16 @@ func New(limit uintptr) *Cache {
 //
 // See the `Set()` function for ttl information.
 func (c *Cache) Add(key string, value []byte, ttl int64) error {
-	if err := c.exists(key); err == nil {
-		return errors.NewAlreadyExistingKey(key)
-	}
+	return c.AddUntil(key, value, expiry.FromSeconds(ttl))
+}
+
+// AddUntil is `Add()` with an Expiry instead of a ttl.
+func (c *Cache) AddUntil(key string, value []byte, exp expiry.Expiry) error {
+	return c.add(key, value, 0, exp)
+}
 
-	return c.Set(key, value, ttl)
+func (c *Cache) add(key string, value []byte, flags uint32, exp expiry.Expiry) error {
+	return c.write(key, value, option.Options{Expiry: exp, Flags: flags, OnlyIfAbsent: true})
 }
 
 // Set sets the value of an item, regardless of wether or not the value is
@@ -64,29 +90,108 @@ func (c *Cache) Add(key string, value []byte, ttl int64) error {
 // the item will be cached infinitely. If ttl is < 0, the value will be deleted
 // from the cache using the `Delete()` function.
 func (c *Cache) Set(key string, value []byte, ttl int64) error {
-	expiry := time.Now().Add(time.Duration(ttl) * time.Second)
+	return c.SetUntil(key, value, expiry.FromSeconds(ttl))
+}
 
-	var expire bool
-	if ttl < 0 {
+// SetUntil is `Set()` with an Expiry instead of a ttl. The item is kept until
+// exactly the moment of exp. If exp has already passed, the value will be
+// deleted from the cache using the `Delete()` function.
+func (c *Cache) SetUntil(key string, value []byte, exp expiry.Expiry) error {
+	return c.set(key, value, 0, exp)
+}
+
+func (c *Cache) set(key string, value []byte, flags uint32, exp expiry.Expiry) error {
+	if exp.Expired() {
 		return c.Delete(key)
-	} else if ttl != 0 {
-		expire = true
 	}
 
-	c.items[key] = &cachedItem{value, expiry, expire, encoding.Md5Sum(value)}
-	c.keys = append(c.keys, key)
-	c.size += uintptr(len(value)) // TODO: if already exists, don't add this all
+	if old, ok := c.items[key]; ok {
+		c.size -= uintptr(len(old.value))
+	} else {
+		c.keys = append(c.keys, key)
+	}
+
+	now := time.Now()
+	c.items[key] = &cachedItem{
+		value:    value,
+		expiry:   exp.Time(),
+		expire:   !exp.IsNever(),
+		token:    encoding.Md5Sum(value),
+		flags:    flags,
+		created:  now,
+		accessed: now,
+	}
+	c.size += uintptr(len(value))
 	c.lru(key)
 	c.evict()
 	return nil
 }
 
+// SetWith sets the value of an item as configured by opts, see the `option`
+// package.
+func (c *Cache) SetWith(key string, value []byte, opts ...option.Option) error {
+	o := option.Apply(opts...)
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	return c.write(key, value, o)
+}
+
+// write is the conditional write Add, Replace and CompareAndReplace are built
+// on.
+func (c *Cache) write(key string, value []byte, o option.Options) error {
+	err := c.exists(key)
+	if err == nil && o.OnlyIfAbsent {
+		return errors.NewAlreadyExistingKey(key)
+	}
+
+	if err != nil && (o.OnlyIfPresent || o.HasToken) {
+		return err
+	}
+
+	if o.HasToken && c.items[key].token != o.Token {
+		return errors.NewCASConflict(key)
+	}
+
+	exp := o.Expiry
+	if o.KeepTTL && err == nil && c.items[key].expire {
+		exp = expiry.At(c.items[key].expiry)
+	}
+
+	if err := c.set(key, value, o.Flags, exp); err != nil {
+		return err
+	}
+
+	if cached, ok := c.items[key]; ok {
+		cached.tags = o.Tags
+	}
+
+	return nil
+}
+
+// InvalidateTags deletes every item tagged with one of tags by `SetWith()`.
+func (c *Cache) InvalidateTags(tags ...string) error {
+	for i := len(c.keys) - 1; i >= 0; i-- {
+		if hasTag(c.items[c.keys[i]].tags, tags) {
+			c.removeAt(i)
+		}
+	}
+
+	return nil
+}
+
 // SetMulti sets multiple values for their respective keys. This is a shorthand
 // to use `Set` multiple times.
 func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	return c.SetMultiUntil(items, expiry.FromSeconds(ttl))
+}
+
+// SetMultiUntil is `SetMulti()` with an Expiry instead of a ttl.
+func (c *Cache) SetMultiUntil(items map[string][]byte, exp expiry.Expiry) map[string]error {
 	results := make(map[string]error)
 	for key, value := range items {
-		results[key] = c.Set(key, value, ttl)
+		results[key] = c.SetUntil(key, value, exp)
 	}
 
 	return results
@@ -96,32 +201,43 @@ func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
 // tokens match, we will replace the value and return true. If it doesn't, we
 // will not replace the value and return false.
 func (c *Cache) CompareAndReplace(token, key string, value []byte, ttl int64) error {
-	if err := c.exists(key); err != nil {
-		return err
-	}
+	return c.CompareAndReplaceUntil(token, key, value, expiry.FromSeconds(ttl))
+}
 
-	if c.items[key].token != token {
-		return errors.NewNonExistingKey(key)
-	}
+// CompareAndReplaceUntil is `CompareAndReplace()` with an Expiry instead of a
+// ttl.
+func (c *Cache) CompareAndReplaceUntil(token, key string, value []byte, exp expiry.Expiry) error {
+	return c.compareAndReplace(token, key, value, 0, exp)
+}
 
-	return c.Set(key, value, ttl)
+func (c *Cache) compareAndReplace(token, key string, value []byte, flags uint32, exp expiry.Expiry) error {
+	return c.write(key, value, option.Options{Expiry: exp, Flags: flags, Token: token, HasToken: true})
 }
 
 // Replace will update and only update the value of a cache key. If the key is
 // not previously used, we will return false.
 func (c *Cache) Replace(key string, value []byte, ttl int64) error {
-	if err := c.exists(key); err != nil {
-		return err
-	}
+	return c.ReplaceUntil(key, value, expiry.FromSeconds(ttl))
+}
 
-	return c.Set(key, value, ttl)
+// ReplaceUntil is `Replace()` with an Expiry instead of a ttl.
+func (c *Cache) ReplaceUntil(key string, value []byte, exp expiry.Expiry) error {
+	return c.replace(key, value, 0, exp)
+}
+
+func (c *Cache) replace(key string, value []byte, flags uint32, exp expiry.Expiry) error {
+	return c.write(key, value, option.Options{Expiry: exp, Flags: flags, OnlyIfPresent: true})
 }
 
 // Get gets the value out of the map associated with the provided key.
 func (c *Cache) Get(key string) ([]byte, string, error) {
 	if err := c.exists(key); err != nil {
+		atomic.AddInt64(&c.misses, 1)
 		return nil, "", err
 	}
+
+	atomic.AddInt64(&c.hits, 1)
+	c.items[key].accessed = time.Now()
 	return c.items[key].value, c.items[key].token, nil
 }
 
@@ -144,11 +260,16 @@ func (c *Cache) GetMulti(keys []string) (map[string][]byte, map[string]string, m
 //
 // Initial value and offset can't be below 0.
 func (c *Cache) Increment(key string, initial, offset, ttl int64) error {
+	return c.IncrementUntil(key, initial, offset, expiry.FromSeconds(ttl))
+}
+
+// IncrementUntil is `Increment()` with an Expiry instead of a ttl.
+func (c *Cache) IncrementUntil(key string, initial, offset int64, exp expiry.Expiry) error {
 	if initial < 0 || offset <= 0 {
 		return errors.NewInvalidRange(initial, offset)
 	}
 
-	return c.incrementOffset(key, initial, offset, ttl)
+	return c.incrementOffset(key, initial, offset, exp)
 }
 
 // Decrement subtracts a value of offset to the initial value. If the initial
@@ -157,16 +278,22 @@ func (c *Cache) Increment(key string, initial, offset, ttl int64) error {
 //
 // Initial value and offset can't be below 0.
 func (c *Cache) Decrement(key string, initial, offset, ttl int64) error {
+	return c.DecrementUntil(key, initial, offset, expiry.FromSeconds(ttl))
+}
+
+// DecrementUntil is `Decrement()` with an Expiry instead of a ttl.
+func (c *Cache) DecrementUntil(key string, initial, offset int64, exp expiry.Expiry) error {
 	if initial < 0 || offset <= 0 {
 		return errors.NewInvalidRange(initial, offset)
 	}
 
-	return c.incrementOffset(key, initial, offset*-1, ttl)
+	return c.incrementOffset(key, initial, offset*-1, exp)
 }
 
 // Flush will remove all the items from the hash.
 func (c *Cache) Flush() error {
 	c.items = make(map[string]*cachedItem)
+	c.keys = nil
 	c.size = 0
 	return nil
 }
@@ -200,18 +327,32 @@ func (c *Cache) DeleteMulti(keys []string) map[string]error {
 // Touch will update the key's ttl to the given ttl value without altering the
 // value.
 func (c *Cache) Touch(key string, ttl int64) error {
+	return c.TouchUntil(key, expiry.FromSeconds(ttl))
+}
+
+// TouchUntil is `Touch()` with an Expiry instead of a ttl.
+func (c *Cache) TouchUntil(key string, exp expiry.Expiry) error {
 	if err := c.exists(key); err != nil {
 		return err
 	}
 
-	if ttl < 0 {
+	if exp.Expired() {
 		return c.Delete(key)
 	}
 
-	c.items[key].expiry = time.Now().Add(time.Duration(ttl) * time.Second)
+	c.items[key].expiry = exp.Time()
+	c.items[key].expire = !exp.IsNever()
 	return nil
 }
 
+// OnEvict registers a function that is called with every item that is evicted
+// because the cache is over its limit, before it is removed. Items that have
+// expired are dropped without calling fn. The expiry is the zero time for items
+// that don't expire.
+func (c *Cache) OnEvict(fn func(key string, value []byte, expiry time.Time)) {
+	c.onEvict = fn
+}
+
 // removeAt will remove a specific indexed value from our cache.
 func (c *Cache) removeAt(index int) error {
 	key := c.keys[index]
@@ -226,9 +367,9 @@ func (c *Cache) removeAt(index int) error {
 // Decrement. If the key isn't set before, we will set the initial value. If
 // there is a value present, we will add the given offset to that value and
 // update the value with the new TTL.
-func (c *Cache) incrementOffset(key string, initial, offset, ttl int64) error {
+func (c *Cache) incrementOffset(key string, initial, offset int64, exp expiry.Expiry) error {
 	if err := c.exists(key); err != nil {
-		return c.Set(key, encoding.Int64Bytes(initial), ttl)
+		return c.SetUntil(key, encoding.Int64Bytes(initial), exp)
 	}
 
 	val, ok := encoding.BytesInt64(c.items[key].value)
@@ -242,7 +383,7 @@ func (c *Cache) incrementOffset(key string, initial, offset, ttl int64) error {
 		return errors.NewValueBelowZero(key)
 	}
 
-	return c.Set(key, encoding.Int64Bytes(val), ttl)
+	return c.SetUntil(key, encoding.Int64Bytes(val), exp)
 }
 
 // exists checks if a key is stored in the cache.
@@ -262,13 +403,14 @@ func (c *Cache) exists(key string) error {
 		c.Delete(key)
 	}
 
-	return errors.NewNonExistingKey(key)
+	return errors.NewNotFound(key)
 }
 
 // evict clears off the items in the cache that have been least active.
 func (c *Cache) evict() {
 	for {
 		if c.size > c.limit {
+			c.notifyEvict(c.keys[0])
 			c.removeAt(0)
 		} else {
 			break
@@ -276,6 +418,25 @@ func (c *Cache) evict() {
 	}
 }
 
+// notifyEvict passes the item stored under key to the eviction function, if
+// one is registered and the item hasn't expired yet.
+func (c *Cache) notifyEvict(key string) {
+	item := c.items[key]
+	if c.onEvict == nil {
+		return
+	}
+
+	var expiry time.Time
+	if item.expire {
+		if !time.Now().Before(item.expiry) {
+			return
+		}
+		expiry = item.expiry
+	}
+
+	c.onEvict(key, item.value, expiry)
+}
+
 // lru stands for Least Recently Used. We will use this algorithm to mark items
 // that are not active in our cache to be freed when the size is over its limit.
 func (c *Cache) lru(key string) {
@@ -286,3 +447,16 @@ func (c *Cache) lru(key string) {
 		}
 	}
 }
+
+// hasTag reports whether one of tags is in itemTags.
+func hasTag(itemTags, tags []string) bool {
+	for _, t := range itemTags {
+		for _, tag := range tags {
+			if t == tag {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,375 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package namespace provides a cache that keeps its items in a namespace of
+// another cache, so several caches can share a backend without seeing each
+// other's keys.
+//
+// Keys are prefixed with the name of the namespace and its generation, a
+// counter stored in the other cache. Flush increments the generation, which
+// makes every item of the namespace unreachable at once without touching the
+// rest of the backend, even on a backend that can't list its keys. The items of
+// an old generation stay behind until they expire or are evicted, so they
+// should be written with a ttl on backends that don't evict.
+//
+// As the generation can change at any time, every operation reads it from the
+// other cache first, which takes an extra round trip on a remote cache. The
+// first operation on a namespace adds the generation as well, and reads it
+// again in case another process added it first.
+package namespace
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/internal/encoding"
+)
+
+// Cache is a caching implementation that stores its items in a namespace of
+// another cache. The other cache has to support Increment. The optional
+// interfaces of that cache are forwarded: keys are scanned and locked within
+// the namespace and `Stats()` covers the whole other cache. Snapshots aren't
+// supported, as restoring one would replace the other namespaces too.
+type Cache struct {
+	cacher.Forwarder
+
+	next   cacher.Cacher
+	name   string
+	genKey string
+}
+
+// New creates a new instance of Cache which stores its items in next, under
+// the namespace name. Every operation reads the generation of the namespace
+// from next first, so it takes two round trips on a remote cache.
+func New(next cacher.Cacher, name string) *Cache {
+	cache := new(Cache)
+	cache.Forwarder = cacher.NewForwarder(next)
+	cache.next = next
+	cache.name = name
+	cache.genKey = name + ":gen"
+
+	return cache
+}
+
+// Name returns the name of the namespace.
+func (c *Cache) Name() string {
+	return c.name
+}
+
+// Add adds the item to the namespace if it isn't stored in it yet.
+func (c *Cache) Add(key string, value []byte, ttl int64) error {
+	prefix, err := c.prefix()
+	if err != nil {
+		return err
+	}
+
+	return unprefix(prefix, c.next.Add(prefix+key, value, ttl))
+}
+
+// CompareAndReplace replaces the item if token matches. The token is the one
+// returned by `Get()`.
+func (c *Cache) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	prefix, err := c.prefix()
+	if err != nil {
+		return err
+	}
+
+	return unprefix(prefix, c.next.CompareAndReplace(token, prefix+key, value, ttl))
+}
+
+// Set sets the item in the namespace.
+func (c *Cache) Set(key string, value []byte, ttl int64) error {
+	prefix, err := c.prefix()
+	if err != nil {
+		return err
+	}
+
+	return unprefix(prefix, c.next.Set(prefix+key, value, ttl))
+}
+
+// SetMulti sets the items in the namespace.
+func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	prefix, err := c.prefix()
+	if err != nil {
+		return fail(keysOf(items), err)
+	}
+
+	prefixed := make(map[string][]byte)
+	for key, value := range items {
+		prefixed[prefix+key] = value
+	}
+
+	results := make(map[string]error)
+	for key, err := range c.next.SetMulti(prefixed, ttl) {
+		results[key[len(prefix):]] = unprefix(prefix, err)
+	}
+
+	return results
+}
+
+// Replace replaces the item if it is stored in the namespace.
+func (c *Cache) Replace(key string, value []byte, ttl int64) error {
+	prefix, err := c.prefix()
+	if err != nil {
+		return err
+	}
+
+	return unprefix(prefix, c.next.Replace(prefix+key, value, ttl))
+}
+
+// Increment increments the counter in the namespace.
+func (c *Cache) Increment(key string, initial, offset, ttl int64) error {
+	prefix, err := c.prefix()
+	if err != nil {
+		return err
+	}
+
+	return unprefix(prefix, c.next.Increment(prefix+key, initial, offset, ttl))
+}
+
+// Decrement decrements the counter in the namespace.
+func (c *Cache) Decrement(key string, initial, offset, ttl int64) error {
+	prefix, err := c.prefix()
+	if err != nil {
+		return err
+	}
+
+	return unprefix(prefix, c.next.Decrement(prefix+key, initial, offset, ttl))
+}
+
+// Delete deletes the item from the namespace.
+func (c *Cache) Delete(key string) error {
+	prefix, err := c.prefix()
+	if err != nil {
+		return err
+	}
+
+	return unprefix(prefix, c.next.Delete(prefix+key))
+}
+
+// DeleteMulti deletes the items from the namespace.
+func (c *Cache) DeleteMulti(keys []string) map[string]error {
+	prefix, err := c.prefix()
+	if err != nil {
+		return fail(keys, err)
+	}
+
+	results := make(map[string]error)
+	for key, err := range c.next.DeleteMulti(prefixAll(prefix, keys)) {
+		results[key[len(prefix):]] = unprefix(prefix, err)
+	}
+
+	return results
+}
+
+// Get gets the item from the namespace.
+func (c *Cache) Get(key string) ([]byte, string, error) {
+	prefix, err := c.prefix()
+	if err != nil {
+		return nil, "", err
+	}
+
+	value, token, err := c.next.Get(prefix + key)
+	return value, token, unprefix(prefix, err)
+}
+
+// GetMulti gets the items from the namespace.
+func (c *Cache) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	prefix, err := c.prefix()
+	if err != nil {
+		return map[string][]byte{}, map[string]string{}, fail(keys, err)
+	}
+
+	items, tokens, errs := c.next.GetMulti(prefixAll(prefix, keys))
+
+	values := make(map[string][]byte)
+	for key, value := range items {
+		values[key[len(prefix):]] = value
+	}
+
+	keyTokens := make(map[string]string)
+	for key, token := range tokens {
+		keyTokens[key[len(prefix):]] = token
+	}
+
+	keyErrs := make(map[string]error)
+	for key, err := range errs {
+		keyErrs[key[len(prefix):]] = unprefix(prefix, err)
+	}
+
+	return values, keyTokens, keyErrs
+}
+
+// Flush removes all the items from the namespace by incrementing its
+// generation. The rest of the other cache isn't touched.
+func (c *Cache) Flush() error {
+	return c.next.Increment(c.genKey, seed(), 1, 0)
+}
+
+// Touch updates the ttl of the item.
+func (c *Cache) Touch(key string, ttl int64) error {
+	prefix, err := c.prefix()
+	if err != nil {
+		return err
+	}
+
+	return unprefix(prefix, c.next.Touch(prefix+key, ttl))
+}
+
+// Generation returns the current generation of the namespace.
+func (c *Cache) Generation() (int64, error) {
+	value, _, err := c.next.Get(c.genKey)
+	if errors.Is(err, errors.ErrNotFound) {
+		err = c.next.Add(c.genKey, encoding.Int64Bytes(seed()), 0)
+		if err != nil && !errors.Is(err, errors.ErrExists) {
+			return 0, err
+		}
+
+		value, _, err = c.next.Get(c.genKey)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	gen, ok := encoding.BytesInt64(value)
+	if !ok {
+		return 0, errors.NewEncoding(c.genKey)
+	}
+
+	return gen, nil
+}
+
+// Scan calls fn for every key of the current generation that starts with
+// prefix, without the prefix of the namespace.
+func (c *Cache) Scan(prefix string, fn func(key string) bool) error {
+	gen, err := c.prefix()
+	if err != nil {
+		return err
+	}
+
+	return c.Forwarder.Scan(gen+prefix, func(key string) bool {
+		return fn(key[len(gen):])
+	})
+}
+
+// TryLock takes the lock named key within the namespace. Locks are kept when
+// the namespace is flushed.
+func (c *Cache) TryLock(key string, ttl time.Duration) (string, bool, error) {
+	return c.Forwarder.TryLock(c.name+":"+key, ttl)
+}
+
+// Unlock releases the lock named key within the namespace.
+func (c *Cache) Unlock(key, token string) error {
+	return c.Forwarder.Unlock(c.name+":"+key, token)
+}
+
+// Capabilities returns the capabilities of the other cache, without
+// snapshots.
+func (c *Cache) Capabilities() cacher.Capability {
+	return c.Forwarder.Capabilities() &^ cacher.CanSnapshot
+}
+
+// Snapshot isn't supported, as a snapshot of the other cache would include the
+// items outside the namespace.
+func (c *Cache) Snapshot(w io.Writer) error {
+	return errors.NewUnsupported("Snapshot")
+}
+
+// Restore isn't supported, as restoring the other cache would replace the
+// items outside the namespace.
+func (c *Cache) Restore(r io.Reader) error {
+	return errors.NewUnsupported("Restore")
+}
+
+// Close closes the other cache.
+func (c *Cache) Close() error {
+	return cacher.Close(c.next)
+}
+
+// Ping checks whether the other cache can be reached.
+func (c *Cache) Ping(ctx context.Context) error {
+	return cacher.Ping(ctx, c.next)
+}
+
+// prefix returns what the keys of the current generation start with.
+func (c *Cache) prefix() (string, error) {
+	gen, err := c.Generation()
+	if err != nil {
+		return "", err
+	}
+
+	return c.name + ":" + strconv.FormatInt(gen, 10) + ":", nil
+}
+
+// seed is the first generation of a namespace. It is based on the time, so a
+// generation counter that was evicted doesn't start over at a generation that
+// has been used before.
+func seed() int64 {
+	return time.Now().UnixNano()
+}
+
+// unprefix removes the prefix from the key of err, so errors name the key as
+// it was passed in.
+func unprefix(prefix string, err error) error {
+	switch e := err.(type) {
+	case errors.NotFound:
+		e.Key = strings.TrimPrefix(e.Key, prefix)
+		return e
+	case errors.AlreadyExistingKey:
+		e.Key = strings.TrimPrefix(e.Key, prefix)
+		return e
+	case errors.CASConflict:
+		e.Key = strings.TrimPrefix(e.Key, prefix)
+		return e
+	case errors.Encoding:
+		e.Key = strings.TrimPrefix(e.Key, prefix)
+		return e
+	case errors.ValueBelowZero:
+		e.Key = strings.TrimPrefix(e.Key, prefix)
+		return e
+	case errors.Decode:
+		e.Key = strings.TrimPrefix(e.Key, prefix)
+		return e
+	case errors.Tampered:
+		e.Key = strings.TrimPrefix(e.Key, prefix)
+		return e
+	case errors.InvalidData:
+		e.Key = strings.TrimPrefix(e.Key, prefix)
+		return e
+	}
+
+	return err
+}
+
+func prefixAll(prefix string, keys []string) []string {
+	prefixed := make([]string, len(keys))
+	for i, key := range keys {
+		prefixed[i] = prefix + key
+	}
+
+	return prefixed
+}
+
+func keysOf(items map[string][]byte) []string {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+func fail(keys []string, err error) map[string]error {
+	results := make(map[string]error)
+	for _, key := range keys {
+		results[key] = err
+	}
+
+	return results
+}
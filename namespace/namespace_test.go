@@ -0,0 +1,159 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package namespace_test
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/memory"
+	"github.com/jelmersnoeck/cacher/namespace"
+)
+
+func TestIsolation(t *testing.T) {
+	store := memory.New(0)
+	users := namespace.New(store, "users")
+	posts := namespace.New(store, "posts")
+
+	users.Set("1", []byte("jane"), 0)
+	posts.Set("1", []byte("hello"), 0)
+	store.Set("1", []byte("global"), 0)
+
+	if value, _, _ := users.Get("1"); string(value) != "jane" {
+		t.Errorf("Expected `jane`, got `%s`.", value)
+		t.FailNow()
+	}
+
+	if value, _, _ := posts.Get("1"); string(value) != "hello" {
+		t.Errorf("Expected `hello`, got `%s`.", value)
+		t.FailNow()
+	}
+}
+
+func TestFlush(t *testing.T) {
+	store := memory.New(0)
+	users := namespace.New(store, "users")
+	posts := namespace.New(store, "posts")
+
+	users.SetMulti(map[string][]byte{"1": []byte("jane"), "2": []byte("john")}, 0)
+	posts.Set("1", []byte("hello"), 0)
+	store.Set("global", []byte("value"), 0)
+
+	before, _ := users.Generation()
+	if err := users.Flush(); err != nil {
+		t.Errorf("Expected Flush to succeed, got `%v`.", err)
+		t.FailNow()
+	}
+
+	if after, _ := users.Generation(); after != before+1 {
+		t.Errorf("Expected generation %d, got %d.", before+1, after)
+		t.FailNow()
+	}
+
+	_, _, errs := users.GetMulti([]string{"1", "2"})
+	for _, key := range []string{"1", "2"} {
+		if !errors.Is(errs[key], errors.ErrNotFound) {
+			t.Errorf("Expected `%s` to be flushed, got `%v`.", key, errs[key])
+			t.FailNow()
+		}
+	}
+
+	if value, _, _ := posts.Get("1"); string(value) != "hello" {
+		t.Errorf("Expected the other namespace to be kept, got `%s`.", value)
+		t.FailNow()
+	}
+
+	if value, _, _ := store.Get("global"); string(value) != "value" {
+		t.Errorf("Expected the keys outside the namespace to be kept, got `%s`.", value)
+		t.FailNow()
+	}
+}
+
+func TestEvictedGeneration(t *testing.T) {
+	store := memory.New(0)
+	users := namespace.New(store, "users")
+
+	users.Set("1", []byte("jane"), 0)
+
+	// A backend that drops the counter mustn't bring old items back.
+	store.Delete("users:gen")
+	if _, _, err := users.Get("1"); !errors.Is(err, errors.ErrNotFound) {
+		t.Errorf("Expected the item to be gone, got `%v`.", err)
+		t.FailNow()
+	}
+}
+
+func TestSharedGeneration(t *testing.T) {
+	store := memory.New(0)
+	first := namespace.New(store, "users")
+	second := namespace.New(store, "users")
+
+	first.Set("1", []byte("jane"), 0)
+	if value, _, _ := second.Get("1"); string(value) != "jane" {
+		t.Errorf("Expected `jane`, got `%s`.", value)
+		t.FailNow()
+	}
+
+	second.Flush()
+	if _, _, err := first.Get("1"); !errors.Is(err, errors.ErrNotFound) {
+		t.Errorf("Expected the flush to be seen by every instance, got `%v`.", err)
+		t.FailNow()
+	}
+}
+
+func TestCapabilities(t *testing.T) {
+	store := memory.New(0)
+	users := namespace.New(store, "users")
+	posts := namespace.New(store, "posts")
+
+	users.Set("1", []byte("jane"), 0)
+	users.Set("2", []byte("john"), 0)
+	posts.Set("1", []byte("hello"), 0)
+
+	if caps := cacher.Capabilities(users); caps != cacher.Capabilities(store)&^cacher.CanSnapshot {
+		t.Errorf("Expected the capabilities of the store without snapshots, got %s.", caps)
+		t.FailNow()
+	}
+
+	if err := users.Restore(strings.NewReader("")); !errors.Is(err, errors.ErrUnsupported) {
+		t.Errorf("Expected Restore to be unsupported, got `%v`.", err)
+		t.FailNow()
+	}
+
+	if v, _, err := posts.Get("1"); err != nil || string(v) != "hello" {
+		t.Errorf("Expected the other namespace to be kept, got `%s` `%v`.", v, err)
+		t.FailNow()
+	}
+
+	var keys []string
+	users.Scan("", func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	sort.Strings(keys)
+	if strings.Join(keys, ",") != "1,2" {
+		t.Errorf("Expected to scan the keys of the namespace, got %v.", keys)
+		t.FailNow()
+	}
+
+	if _, ok, _ := users.TryLock("1", time.Second); !ok {
+		t.Errorf("Expected to take the lock.")
+		t.FailNow()
+	}
+
+	if _, ok, _ := posts.TryLock("1", time.Second); !ok {
+		t.Errorf("Expected the lock of another namespace to be separate.")
+		t.FailNow()
+	}
+
+	if _, err := users.Stats(); err != nil {
+		t.Errorf("Expected the stats of the store, got `%v`.", err)
+		t.FailNow()
+	}
+}
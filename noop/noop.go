@@ -0,0 +1,116 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package noop provides caches that don't store anything. They can be used in
+// place of any other cache to disable caching, or to assert which calls are
+// made in tests.
+package noop
+
+import (
+	"context"
+
+	"github.com/jelmersnoeck/cacher/errors"
+)
+
+// Cache is a black hole: every write succeeds, but nothing is stored, so every
+// read misses.
+type Cache struct{}
+
+// New creates a new instance of Cache.
+func New() *Cache {
+	return new(Cache)
+}
+
+// Add pretends to add the item to the cache.
+func (c *Cache) Add(key string, value []byte, ttl int64) error {
+	return nil
+}
+
+// CompareAndReplace pretends to replace the item in the cache.
+func (c *Cache) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	return nil
+}
+
+// Set pretends to set the item in the cache.
+func (c *Cache) Set(key string, value []byte, ttl int64) error {
+	return nil
+}
+
+// SetMulti pretends to set all the items in the cache.
+func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	results := make(map[string]error)
+	for key := range items {
+		results[key] = nil
+	}
+
+	return results
+}
+
+// Replace pretends to replace the item in the cache.
+func (c *Cache) Replace(key string, value []byte, ttl int64) error {
+	return nil
+}
+
+// Increment pretends to increment the item in the cache.
+func (c *Cache) Increment(key string, initial, offset, ttl int64) error {
+	return nil
+}
+
+// Decrement pretends to decrement the item in the cache.
+func (c *Cache) Decrement(key string, initial, offset, ttl int64) error {
+	return nil
+}
+
+// Delete pretends to delete the item from the cache.
+func (c *Cache) Delete(key string) error {
+	return nil
+}
+
+// DeleteMulti pretends to delete all the items from the cache.
+func (c *Cache) DeleteMulti(keys []string) map[string]error {
+	results := make(map[string]error)
+	for _, key := range keys {
+		results[key] = nil
+	}
+
+	return results
+}
+
+// Get always misses.
+func (c *Cache) Get(key string) ([]byte, string, error) {
+	return nil, "", errors.NewNotFound(key)
+}
+
+// GetMulti misses for every key.
+func (c *Cache) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	items := make(map[string][]byte)
+	errs := make(map[string]error)
+	tokens := make(map[string]string)
+
+	for _, key := range keys {
+		items[key], tokens[key], errs[key] = c.Get(key)
+	}
+
+	return items, tokens, errs
+}
+
+// Flush pretends to remove all the items from the cache.
+func (c *Cache) Flush() error {
+	return nil
+}
+
+// Touch pretends to update the ttl of the item.
+func (c *Cache) Touch(key string, ttl int64) error {
+	return nil
+}
+
+// Close does nothing, there is nothing to release.
+func (c *Cache) Close() error {
+	return nil
+}
+
+// Ping always succeeds, unless ctx is done.
+func (c *Cache) Ping(ctx context.Context) error {
+	return ctx.Err()
+}
@@ -0,0 +1,77 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package noop_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/noop"
+)
+
+func TestCache(t *testing.T) {
+	var cache cacher.Cacher = noop.New()
+
+	if err := cache.Set("key1", []byte("value1"), 0); err != nil {
+		t.Errorf("Expected Set to succeed, got `%s`.", err)
+		t.FailNow()
+	}
+
+	if err := cache.Replace("key1", []byte("value2"), 0); err != nil {
+		t.Errorf("Expected Replace to succeed, got `%s`.", err)
+		t.FailNow()
+	}
+
+	if _, _, err := cache.Get("key1"); err == nil {
+		t.Errorf("Expected Get to miss.")
+		t.FailNow()
+	}
+
+	_, _, errs := cache.GetMulti([]string{"key1", "key2"})
+	if errs["key1"] == nil || errs["key2"] == nil {
+		t.Errorf("Expected GetMulti to miss for every key.")
+		t.FailNow()
+	}
+}
+
+func TestRecorder(t *testing.T) {
+	recorder := noop.NewRecorder()
+	var cache cacher.Cacher = recorder
+
+	cache.Set("key1", []byte("value1"), 10)
+	cache.Get("key1")
+	cache.Increment("key2", 1, 2, 0)
+
+	expected := []noop.Call{
+		{Method: "Set", Args: []interface{}{"key1", []byte("value1"), int64(10)}},
+		{Method: "Get", Args: []interface{}{"key1"}},
+		{Method: "Increment", Args: []interface{}{"key2", int64(1), int64(2), int64(0)}},
+	}
+
+	if calls := recorder.Calls(); !reflect.DeepEqual(calls, expected) {
+		t.Errorf("Expected calls %v, got %v.", expected, calls)
+		t.FailNow()
+	}
+
+	if calls := recorder.CallsTo("Get"); len(calls) != 1 {
+		t.Errorf("Expected 1 call to Get, got %d.", len(calls))
+		t.FailNow()
+	}
+
+	value := []byte("value2")
+	cache.Set("key1", value, 0)
+	copy(value, "VALUE2")
+	if args := recorder.CallsTo("Set")[1].Args; string(args[1].([]byte)) != "value2" {
+		t.Errorf("Expected the recorded value not to change, got `%s`.", args[1])
+		t.FailNow()
+	}
+
+	recorder.Reset()
+	if calls := recorder.Calls(); len(calls) != 0 {
+		t.Errorf("Expected no calls after Reset, got %d.", len(calls))
+		t.FailNow()
+	}
+}
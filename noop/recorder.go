@@ -0,0 +1,178 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package noop
+
+import (
+	"context"
+	"sync"
+)
+
+// Call describes a single method call made on a Recorder. Args holds the
+// arguments in the order of the method signature.
+type Call struct {
+	Method string
+	Args   []interface{}
+}
+
+// Recorder behaves like Cache, but keeps track of every call made to it so
+// tests can assert how a cache is used.
+type Recorder struct {
+	Cache
+
+	mu    sync.Mutex
+	calls []Call
+}
+
+// NewRecorder creates a new instance of Recorder.
+func NewRecorder() *Recorder {
+	return new(Recorder)
+}
+
+// Calls returns all the calls recorded so far, in the order they were made.
+func (r *Recorder) Calls() []Call {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	calls := make([]Call, len(r.calls))
+	copy(calls, r.calls)
+
+	return calls
+}
+
+// CallsTo returns the recorded calls to the given method.
+func (r *Recorder) CallsTo(method string) []Call {
+	var calls []Call
+	for _, call := range r.Calls() {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+
+	return calls
+}
+
+// Reset forgets all the recorded calls.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = nil
+}
+
+// Add records the call and pretends to add the item to the cache.
+func (r *Recorder) Add(key string, value []byte, ttl int64) error {
+	r.record("Add", key, value, ttl)
+	return r.Cache.Add(key, value, ttl)
+}
+
+// CompareAndReplace records the call and pretends to replace the item in the
+// cache.
+func (r *Recorder) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	r.record("CompareAndReplace", token, key, value, ttl)
+	return r.Cache.CompareAndReplace(token, key, value, ttl)
+}
+
+// Set records the call and pretends to set the item in the cache.
+func (r *Recorder) Set(key string, value []byte, ttl int64) error {
+	r.record("Set", key, value, ttl)
+	return r.Cache.Set(key, value, ttl)
+}
+
+// SetMulti records the call and pretends to set all the items in the cache.
+func (r *Recorder) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	r.record("SetMulti", items, ttl)
+	return r.Cache.SetMulti(items, ttl)
+}
+
+// Replace records the call and pretends to replace the item in the cache.
+func (r *Recorder) Replace(key string, value []byte, ttl int64) error {
+	r.record("Replace", key, value, ttl)
+	return r.Cache.Replace(key, value, ttl)
+}
+
+// Increment records the call and pretends to increment the item in the cache.
+func (r *Recorder) Increment(key string, initial, offset, ttl int64) error {
+	r.record("Increment", key, initial, offset, ttl)
+	return r.Cache.Increment(key, initial, offset, ttl)
+}
+
+// Decrement records the call and pretends to decrement the item in the cache.
+func (r *Recorder) Decrement(key string, initial, offset, ttl int64) error {
+	r.record("Decrement", key, initial, offset, ttl)
+	return r.Cache.Decrement(key, initial, offset, ttl)
+}
+
+// Delete records the call and pretends to delete the item from the cache.
+func (r *Recorder) Delete(key string) error {
+	r.record("Delete", key)
+	return r.Cache.Delete(key)
+}
+
+// DeleteMulti records the call and pretends to delete all the items from the
+// cache.
+func (r *Recorder) DeleteMulti(keys []string) map[string]error {
+	r.record("DeleteMulti", keys)
+	return r.Cache.DeleteMulti(keys)
+}
+
+// Get records the call and misses.
+func (r *Recorder) Get(key string) ([]byte, string, error) {
+	r.record("Get", key)
+	return r.Cache.Get(key)
+}
+
+// GetMulti records the call and misses for every key.
+func (r *Recorder) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	r.record("GetMulti", keys)
+	return r.Cache.GetMulti(keys)
+}
+
+// Flush records the call and pretends to remove all the items from the cache.
+func (r *Recorder) Flush() error {
+	r.record("Flush")
+	return r.Cache.Flush()
+}
+
+// Touch records the call and pretends to update the ttl of the item.
+func (r *Recorder) Touch(key string, ttl int64) error {
+	r.record("Touch", key, ttl)
+	return r.Cache.Touch(key, ttl)
+}
+
+// Close records the call.
+func (r *Recorder) Close() error {
+	r.record("Close")
+	return r.Cache.Close()
+}
+
+// Ping records the call and succeeds, unless ctx is done.
+func (r *Recorder) Ping(ctx context.Context) error {
+	r.record("Ping")
+	return r.Cache.Ping(ctx)
+}
+
+// record keeps the call. Values and slices are copied, so a caller reusing
+// them after the call doesn't change what was recorded.
+func (r *Recorder) record(method string, args ...interface{}) {
+	for i, arg := range args {
+		switch arg := arg.(type) {
+		case []byte:
+			args[i] = append(arg[:0:0], arg...)
+		case []string:
+			args[i] = append(arg[:0:0], arg...)
+		case map[string][]byte:
+			items := make(map[string][]byte, len(arg))
+			for key, value := range arg {
+				items[key] = append(value[:0:0], value...)
+			}
+			args[i] = items
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, Call{Method: method, Args: args})
+}
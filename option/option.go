@@ -0,0 +1,122 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package option provides the options of a write with `SetWith()`. Without
+// options, SetWith stores the value without expiry, like `Set()` with a ttl of
+// 0. The other writes can be expressed through it:
+//
+//	Add:               cache.SetWith(key, value, option.OnlyIfAbsent())
+//	Replace:           cache.SetWith(key, value, option.OnlyIfPresent())
+//	CompareAndReplace: cache.SetWith(key, value, option.IfToken(token))
+//
+// Only the memory and Redis caches apply every option natively. See
+// `cacher.WithOptions()` for the options other caches support.
+package option
+
+import (
+	"time"
+
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/expiry"
+)
+
+// Option configures a write.
+type Option func(*Options)
+
+// Options is the result of applying a list of Option. Caches implementing
+// `SetWith()` use it to find out what to do.
+type Options struct {
+	Expiry        expiry.Expiry
+	KeepTTL       bool
+	OnlyIfAbsent  bool
+	OnlyIfPresent bool
+	Token         string
+	HasToken      bool
+	Flags         uint32
+	Tags          []string
+}
+
+// Apply applies opts in order, so later options override earlier ones.
+func Apply(opts ...Option) Options {
+	var o Options
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	return o
+}
+
+// Validate returns an error when the options contradict each other.
+func (o Options) Validate() error {
+	if o.OnlyIfAbsent && o.OnlyIfPresent {
+		return errors.NewUnsupported("OnlyIfAbsent with OnlyIfPresent")
+	}
+
+	if o.OnlyIfAbsent && o.HasToken {
+		return errors.NewUnsupported("OnlyIfAbsent with IfToken")
+	}
+
+	return nil
+}
+
+// WithTTL expires the item after d. A d of 0 or less expires it straight away,
+// which removes it from the cache.
+func WithTTL(d time.Duration) Option {
+	return WithExpiry(expiry.In(d))
+}
+
+// WithExpiry expires the item at exp.
+func WithExpiry(exp expiry.Expiry) Option {
+	return func(o *Options) {
+		o.Expiry = exp
+		o.KeepTTL = false
+	}
+}
+
+// KeepTTL keeps the expiry the item already has. New items don't expire.
+func KeepTTL() Option {
+	return func(o *Options) {
+		o.Expiry = expiry.Never
+		o.KeepTTL = true
+	}
+}
+
+// OnlyIfAbsent only writes the item when it isn't cached yet, like `Add()`.
+func OnlyIfAbsent() Option {
+	return func(o *Options) {
+		o.OnlyIfAbsent = true
+	}
+}
+
+// OnlyIfPresent only writes the item when it is cached already, like
+// `Replace()`.
+func OnlyIfPresent() Option {
+	return func(o *Options) {
+		o.OnlyIfPresent = true
+	}
+}
+
+// IfToken only writes the item when its token matches token, like
+// `CompareAndReplace()`.
+func IfToken(token string) Option {
+	return func(o *Options) {
+		o.Token = token
+		o.HasToken = true
+	}
+}
+
+// WithFlags stores flags with the item, see `cacher.FlagCacher`.
+func WithFlags(flags uint32) Option {
+	return func(o *Options) {
+		o.Flags = flags
+	}
+}
+
+// WithTags tags the item, so it can be invalidated together with the other
+// items with the same tag.
+func WithTags(tags ...string) Option {
+	return func(o *Options) {
+		o.Tags = append(o.Tags, tags...)
+	}
+}
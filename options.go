@@ -0,0 +1,120 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package cacher
+
+import (
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/expiry"
+	"github.com/jelmersnoeck/cacher/item"
+	"github.com/jelmersnoeck/cacher/option"
+)
+
+// OptionsCacher is a Cacher with a single write that is configured through
+// options, see the `option` package.
+type OptionsCacher interface {
+	Cacher
+
+	SetWith(key string, value []byte, opts ...option.Option) error
+}
+
+// WithOptions returns c as an OptionsCacher. Caches that support options
+// natively, such as the memory and Redis caches, are returned as they are.
+// Other caches are wrapped so SetWith is translated into the matching Cacher
+// method, which rounds the expiry up to whole seconds. Flags are stored through
+// `WithFlags()`, so on caches without native flags the items written with flags
+// should be read through the wrapper too. Tags are stored through `WithTags()`,
+// and tagged items should be read through a `WithTags()` wrapper.
+//
+// KeepTTL reads the item with its TTL and replaces it by its token, trying
+// again when it changed in between. It requires c to be an ItemGetter, such as
+// the Bitcask and SQL caches, and returns an `errors.Unsupported` otherwise.
+func WithOptions(c Cacher) OptionsCacher {
+	if oc, ok := c.(OptionsCacher); ok {
+		return oc
+	}
+
+	return optionsAdapter{WithFlags(c), NewForwarder(c), c}
+}
+
+// keepTTLAttempts is how many times a write with KeepTTL is tried while the
+// item keeps changing under it.
+const keepTTLAttempts = 3
+
+type optionsAdapter struct {
+	FlagCacher
+	Forwarder
+
+	next Cacher
+}
+
+func (a optionsAdapter) SetWith(key string, value []byte, opts ...option.Option) error {
+	o := option.Apply(opts...)
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	// The tags adapter writes through WithOptions again, without the tags.
+	if len(o.Tags) > 0 {
+		return WithTags(a.next).SetWith(key, value, opts...)
+	}
+
+	if o.KeepTTL && !o.OnlyIfAbsent {
+		ig, ok := a.next.(ItemGetter)
+		if !ok {
+			return errors.NewUnsupported("KeepTTL")
+		}
+
+		return a.keepTTL(ig, key, value, o)
+	}
+
+	ttl := o.Expiry.Seconds()
+	switch {
+	case o.HasToken:
+		return a.CompareAndReplaceWithFlags(o.Token, key, value, o.Flags, ttl)
+	case o.OnlyIfAbsent:
+		return a.AddWithFlags(key, value, o.Flags, ttl)
+	case o.OnlyIfPresent:
+		return a.ReplaceWithFlags(key, value, o.Flags, ttl)
+	}
+
+	return a.SetWithFlags(key, value, o.Flags, ttl)
+}
+
+// keepTTL writes value with the TTL of the item stored under key. The item is
+// replaced by the token it was read with, so an item that is written or
+// expires in between isn't given a stale TTL. Missing items are added without
+// an expiry.
+func (a optionsAdapter) keepTTL(ig ItemGetter, key string, value []byte, o option.Options) error {
+	for attempt := 0; attempt < keepTTLAttempts; attempt++ {
+		it, err := ig.GetItem(key)
+		if errors.Is(err, errors.ErrNotFound) && !o.OnlyIfPresent && !o.HasToken {
+			err = a.AddWithFlags(key, value, o.Flags, o.Expiry.Seconds())
+			if errors.Is(err, errors.ErrExists) {
+				continue
+			}
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		token := it.Token
+		if o.HasToken {
+			token = o.Token
+		}
+
+		var ttl int64
+		if it.TTL != item.NoExpiry {
+			ttl = expiry.In(it.TTL).Seconds()
+		}
+
+		err = a.CompareAndReplaceWithFlags(token, key, value, o.Flags, ttl)
+		if o.HasToken || !errors.Is(err, errors.ErrCASConflict) && !errors.Is(err, errors.ErrNotFound) {
+			return err
+		}
+	}
+
+	return errors.NewCASConflict(key)
+}
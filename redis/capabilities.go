@@ -0,0 +1,308 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package redis
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/internal/encoding"
+	"github.com/jelmersnoeck/cacher/stats"
+)
+
+// unlockScript deletes a lock only if it is still held with the given token.
+var unlockScript = redis.NewScript(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// addScript is the Lua function scripts use to add ARGV member to a tag set.
+// The set lives as long as the longest lived item in it: it is extended to ms
+// milliseconds, or made persistent when ms is 0.
+const addScript = `
+local function add(set, member, ms)
+	local ttl = redis.call("PTTL", set)
+	redis.call("SADD", set, member)
+	if ms == 0 then
+		redis.call("PERSIST", set)
+	elseif ttl == -2 or (ttl >= 0 and ttl < ms) then
+		redis.call("PEXPIRE", set, ms)
+	end
+end
+`
+
+// retagScript tags the key ARGV[1] with the tags from ARGV[3] on. KEYS[1] is
+// the set of the tags of the key, which loses its previous tags, and the sets
+// of the tags follow in the same order. ARGV[2] is the ttl of the key in
+// milliseconds. The key stays in the sets of its previous tags until they are
+// invalidated, which skips keys that aren't tagged with the tag anymore.
+var retagScript = redis.NewScript(-1, addScript+`
+local ms = tonumber(ARGV[2])
+redis.call("DEL", KEYS[1])
+for i = 2, #KEYS do
+	add(KEYS[1], ARGV[i + 1], ms)
+	add(KEYS[i], ARGV[1], ms)
+end
+return 0
+`)
+
+// touchTagsScript extends the tags of the key ARGV[1] to ARGV[2] milliseconds.
+// KEYS[1] is the set of the tags of the key and the sets of the tags follow.
+var touchTagsScript = redis.NewScript(-1, addScript+`
+local ms = tonumber(ARGV[2])
+if ms == 0 then
+	redis.call("PERSIST", KEYS[1])
+else
+	redis.call("PEXPIRE", KEYS[1], ms)
+end
+for i = 2, #KEYS do
+	add(KEYS[i], ARGV[1], ms)
+end
+return 0
+`)
+
+// invalidateScript deletes the keys read from the sets of ARGV[1] tags. KEYS
+// starts with those sets, followed by the item, flags and tags keys of every
+// key. ARGV holds the number of tags, the tags and the keys. A key is only
+// deleted if it is still tagged with one of the tags, and leaves the sets
+// either way, so keys tagged since the sets were read are kept.
+var invalidateScript = redis.NewScript(-1, `
+local n = tonumber(ARGV[1])
+for j = n + 2, #ARGV do
+	local k = n + 3 * (j - n - 2)
+	for i = 1, n do
+		if redis.call("SISMEMBER", KEYS[k + 3], ARGV[i + 1]) == 1 then
+			redis.call("DEL", KEYS[k + 1], KEYS[k + 2], KEYS[k + 3])
+			break
+		end
+	end
+	for i = 1, n do
+		redis.call("SREM", KEYS[i], ARGV[j])
+	end
+end
+return 0
+`)
+
+// Scan calls fn for every key with the prefix of the cache and prefix, until
+// fn returns false. Keys are listed with SCAN, so a key that is written or
+// deleted during the scan may or may not be passed to fn, and a key might be
+// passed more than once.
+func (c *Cache) Scan(prefix string, fn func(key string) bool) error {
+	return c.scan(context.Background(), prefix, fn)
+}
+
+func (c *Cache) scan(ctx context.Context, prefix string, fn func(key string) bool) error {
+	conn := c.conn(ctx)
+	defer conn.Close()
+
+	match := globEscape(c.prefix+prefix) + "*"
+	cursor := "0"
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", match))
+		if err != nil {
+			return err
+		}
+
+		cursor, _ = redis.String(reply[0], nil)
+		keys, _ := redis.Strings(reply[1], nil)
+		for _, key := range keys {
+			// Flags, tags and locks are stored next to the items.
+			if strings.Contains(key, "\x00") {
+				continue
+			}
+
+			if !fn(strings.TrimPrefix(key, c.prefix)) {
+				return nil
+			}
+		}
+
+		if cursor == "0" {
+			return nil
+		}
+	}
+}
+
+// Stats returns the number of items with the prefix of the cache, and the
+// number of hits and misses of the whole Redis server. The size of the items
+// isn't tracked.
+func (c *Cache) Stats() (stats.Stats, error) {
+	var s stats.Stats
+	err := c.scan(context.Background(), "", func(string) bool {
+		s.Items++
+		return true
+	})
+	if err != nil {
+		return s, err
+	}
+
+	conn := c.conn(context.Background())
+	defer conn.Close()
+
+	// Servers that don't report the numbers leave them at 0.
+	info, _ := redis.String(conn.Do("INFO", "stats"))
+	for _, line := range strings.Split(info, "\r\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+
+		n, _ := strconv.ParseInt(parts[1], 10, 64)
+		switch parts[0] {
+		case "keyspace_hits":
+			s.Hits = n
+		case "keyspace_misses":
+			s.Misses = n
+		}
+	}
+
+	return s, nil
+}
+
+// Ping checks whether the Redis server can be reached.
+func (c *Cache) Ping(ctx context.Context) error {
+	conn := c.conn(ctx)
+	defer conn.Close()
+
+	_, err := conn.Do("PING")
+	return err
+}
+
+// TryLock takes the lock named key for ttl, unless it is held already. Locks
+// are kept apart from the items, so key can be the key of an item. The lock is
+// a Redis key that expires after ttl, so it is released when the holder goes
+// away.
+func (c *Cache) TryLock(key string, ttl time.Duration) (string, bool, error) {
+	token, err := encoding.RandomToken()
+	if err != nil {
+		return "", false, err
+	}
+
+	conn := c.conn(context.Background())
+	defer conn.Close()
+
+	ms := int64(ttl / time.Millisecond)
+	if ms <= 0 {
+		ms = 1
+	}
+
+	reply, err := conn.Do("SET", c.lockKey(key), token, "NX", "PX", ms)
+	if err != nil {
+		return "", false, err
+	}
+
+	if reply == nil {
+		return "", false, nil
+	}
+
+	return token, true, nil
+}
+
+// Unlock releases the lock named key if it is still held with token. If the
+// lock has expired or is held by someone else, it returns an
+// `errors.NotFound`.
+func (c *Cache) Unlock(key, token string) error {
+	conn := c.conn(context.Background())
+	defer conn.Close()
+
+	n, err := redis.Int64(unlockScript.Do(conn, c.lockKey(key), token))
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return errors.NewNotFound(key)
+	}
+
+	return nil
+}
+
+// lockKey returns the name of the Redis key holding the lock named key.
+func (c *Cache) lockKey(key string) string {
+	return c.prefix + "\x00lock:" + key
+}
+
+// globEscape escapes the characters SCAN MATCH treats as a pattern.
+func globEscape(s string) string {
+	var b bytes.Buffer
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
+
+// InvalidateTags deletes every item tagged with one of tags by `SetWith()`.
+// Every tag has a set of the keys tagged with it, which expires with the last
+// of them. The sets are read first and the items deleted by a script, which
+// skips the items that were written with other tags in the meantime.
+func (c *Cache) InvalidateTags(tags ...string) error {
+	return c.InvalidateTagsCtx(context.Background(), tags...)
+}
+
+// InvalidateTagsCtx is `InvalidateTags()` with a context.
+func (c *Cache) InvalidateTagsCtx(ctx context.Context, tags ...string) error {
+	if len(tags) == 0 {
+		return nil
+	}
+
+	conn := c.conn(ctx)
+	defer conn.Close()
+
+	for _, tag := range tags {
+		conn.Send("SMEMBERS", c.tagKey(tag))
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	var keys []string
+	for range tags {
+		members, err := redis.Strings(conn.Receive())
+		if err != nil {
+			return err
+		}
+
+		for _, key := range members {
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil
+	}
+
+	args := []interface{}{len(tags) + 3*len(keys)}
+	for _, tag := range tags {
+		args = append(args, c.tagKey(tag))
+	}
+	for _, key := range keys {
+		args = append(args, c.key(key), c.flagsKey(key), c.tagsKey(key))
+	}
+	args = append(args, len(tags))
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+	for _, key := range keys {
+		args = append(args, key)
+	}
+
+	_, err := invalidateScript.Do(conn, args...)
+	return err
+}
@@ -0,0 +1,85 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package redis
+
+import (
+	"context"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/expiry"
+	"github.com/jelmersnoeck/cacher/internal/encoding"
+)
+
+// Every item carries flags, which are 0 for items written without them. This
+// includes the counters written by Increment and Decrement.
+
+// AddWithFlags is `Add()` that stores flags with the item.
+func (c *Cache) AddWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	return c.addUntil(context.Background(), key, value, flags, expiry.FromSeconds(ttl))
+}
+
+// SetWithFlags is `Set()` that stores flags with the item.
+func (c *Cache) SetWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	return c.setUntil(context.Background(), key, value, flags, expiry.FromSeconds(ttl))
+}
+
+// ReplaceWithFlags is `Replace()` that stores flags with the item.
+func (c *Cache) ReplaceWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	return c.replaceUntil(context.Background(), key, value, flags, expiry.FromSeconds(ttl))
+}
+
+// CompareAndReplaceWithFlags is `CompareAndReplace()` that stores flags with
+// the item.
+func (c *Cache) CompareAndReplaceWithFlags(token, key string, value []byte, flags uint32, ttl int64) error {
+	return c.compareAndReplaceUntil(context.Background(), token, key, value, flags, expiry.FromSeconds(ttl))
+}
+
+// GetWithFlags is `Get()` that also returns the flags of the item, which are
+// read in the same round trip.
+func (c *Cache) GetWithFlags(key string) ([]byte, string, uint32, error) {
+	items, tokens, flags, errs := c.GetMultiWithFlags([]string{key})
+
+	return items[key], tokens[key], flags[key], errs[key]
+}
+
+// GetMultiWithFlags is `GetMulti()` that also returns the flags of the items.
+func (c *Cache) GetMultiWithFlags(keys []string) (map[string][]byte, map[string]string, map[string]uint32, map[string]error) {
+	conn := c.conn(context.Background())
+	defer conn.Close()
+
+	values, err := redis.Values(conn.Do("MGET", append(c.keyArgs(keys), c.flagsKeyArgs(keys)...)...))
+	items := make(map[string][]byte)
+	tokens := make(map[string]string)
+	flags := make(map[string]uint32)
+	errs := make(map[string]error)
+
+	for i, key := range keys {
+		if err != nil {
+			errs[key] = err
+			continue
+		}
+
+		value, ok := values[i].([]byte)
+		if !ok {
+			errs[key] = errors.NewNotFound(key)
+			continue
+		}
+
+		items[key] = value
+		tokens[key] = encoding.Md5Sum(value)
+		flags[key] = flagsOf(values[len(keys)+i])
+		errs[key] = nil
+	}
+
+	return items, tokens, flags, errs
+}
+
+// flagsOf converts the reply of reading the flags of an item, which is nil for
+// items without flags.
+func flagsOf(reply interface{}) uint32 {
+	flags, _ := redis.Uint64(reply, nil)
+	return uint32(flags)
+}
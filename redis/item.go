@@ -0,0 +1,77 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package redis
+
+import (
+	"context"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/internal/encoding"
+	"github.com/jelmersnoeck/cacher/item"
+)
+
+// GetItem gets the value associated with the provided key together with its
+// remaining TTL and flags, which are read in the same round trip. Redis doesn't
+// keep track of when a value was written, so the timestamps of the item are
+// left empty.
+func (c *Cache) GetItem(key string) (*item.Item, error) {
+	conn := c.conn(context.Background())
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("GET", c.key(key))
+	conn.Send("PTTL", c.key(key))
+	conn.Send("GET", c.flagsKey(key))
+	reply, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return nil, err
+	}
+
+	value, ok := reply[0].([]byte)
+	if reply[0] == nil {
+		return nil, errors.NewNotFound(key)
+	} else if !ok {
+		return nil, errors.NewInvalidData(key)
+	}
+
+	it := &item.Item{
+		Key:   key,
+		Value: value,
+		Token: encoding.Md5Sum(value),
+		Flags: flagsOf(reply[2]),
+		TTL:   item.NoExpiry,
+		Size:  len(value),
+	}
+
+	if ms, _ := redis.Int64(reply[1], nil); ms >= 0 {
+		it.TTL = time.Duration(ms) * time.Millisecond
+		it.Expiry = time.Now().Add(it.TTL)
+	}
+
+	return it, nil
+}
+
+// TTL returns the time the item stored under key has left, or
+// `item.NoExpiry` if it doesn't expire.
+func (c *Cache) TTL(key string) (time.Duration, error) {
+	conn := c.conn(context.Background())
+	defer conn.Close()
+
+	ms, err := redis.Int64(conn.Do("PTTL", c.key(key)))
+	if err != nil {
+		return 0, err
+	}
+
+	switch ms {
+	case -2:
+		return 0, errors.NewNotFound(key)
+	case -1:
+		return item.NoExpiry, nil
+	}
+
+	return time.Duration(ms) * time.Millisecond, nil
+}
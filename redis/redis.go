@@ -5,36 +5,240 @@
 package redis
 
 import (
+	"context"
+	"net"
+	"time"
+
 	"github.com/garyburd/redigo/redis"
 	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/expiry"
 	"github.com/jelmersnoeck/cacher/internal/encoding"
+	"github.com/jelmersnoeck/cacher/option"
 )
 
+// Options configures a Cache created with `NewPool()`.
+type Options struct {
+	// Prefix is prepended to every key, so multiple caches can share the same
+	// database. Flush only removes the keys with the prefix.
+	//
+	// The scripts that keep track of tags touch the keys of several items and
+	// tags at once. On Redis Cluster, those keys have to be in the same slot, so
+	// use a prefix with a hash tag, like "{cache}:", to use tags.
+	Prefix string
+}
+
+// pool hands out the connections used for a single cache operation.
+type pool interface {
+	Get() redis.Conn
+	Close() error
+}
+
+// single is a pool that always hands out the same connection, which is only
+// closed when the cache is closed.
+type single struct {
+	conn redis.Conn
+}
+
+func (s single) Get() redis.Conn {
+	return nopCloser{s.conn}
+}
+
+func (s single) Close() error {
+	return s.conn.Close()
+}
+
+type nopCloser struct {
+	redis.Conn
+}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+func (n nopCloser) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return redis.DoWithTimeout(n.Conn, timeout, cmd, args...)
+}
+
+func (n nopCloser) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	return redis.ReceiveWithTimeout(n.Conn, timeout)
+}
+
+// supportsTimeout reports whether conn can bound how long it waits for a reply.
+func supportsTimeout(conn redis.Conn) bool {
+	if n, ok := conn.(nopCloser); ok {
+		conn = n.Conn
+	}
+
+	_, ok := conn.(redis.ConnWithTimeout)
+	return ok
+}
+
+// ctxConn is a connection that stops sending commands once its context is done
+// and uses the deadline of the context as the timeout of every command. Replies
+// of pipelined commands are read with the same timeout.
+type ctxConn struct {
+	redis.Conn
+	ctx     context.Context
+	aborted bool
+}
+
+// timeout returns how long the connection may wait for a reply, and false if
+// the context has no deadline or the connection can't be bounded.
+func (c *ctxConn) timeout() (time.Duration, bool, error) {
+	if err := c.ctx.Err(); err != nil {
+		c.aborted = true
+		return 0, false, err
+	}
+
+	deadline, ok := c.ctx.Deadline()
+	if !ok || !supportsTimeout(c.Conn) {
+		return 0, false, nil
+	}
+
+	timeout := deadline.Sub(time.Now())
+	if timeout <= 0 {
+		c.aborted = true
+		return 0, false, context.DeadlineExceeded
+	}
+
+	return timeout, true, nil
+}
+
+// done replaces err by the error of the context when the context ended while
+// waiting for a reply. The read can time out just before the context notices
+// its deadline has passed, so a timeout counts as the deadline too.
+func (c *ctxConn) done(reply interface{}, err error) (interface{}, error) {
+	if err == nil {
+		return reply, nil
+	}
+
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		c.aborted = true
+		return nil, context.DeadlineExceeded
+	}
+
+	if c.ctx.Err() != nil {
+		c.aborted = true
+		return nil, c.ctx.Err()
+	}
+
+	return reply, err
+}
+
+func (c *ctxConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	timeout, ok, err := c.timeout()
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return c.Conn.Do(cmd, args...)
+	}
+
+	return c.done(redis.DoWithTimeout(c.Conn, timeout, cmd, args...))
+}
+
+func (c *ctxConn) Send(cmd string, args ...interface{}) error {
+	if err := c.ctx.Err(); err != nil {
+		c.aborted = true
+		return err
+	}
+
+	return c.Conn.Send(cmd, args...)
+}
+
+func (c *ctxConn) Flush() error {
+	if err := c.ctx.Err(); err != nil {
+		c.aborted = true
+		return err
+	}
+
+	return c.Conn.Flush()
+}
+
+func (c *ctxConn) Receive() (interface{}, error) {
+	timeout, ok, err := c.timeout()
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return c.Conn.Receive()
+	}
+
+	return c.done(redis.ReceiveWithTimeout(c.Conn, timeout))
+}
+
+// Close makes sure a transaction that was cut short doesn't linger on the
+// connection before handing it back.
+func (c *ctxConn) Close() error {
+	if c.aborted {
+		c.Conn.Do("DISCARD")
+		c.Conn.Do("UNWATCH")
+	}
+
+	return c.Conn.Close()
+}
+
 // Cache is an instance that stores a Redis client that will be used to
 // communicate with the Redis server.
 type Cache struct {
-	client redis.Conn
+	pool   pool
+	prefix string
 }
 
-// New creates a new instance of Cache.
+// New creates a new instance of Cache. All operations share client, so it can't
+// be used from multiple goroutines. A command that runs past the deadline of
+// its context breaks the connection, use `NewPool()` when deadlines are used.
 func New(client redis.Conn) *Cache {
 	cache := new(Cache)
-	cache.client = client
+	cache.pool = single{client}
 
 	return cache
 }
 
+// NewPool creates a new instance of Cache which takes a connection from pool
+// for every operation, so it can be used from multiple goroutines.
+func NewPool(p *redis.Pool, opts Options) *Cache {
+	cache := new(Cache)
+	cache.pool = p
+	cache.prefix = opts.Prefix
+
+	return cache
+}
+
+// Close closes the connection or pool the cache was created with. The cache
+// can't be used after it has been closed.
+func (c *Cache) Close() error {
+	return c.pool.Close()
+}
+
+// conn takes a connection from the pool that is bound to ctx.
+func (c *Cache) conn(ctx context.Context) redis.Conn {
+	return &ctxConn{Conn: c.pool.Get(), ctx: ctx}
+}
+
 // Add an item to the cache. If the item is already cached, the value won't be
 // overwritten.
 //
 // ttl defines the number of seconds the value should be cached. If ttl is 0,
 // the item will be cached infinitely.
 func (c *Cache) Add(key string, value []byte, ttl int64) error {
-	if err := c.exists(key); err == nil {
-		return errors.NewAlreadyExistingKey(key)
-	}
+	return c.AddCtx(context.Background(), key, value, ttl)
+}
 
-	return c.Set(key, value, ttl)
+// AddCtx is `Add()` with a context.
+func (c *Cache) AddCtx(ctx context.Context, key string, value []byte, ttl int64) error {
+	return c.addUntil(ctx, key, value, 0, expiry.FromSeconds(ttl))
+}
+
+// AddUntil is `Add()` with an Expiry instead of a ttl.
+func (c *Cache) AddUntil(key string, value []byte, exp expiry.Expiry) error {
+	return c.addUntil(context.Background(), key, value, 0, exp)
+}
+
+func (c *Cache) addUntil(ctx context.Context, key string, value []byte, flags uint32, exp expiry.Expiry) error {
+	return c.write(ctx, key, value, option.Options{Expiry: exp, Flags: flags, OnlyIfAbsent: true})
 }
 
 // Set sets the value of an item, regardless of wether or not the value is
@@ -43,124 +247,229 @@ func (c *Cache) Add(key string, value []byte, ttl int64) error {
 // ttl defines the number of seconds the value should be cached. If ttl is 0,
 // the item will be cached infinitely.
 func (c *Cache) Set(key string, value []byte, ttl int64) error {
-	var err error
+	return c.SetCtx(context.Background(), key, value, ttl)
+}
 
-	if ttl > 0 {
-		_, err = c.client.Do("SETEX", key, ttl, value)
-	} else if ttl == 0 {
-		_, err = c.client.Do("SET", key, value)
-	} else {
-		return c.Delete(key)
-	}
+// SetCtx is `Set()` with a context.
+func (c *Cache) SetCtx(ctx context.Context, key string, value []byte, ttl int64) error {
+	return c.setUntil(ctx, key, value, 0, expiry.FromSeconds(ttl))
+}
 
-	return err
+// SetUntil is `Set()` with an Expiry instead of a ttl.
+func (c *Cache) SetUntil(key string, value []byte, exp expiry.Expiry) error {
+	return c.setUntil(context.Background(), key, value, 0, exp)
 }
 
-// SetMulti sets multiple values for their respective keys. This is a shorthand
-// to use `Set` multiple times.
-func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
-	results := make(map[string]error)
+func (c *Cache) setUntil(ctx context.Context, key string, value []byte, flags uint32, exp expiry.Expiry) error {
+	conn := c.conn(ctx)
+	defer conn.Close()
 
-	c.client.Do("MULTI")
-	for key, value := range items {
-		results[key] = c.Set(key, value, ttl)
+	if exp.Expired() {
+		return c.delete(conn, key)
 	}
-	c.client.Do("EXEC")
 
-	return results
+	conn.Send("MULTI")
+	c.set(conn, key, value, flags, nil, exp)
+	return exec(conn)
 }
 
-// CompareAndReplace validates the token with the token in the store. If the
-// tokens match, we will replace the value and return true. If it doesn't, we
-// will not replace the value and return false.
-func (c *Cache) CompareAndReplace(token, key string, value []byte, ttl int64) error {
-	c.client.Do("WATCH", key)
-	defer c.client.Do("UNWATCH")
-
-	if err := c.exists(key); err != nil {
+// SetWith sets the value of an item as configured by opts, see the `option`
+// package. Tagged keys are added to a Redis set per tag, in the same
+// transaction as the value.
+func (c *Cache) SetWith(key string, value []byte, opts ...option.Option) error {
+	o := option.Apply(opts...)
+	if err := o.Validate(); err != nil {
 		return err
 	}
 
-	_, storedToken, _ := c.Get(key)
-	if token != storedToken {
-		return errors.NewNotFound(key)
-	}
+	return c.write(context.Background(), key, value, o)
+}
 
-	// We're watching the key, by using MULTI the transaction will fail if the key
-	// changes in the meantime.
-	c.client.Do("MULTI")
-	c.Set(key, value, ttl)
-	rValue, _ := c.client.Do("EXEC")
+// write is the write Add, Replace and CompareAndReplace are built on. When the
+// write depends on the current item, the key is watched while the item is
+// inspected, so the write fails if the key changes in the meantime.
+func (c *Cache) write(ctx context.Context, key string, value []byte, o option.Options) error {
+	conn := c.conn(ctx)
+	defer conn.Close()
 
-	for _, v := range rValue.([]interface{}) {
-		if v.(string) != "OK" {
-			return errors.NewNotFound(key)
+	if len(o.Tags) > 0 {
+		if err := loadScript(conn, retagScript); err != nil {
+			return err
 		}
 	}
 
-	return nil
-}
+	if !o.OnlyIfAbsent && !o.OnlyIfPresent && !o.HasToken && !o.KeepTTL {
+		conn.Send("MULTI")
+		c.set(conn, key, value, o.Flags, o.Tags, o.Expiry)
+		return exec(conn)
+	}
 
-// Replace will update and only update the value of a cache key. If the key is
-// not previously used, we will return false.
-func (c *Cache) Replace(key string, value []byte, ttl int64) error {
-	c.client.Do("WATCH", key)
-	defer c.client.Do("UNWATCH")
+	if _, err := conn.Do("WATCH", c.key(key)); err != nil {
+		return err
+	}
+	defer conn.Do("UNWATCH")
 
-	if err := c.exists(key); err != nil {
+	err := c.exists(conn, key)
+	if err != nil && !errors.Is(err, errors.ErrNotFound) {
 		return err
 	}
 
-	// We're watching the key, so we can use a transaction to set the value. If
-	// the key changes in the meantime, it'll fail.
-	c.client.Do("MULTI")
-	c.Set(key, value, ttl)
-	vals, err := c.client.Do("EXEC")
+	if err == nil && o.OnlyIfAbsent {
+		return errors.NewAlreadyExistingKey(key)
+	}
 
-	if err != nil {
+	if err != nil && (o.OnlyIfPresent || o.HasToken) {
 		return err
 	}
 
-	for _, v := range vals.([]interface{}) {
-		if v.(string) != "OK" {
-			return errors.NewNotFound(key)
+	if o.HasToken {
+		if _, storedToken, _ := c.get(conn, key); storedToken != o.Token {
+			return errors.NewCASConflict(key)
 		}
 	}
 
-	return nil
-}
-
-// Get gets the value out of the map associated with the provided key.
-func (c *Cache) Get(key string) ([]byte, string, error) {
-	value, err := c.client.Do("GET", key)
+	exp := o.Expiry
+	if o.KeepTTL && err == nil {
+		ms, err := redis.Int64(conn.Do("PTTL", c.key(key)))
+		if err != nil {
+			return err
+		}
+		if ms > 0 {
+			exp = expiry.In(time.Duration(ms) * time.Millisecond)
+		}
+	}
 
+	conn.Do("MULTI")
+	c.set(conn, key, value, o.Flags, o.Tags, exp)
+	reply, err := conn.Do("EXEC")
 	if err != nil {
-		return []byte{}, "", err
+		return err
 	}
 
-	if value == nil {
-		return []byte{}, "", errors.NewNotFound(key)
+	// EXEC replies with nil when the transaction is aborted.
+	values, ok := reply.([]interface{})
+	if !ok && o.OnlyIfAbsent {
+		return errors.NewAlreadyExistingKey(key)
 	}
 
-	val, ok := value.([]byte)
+	if !ok && o.HasToken {
+		return errors.NewCASConflict(key)
+	}
 
-	if !ok {
-		return nil, "", errors.NewInvalidData(key)
+	if !ok || execFailed(values) {
+		return errors.NewNotFound(key)
 	}
 
-	return val, encoding.Md5Sum(val), nil
+	return nil
+}
+
+// SetMulti sets multiple values for their respective keys. This is a shorthand
+// to use `Set` multiple times.
+func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	return c.SetMultiCtx(context.Background(), items, ttl)
+}
+
+// SetMultiCtx is `SetMulti()` with a context.
+func (c *Cache) SetMultiCtx(ctx context.Context, items map[string][]byte, ttl int64) map[string]error {
+	return c.setMultiUntil(ctx, items, expiry.FromSeconds(ttl))
+}
+
+// SetMultiUntil is `SetMulti()` with an Expiry instead of a ttl.
+func (c *Cache) SetMultiUntil(items map[string][]byte, exp expiry.Expiry) map[string]error {
+	return c.setMultiUntil(context.Background(), items, exp)
+}
+
+func (c *Cache) setMultiUntil(ctx context.Context, items map[string][]byte, exp expiry.Expiry) map[string]error {
+	conn := c.conn(ctx)
+	defer conn.Close()
+
+	results := make(map[string]error)
+
+	conn.Do("MULTI")
+	for key, value := range items {
+		results[key] = c.set(conn, key, value, 0, nil, exp)
+	}
+	conn.Do("EXEC")
+
+	return results
+}
+
+// CompareAndReplace validates the token with the token in the store. If the
+// tokens match, we will replace the value and return true. If it doesn't, we
+// will not replace the value and return false.
+func (c *Cache) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	return c.CompareAndReplaceCtx(context.Background(), token, key, value, ttl)
+}
+
+// CompareAndReplaceCtx is `CompareAndReplace()` with a context.
+func (c *Cache) CompareAndReplaceCtx(ctx context.Context, token, key string, value []byte, ttl int64) error {
+	return c.compareAndReplaceUntil(ctx, token, key, value, 0, expiry.FromSeconds(ttl))
+}
+
+// CompareAndReplaceUntil is `CompareAndReplace()` with an Expiry instead of a ttl.
+func (c *Cache) CompareAndReplaceUntil(token, key string, value []byte, exp expiry.Expiry) error {
+	return c.compareAndReplaceUntil(context.Background(), token, key, value, 0, exp)
+}
+
+func (c *Cache) compareAndReplaceUntil(ctx context.Context, token, key string, value []byte, flags uint32, exp expiry.Expiry) error {
+	return c.write(ctx, key, value, option.Options{Expiry: exp, Flags: flags, Token: token, HasToken: true})
+}
+
+// Replace will update and only update the value of a cache key. If the key is
+// not previously used, we will return false.
+func (c *Cache) Replace(key string, value []byte, ttl int64) error {
+	return c.ReplaceCtx(context.Background(), key, value, ttl)
+}
+
+// ReplaceCtx is `Replace()` with a context.
+func (c *Cache) ReplaceCtx(ctx context.Context, key string, value []byte, ttl int64) error {
+	return c.replaceUntil(ctx, key, value, 0, expiry.FromSeconds(ttl))
+}
+
+// ReplaceUntil is `Replace()` with an Expiry instead of a ttl.
+func (c *Cache) ReplaceUntil(key string, value []byte, exp expiry.Expiry) error {
+	return c.replaceUntil(context.Background(), key, value, 0, exp)
+}
+
+func (c *Cache) replaceUntil(ctx context.Context, key string, value []byte, flags uint32, exp expiry.Expiry) error {
+	return c.write(ctx, key, value, option.Options{Expiry: exp, Flags: flags, OnlyIfPresent: true})
+}
+
+// Get gets the value out of the map associated with the provided key.
+func (c *Cache) Get(key string) ([]byte, string, error) {
+	return c.GetCtx(context.Background(), key)
+}
+
+// GetCtx is `Get()` with a context.
+func (c *Cache) GetCtx(ctx context.Context, key string) ([]byte, string, error) {
+	conn := c.conn(ctx)
+	defer conn.Close()
+
+	return c.get(conn, key)
 }
 
 // GetMulti gets multiple values from the cache and returns them as a map. It
 // uses `Get` internally to retrieve the data.
 func (c *Cache) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
-	cValues, err := c.client.Do("MGET", keyArgs(keys)...)
+	return c.GetMultiCtx(context.Background(), keys)
+}
+
+// GetMultiCtx is `GetMulti()` with a context.
+func (c *Cache) GetMultiCtx(ctx context.Context, keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	conn := c.conn(ctx)
+	defer conn.Close()
+
+	cValues, err := conn.Do("MGET", c.keyArgs(keys)...)
 	items := make(map[string][]byte)
 	errs := make(map[string]error)
 	tokens := make(map[string]string)
 
 	for _, v := range keys {
-		errs[v] = errors.NewNotFound(v)
+		if err != nil {
+			errs[v] = err
+		} else {
+			errs[v] = errors.NewNotFound(v)
+		}
 	}
 
 	if err == nil {
@@ -181,54 +490,133 @@ func (c *Cache) GetMulti(keys []string) (map[string][]byte, map[string]string, m
 // Increment adds a value of offset to the initial value. If the initial value
 // is already set, it will be added to the value currently stored in the cache.
 func (c *Cache) Increment(key string, initial, offset, ttl int64) error {
+	return c.IncrementCtx(context.Background(), key, initial, offset, ttl)
+}
+
+// IncrementCtx is `Increment()` with a context.
+func (c *Cache) IncrementCtx(ctx context.Context, key string, initial, offset, ttl int64) error {
+	return c.incrementUntil(ctx, key, initial, offset, expiry.FromSeconds(ttl))
+}
+
+// IncrementUntil is `Increment()` with an Expiry instead of a ttl.
+func (c *Cache) IncrementUntil(key string, initial, offset int64, exp expiry.Expiry) error {
+	return c.incrementUntil(context.Background(), key, initial, offset, exp)
+}
+
+func (c *Cache) incrementUntil(ctx context.Context, key string, initial, offset int64, exp expiry.Expiry) error {
 	if initial < 0 || offset <= 0 {
 		return errors.NewInvalidRange(initial, offset)
 	}
 
-	return c.incrementOffset(key, initial, offset, ttl)
+	return c.incrementOffset(ctx, key, initial, offset, exp)
 }
 
 // Decrement subtracts a value of offset to the initial value. If the initial
 // value is already set, it will be added to the value currently stored in the
 // cache.
 func (c *Cache) Decrement(key string, initial, offset, ttl int64) error {
+	return c.DecrementCtx(context.Background(), key, initial, offset, ttl)
+}
+
+// DecrementCtx is `Decrement()` with a context.
+func (c *Cache) DecrementCtx(ctx context.Context, key string, initial, offset, ttl int64) error {
+	return c.decrementUntil(ctx, key, initial, offset, expiry.FromSeconds(ttl))
+}
+
+// DecrementUntil is `Decrement()` with an Expiry instead of a ttl.
+func (c *Cache) DecrementUntil(key string, initial, offset int64, exp expiry.Expiry) error {
+	return c.decrementUntil(context.Background(), key, initial, offset, exp)
+}
+
+func (c *Cache) decrementUntil(ctx context.Context, key string, initial, offset int64, exp expiry.Expiry) error {
 	if initial < 0 || offset <= 0 {
 		return errors.NewInvalidRange(initial, offset)
 	}
 
-	return c.incrementOffset(key, initial, offset*-1, ttl)
+	return c.incrementOffset(ctx, key, initial, offset*-1, exp)
 }
 
-// Flush will remove all the items from the hash.
+// Flush will remove all the items from the hash. If the cache has a prefix,
+// only the keys with that prefix are removed.
 func (c *Cache) Flush() error {
-	_, err := c.client.Do("FLUSHDB")
+	return c.FlushCtx(context.Background())
+}
 
-	return err
+// FlushCtx is `Flush()` with a context.
+func (c *Cache) FlushCtx(ctx context.Context) error {
+	conn := c.conn(ctx)
+	defer conn.Close()
+
+	if c.prefix == "" {
+		_, err := conn.Do("FLUSHDB")
+		return err
+	}
+
+	cursor := "0"
+	for {
+		reply, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", globEscape(c.prefix)+"*"))
+		if err != nil {
+			return err
+		}
+
+		cursor, _ = redis.String(reply[0], nil)
+		keys, _ := redis.Values(reply[1], nil)
+		if len(keys) > 0 {
+			if _, err := conn.Do("DEL", keys...); err != nil {
+				return err
+			}
+		}
+
+		if cursor == "0" {
+			return nil
+		}
+	}
 }
 
 // Delete will validate if the key actually is stored in the cache. If it is
 // stored, it will remove the item from the cache. If it is not stored, it will
 // return false.
 func (c *Cache) Delete(key string) error {
-	v, err := c.client.Do("DEL", key)
-
-	if err != nil {
-		return err
-	}
+	return c.DeleteCtx(context.Background(), key)
+}
 
-	if v.(int64) != 1 {
-		errors.NewNotFound(key)
-	}
+// DeleteCtx is `Delete()` with a context.
+func (c *Cache) DeleteCtx(ctx context.Context, key string) error {
+	conn := c.conn(ctx)
+	defer conn.Close()
 
-	return nil
+	return c.delete(conn, key)
 }
 
 // DeleteMulti will delete multiple values at a time. It uses the `Delete`
 // method internally to do so. It will return a map of results to see if the
 // deletion is successful.
 func (c *Cache) DeleteMulti(keys []string) map[string]error {
-	_, _, errs := c.GetMulti(keys)
-	c.client.Do("DEL", keyArgs(keys)...)
+	return c.DeleteMultiCtx(context.Background(), keys)
+}
+
+// DeleteMultiCtx is `DeleteMulti()` with a context.
+func (c *Cache) DeleteMultiCtx(ctx context.Context, keys []string) map[string]error {
+	_, _, errs := c.GetMultiCtx(ctx, keys)
+
+	conn := c.conn(ctx)
+	defer conn.Close()
+
+	tags, err := c.tags(conn, keys)
+	if err != nil {
+		results := make(map[string]error)
+		for _, key := range keys {
+			results[key] = err
+		}
+		return results
+	}
+
+	conn.Send("MULTI")
+	conn.Send("DEL", append(c.keyArgs(keys), c.flagsKeyArgs(keys)...)...)
+	for _, key := range keys {
+		c.untag(conn, key, tags[key])
+	}
+	conn.Do("EXEC")
 
 	// DEL will only return false if the key is not present. To get a map of bools
 	// to return, we can go over the items that are in the store (before we've
@@ -245,32 +633,152 @@ func (c *Cache) DeleteMulti(keys []string) map[string]error {
 // Touch will update the key's ttl to the given ttl value without altering the
 // value.
 func (c *Cache) Touch(key string, ttl int64) error {
-	if err := c.exists(key); err != nil {
+	return c.TouchCtx(context.Background(), key, ttl)
+}
+
+// TouchCtx is `Touch()` with a context.
+func (c *Cache) TouchCtx(ctx context.Context, key string, ttl int64) error {
+	return c.touchUntil(ctx, key, expiry.FromSeconds(ttl))
+}
+
+// TouchUntil is `Touch()` with an Expiry instead of a ttl.
+func (c *Cache) TouchUntil(key string, exp expiry.Expiry) error {
+	return c.touchUntil(context.Background(), key, exp)
+}
+
+func (c *Cache) touchUntil(ctx context.Context, key string, exp expiry.Expiry) error {
+	conn := c.conn(ctx)
+	defer conn.Close()
+
+	if err := c.exists(conn, key); err != nil {
+		return err
+	}
+
+	if exp.Expired() {
+		return c.delete(conn, key)
+	}
+
+	tags, err := redis.Strings(conn.Do("SMEMBERS", c.tagsKey(key)))
+	if err != nil {
+		return err
+	}
+
+	if len(tags) > 0 {
+		if err := loadScript(conn, touchTagsScript); err != nil {
+			return err
+		}
+	}
+
+	conn.Send("MULTI")
+	for _, k := range []string{c.key(key), c.flagsKey(key)} {
+		if exp.IsNever() {
+			conn.Send("PERSIST", k)
+		} else {
+			conn.Send("PEXPIRE", k, exp.Milliseconds())
+		}
+	}
+	if len(tags) > 0 {
+		args := []interface{}{1 + len(tags), c.tagsKey(key)}
+		for _, tag := range tags {
+			args = append(args, c.tagKey(tag))
+		}
+		touchTagsScript.SendHash(conn, append(args, key, milliseconds(exp))...)
+	}
+	return exec(conn)
+}
+
+// get reads the value of key over conn.
+func (c *Cache) get(conn redis.Conn, key string) ([]byte, string, error) {
+	value, err := conn.Do("GET", c.key(key))
+
+	if err != nil {
+		return []byte{}, "", err
+	}
+
+	if value == nil {
+		return []byte{}, "", errors.NewNotFound(key)
+	}
+
+	val, ok := value.([]byte)
+
+	if !ok {
+		return nil, "", errors.NewInvalidData(key)
+	}
+
+	return val, encoding.Md5Sum(val), nil
+}
+
+// set queues the writes of key over conn, with the expiry in milliseconds. The
+// flags are stored under a separate key with the same expiry, which is removed
+// when flags is 0, and the key replaces its tags with tags. set is called
+// inside a transaction, so the value, its flags and its tags are always
+// written together.
+func (c *Cache) set(conn redis.Conn, key string, value []byte, flags uint32, tags []string, exp expiry.Expiry) error {
+	var px []interface{}
+	if !exp.IsNever() {
+		ms := exp.Milliseconds()
+		if ms <= 0 {
+			conn.Send("DEL", c.key(key), c.flagsKey(key))
+			return c.retag(conn, key, nil, exp)
+		}
+		px = []interface{}{"PX", ms}
+	}
+
+	if err := c.retag(conn, key, tags, exp); err != nil {
+		return err
+	}
+
+	if err := conn.Send("SET", append([]interface{}{c.key(key), value}, px...)...); err != nil {
 		return err
 	}
 
-	if ttl < 0 {
-		return c.Delete(key)
+	if flags == 0 {
+		return conn.Send("DEL", c.flagsKey(key))
 	}
 
-	_, err := c.client.Do("EXPIRE", key, ttl)
-	return err
+	return conn.Send("SET", append([]interface{}{c.flagsKey(key), flags}, px...)...)
+}
+
+// delete removes key, its flags and its tags over conn.
+func (c *Cache) delete(conn redis.Conn, key string) error {
+	tags, err := c.tags(conn, []string{key})
+	if err != nil {
+		return err
+	}
+
+	conn.Send("MULTI")
+	conn.Send("DEL", c.key(key), c.flagsKey(key))
+	c.untag(conn, key, tags[key])
+	values, err := redis.Values(conn.Do("EXEC"))
+
+	if err != nil {
+		return err
+	}
+
+	if n, _ := redis.Int64(values[0], nil); n == 0 {
+		return errors.NewNotFound(key)
+	}
+
+	return nil
 }
 
 // incrementOffset is a common incrementor method used between Increment and
 // Decrement. If the key isn't set before, we will set the initial value. If
 // there is a value present, we will add the given offset to that value and
 // update the value with the new TTL.
-func (c *Cache) incrementOffset(key string, initial, offset, ttl int64) error {
-	c.client.Do("WATCH", key)
+func (c *Cache) incrementOffset(ctx context.Context, key string, initial, offset int64, exp expiry.Expiry) error {
+	conn := c.conn(ctx)
+	defer conn.Close()
 
-	if err := c.exists(key); err != nil {
-		c.client.Do("MULTI")
-		defer c.client.Do("EXEC")
-		return c.Set(key, encoding.Int64Bytes(initial), ttl)
+	conn.Do("WATCH", c.key(key))
+
+	if err := c.exists(conn, key); err != nil {
+		conn.Do("MULTI")
+		defer conn.Do("EXEC")
+		return c.set(conn, key, encoding.Int64Bytes(initial), 0, nil, exp)
 	}
 
-	getValue, _, err := c.Get(key)
+	getValue, _, err := c.get(conn, key)
 	if err != nil {
 		return err
 	}
@@ -284,32 +792,169 @@ func (c *Cache) incrementOffset(key string, initial, offset, ttl int64) error {
 	// We are watching our key. With using a transaction, we can check that this
 	// increment doesn't inflect with another concurrent request that might
 	// happen.
-	c.client.Do("MULTI")
-	defer c.client.Do("EXEC")
+	conn.Do("MULTI")
+	defer conn.Do("EXEC")
 
 	val += offset
 	if val < 0 {
 		return errors.NewValueBelowZero(key)
 	}
 
-	return c.Set(key, encoding.Int64Bytes(val), ttl)
+	return c.set(conn, key, encoding.Int64Bytes(val), 0, nil, exp)
 }
 
-func (c *Cache) exists(key string) error {
-	val, _ := c.client.Do("EXISTS", key)
+func (c *Cache) exists(conn redis.Conn, key string) error {
+	val, err := conn.Do("EXISTS", c.key(key))
+	if err != nil {
+		return err
+	}
 
 	if val.(int64) == 1 {
 		return nil
 	}
 
-	return errors.NewNonExistingKey(key)
+	return errors.NewNotFound(key)
+}
+
+// key returns the name key is stored under in Redis.
+func (c *Cache) key(key string) string {
+	return c.prefix + key
+}
+
+// tagKey returns the name of the Redis set holding the keys tagged with tag.
+func (c *Cache) tagKey(tag string) string {
+	return c.prefix + "\x00tag:" + tag
+}
+
+// tagsKey returns the name of the Redis set holding the tags of key.
+func (c *Cache) tagsKey(key string) string {
+	return c.prefix + key + "\x00tags"
+}
+
+// retag queues the writes that replace the tags of key with tags, which expire
+// with exp. A key without tags only loses its set of tags; the script that
+// writes the tags is queued by its hash, so it has to be loaded with
+// `loadScript()` first.
+func (c *Cache) retag(conn redis.Conn, key string, tags []string, exp expiry.Expiry) error {
+	if len(tags) == 0 {
+		return conn.Send("DEL", c.tagsKey(key))
+	}
+
+	args := []interface{}{1 + len(tags), c.tagsKey(key)}
+	for _, tag := range tags {
+		args = append(args, c.tagKey(tag))
+	}
+	args = append(args, key, milliseconds(exp))
+	for _, tag := range tags {
+		args = append(args, tag)
+	}
+
+	return retagScript.SendHash(conn, args...)
+}
+
+// tags reads the tags of keys over conn in a single round trip.
+func (c *Cache) tags(conn redis.Conn, keys []string) (map[string][]string, error) {
+	for _, key := range keys {
+		conn.Send("SMEMBERS", c.tagsKey(key))
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+
+	tags := make(map[string][]string)
+	for _, key := range keys {
+		t, err := redis.Strings(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+		tags[key] = t
+	}
+
+	return tags, nil
+}
+
+// untag queues the writes that remove key from the sets of tags and drop its
+// set of tags.
+func (c *Cache) untag(conn redis.Conn, key string, tags []string) {
+	conn.Send("DEL", c.tagsKey(key))
+	for _, tag := range tags {
+		conn.Send("SREM", c.tagKey(tag), key)
+	}
+}
+
+// loadScript makes sure the server has script, so it can be queued in a
+// transaction with EVALSHA instead of sending its source every time.
+func loadScript(conn redis.Conn, script *redis.Script) error {
+	exists, err := redis.Ints(conn.Do("SCRIPT", "EXISTS", script.Hash()))
+	if err != nil {
+		return err
+	}
+
+	if len(exists) == 1 && exists[0] == 1 {
+		return nil
+	}
+
+	return script.Load(conn)
 }
 
-func keyArgs(keys []string) []interface{} {
+// flagsKey returns the name the flags of key are stored under in Redis. It
+// starts with the name of the key, so the flags are flushed with the prefix.
+func (c *Cache) flagsKey(key string) string {
+	return c.prefix + key + "\x00flags"
+}
+
+func (c *Cache) keyArgs(keys []string) []interface{} {
 	var args []interface{}
 	for _, key := range keys {
-		args = append(args, key)
+		args = append(args, c.key(key))
 	}
 
 	return args
 }
+
+func (c *Cache) flagsKeyArgs(keys []string) []interface{} {
+	var args []interface{}
+	for _, key := range keys {
+		args = append(args, c.flagsKey(key))
+	}
+
+	return args
+}
+
+// milliseconds returns the ttl of exp in milliseconds, or 0 if it never
+// expires.
+func milliseconds(exp expiry.Expiry) int64 {
+	if exp.IsNever() {
+		return 0
+	}
+
+	return exp.Milliseconds()
+}
+
+// exec executes the transaction queued on conn and returns the first error of
+// its commands.
+func exec(conn redis.Conn) error {
+	values, err := redis.Values(conn.Do("EXEC"))
+	if err != nil {
+		return err
+	}
+
+	for _, v := range values {
+		if err, ok := v.(redis.Error); ok {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// execFailed reports whether any of the commands of a transaction failed.
+func execFailed(values []interface{}) bool {
+	for _, v := range values {
+		if _, ok := v.(redis.Error); ok {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,87 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package cacher_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/bitcask"
+	"github.com/jelmersnoeck/cacher/internal/sqltest"
+	"github.com/jelmersnoeck/cacher/memcached"
+	"github.com/jelmersnoeck/cacher/memory"
+	"github.com/jelmersnoeck/cacher/noop"
+	"github.com/jelmersnoeck/cacher/redis"
+	"github.com/jelmersnoeck/cacher/sql"
+)
+
+func TestOpen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cacher-open")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		dsn   string
+		check func(cacher.Cacher) bool
+	}{
+		{"memory://?limit=1MB", func(c cacher.Cacher) bool { _, ok := c.(*memory.Cache); return ok }},
+		{"noop://", func(c cacher.Cacher) bool { _, ok := c.(*noop.Cache); return ok }},
+		{"bitcask://" + dir + "?max_file_size=1MB&merge_interval=-1s", func(c cacher.Cacher) bool { _, ok := c.(*bitcask.Cache); return ok }},
+		{"redis://localhost:6379/0?prefix=open:&pool=2", func(c cacher.Cacher) bool { _, ok := c.(*redis.Cache); return ok }},
+		{"memcached://localhost:11211,127.0.0.1:11212?timeout=100ms&pool=2", func(c cacher.Cacher) bool { _, ok := c.(*memcached.Cache); return ok }},
+		{"sql://" + sqltest.DriverName + "?dsn=open&dialect=postgres&table=items&create_table=true", func(c cacher.Cacher) bool { _, ok := c.(*sql.Cache); return ok }},
+	}
+
+	for _, tc := range cases {
+		cache, err := cacher.Open(tc.dsn)
+		if err != nil {
+			t.Errorf("Expected `%s` to open, got `%s`.", tc.dsn, err)
+			continue
+		}
+
+		if !tc.check(cache) {
+			t.Errorf("Expected `%s` to open the matching backend, got %T.", tc.dsn, cache)
+		}
+
+		cacher.Close(cache)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	for _, dsn := range []string{
+		"unknown://localhost",
+		"memory://?limit=lots",
+		"redis://localhost/db",
+		"bitcask://",
+		"memcached://localhost?timeout=soon",
+		"sql://" + sqltest.DriverName + "?dialect=oracle",
+		"sql://unknown?dsn=open",
+		"sql://" + sqltest.DriverName + "?dsn=open&table=cache%20WHERE%201%3D1",
+	} {
+		if _, err := cacher.Open(dsn); err == nil {
+			t.Errorf("Expected `%s` to fail to open.", dsn)
+		}
+	}
+}
+
+func TestDrivers(t *testing.T) {
+	drivers := cacher.Drivers()
+	expected := []string{"bitcask", "memcached", "memory", "noop", "redis", "sql"}
+
+	for _, name := range expected {
+		var found bool
+		for _, d := range drivers {
+			found = found || d == name
+		}
+
+		if !found {
+			t.Errorf("Expected driver `%s` to be registered, got %v.", name, drivers)
+		}
+	}
+}
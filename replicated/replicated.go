@@ -0,0 +1,423 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package replicated provides a cache that writes to several caches at once
+// and reads from the first one that has the item.
+//
+// This can be used for redundancy, or to move to a new cache without starting
+// cold: put the new cache first and the old one second, and reads that miss the
+// new cache are served by the old one until the new one has caught up.
+package replicated
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/expiry"
+	"github.com/jelmersnoeck/cacher/item"
+)
+
+// Divergence describes an operation for which the backends didn't agree. Errs
+// holds the result of every backend, in the order they were passed to `New()`.
+// For reads, the backends after the one that served the item aren't consulted
+// and have no entry.
+type Divergence struct {
+	Op   string
+	Key  string
+	Errs []error
+}
+
+// Cache is a caching implementation that replicates all writes to a set of
+// caches.
+type Cache struct {
+	// Forwarder forwards scans, stats and locks to primary.
+	cacher.Forwarder
+
+	backends     []cacher.Cacher
+	quorum       int
+	onDivergence func(Divergence)
+}
+
+// New creates a new instance of Cache. Writes are sent to primary and all of
+// the secondaries, and succeed when at least quorum of them succeed. A quorum
+// of 0, or one larger than the number of caches, requires all of them to
+// succeed. Reads go to primary, and only fall back to the secondaries, in
+// order, when primary misses or fails.
+func New(quorum int, primary cacher.Cacher, secondaries ...cacher.Cacher) *Cache {
+	cache := new(Cache)
+	cache.Forwarder = cacher.NewForwarder(primary)
+	cache.backends = append([]cacher.Cacher{primary}, secondaries...)
+	cache.quorum = quorum
+	if quorum <= 0 || quorum > len(cache.backends) {
+		cache.quorum = len(cache.backends)
+	}
+
+	return cache
+}
+
+// OnDivergence registers a function that is called every time the backends
+// don't agree on the outcome of an operation: when a write succeeds on some
+// backends and fails on others, or when a read misses primary but is served by
+// a secondary.
+func (c *Cache) OnDivergence(fn func(Divergence)) {
+	c.onDivergence = fn
+}
+
+// Add adds the item to all the caches.
+func (c *Cache) Add(key string, value []byte, ttl int64) error {
+	return c.write("Add", key, func(b cacher.Cacher) error {
+		return b.Add(key, value, ttl)
+	})
+}
+
+// CompareAndReplace validates the token with primary, as tokens are specific
+// to a cache, and replaces the value there. When that succeeds, the value is
+// set in all the secondaries.
+func (c *Cache) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	if err := c.backends[0].CompareAndReplace(token, key, value, ttl); err != nil {
+		return err
+	}
+
+	errs := make([]error, len(c.backends))
+	c.each(c.backends[1:], func(i int, b cacher.Cacher) {
+		errs[i+1] = b.Set(key, value, ttl)
+	})
+
+	return c.result("CompareAndReplace", key, errs)
+}
+
+// Set sets the value in all the caches.
+func (c *Cache) Set(key string, value []byte, ttl int64) error {
+	return c.write("Set", key, func(b cacher.Cacher) error {
+		return b.Set(key, value, ttl)
+	})
+}
+
+// SetMulti sets multiple values in all the caches.
+func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	keys := make([]string, 0, len(items))
+	for key := range items {
+		keys = append(keys, key)
+	}
+
+	return c.writeMulti("SetMulti", keys, func(b cacher.Cacher) map[string]error {
+		return b.SetMulti(items, ttl)
+	})
+}
+
+// Replace replaces the value in all the caches.
+func (c *Cache) Replace(key string, value []byte, ttl int64) error {
+	return c.write("Replace", key, func(b cacher.Cacher) error {
+		return b.Replace(key, value, ttl)
+	})
+}
+
+// Increment increments the value in all the caches.
+func (c *Cache) Increment(key string, initial, offset, ttl int64) error {
+	return c.write("Increment", key, func(b cacher.Cacher) error {
+		return b.Increment(key, initial, offset, ttl)
+	})
+}
+
+// Decrement decrements the value in all the caches.
+func (c *Cache) Decrement(key string, initial, offset, ttl int64) error {
+	return c.write("Decrement", key, func(b cacher.Cacher) error {
+		return b.Decrement(key, initial, offset, ttl)
+	})
+}
+
+// Delete removes the item from all the caches.
+func (c *Cache) Delete(key string) error {
+	return c.write("Delete", key, func(b cacher.Cacher) error {
+		return b.Delete(key)
+	})
+}
+
+// DeleteMulti removes the items from all the caches.
+func (c *Cache) DeleteMulti(keys []string) map[string]error {
+	return c.writeMulti("DeleteMulti", keys, func(b cacher.Cacher) map[string]error {
+		return b.DeleteMulti(keys)
+	})
+}
+
+// Get gets the value from primary. If primary misses or fails, the
+// secondaries are tried in order. The error of primary is returned when none of
+// the caches have the item.
+//
+// An item served by a secondary is added to primary, see `backfill()`, so the
+// token can be used with `CompareAndReplace()`.
+func (c *Cache) Get(key string) ([]byte, string, error) {
+	var errs []error
+	for i, b := range c.backends {
+		value, token, err := b.Get(key)
+		errs = append(errs, err)
+		if err == nil {
+			if i > 0 {
+				c.diverged("Get", key, errs)
+				value, token = c.backfill(b, key, value)
+			}
+			return value, token, nil
+		}
+	}
+
+	return nil, "", errs[0]
+}
+
+// GetMulti gets the values from primary. The keys primary misses or fails on
+// are requested from the secondaries, in order, and added to primary like
+// `Get()` does.
+func (c *Cache) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	items, tokens, errs := c.backends[0].GetMulti(keys)
+	if items == nil {
+		items = make(map[string][]byte)
+	}
+	if tokens == nil {
+		tokens = make(map[string]string)
+	}
+	if errs == nil {
+		errs = make(map[string]error)
+	}
+
+	history := make(map[string][]error)
+	var missing []string
+	for _, key := range keys {
+		if _, ok := items[key]; !ok && errs[key] == nil {
+			errs[key] = errors.NewNotFound(key)
+		}
+
+		if errs[key] != nil {
+			missing = append(missing, key)
+			history[key] = []error{errs[key]}
+		}
+	}
+
+	for _, b := range c.backends[1:] {
+		if len(missing) == 0 {
+			break
+		}
+
+		bItems, _, bErrs := b.GetMulti(missing)
+		var next []string
+		for _, key := range missing {
+			history[key] = append(history[key], bErrs[key])
+			if bErrs[key] != nil {
+				next = append(next, key)
+				continue
+			}
+
+			c.diverged("GetMulti", key, history[key])
+			items[key], tokens[key] = c.backfill(b, key, bItems[key])
+			errs[key] = nil
+		}
+		missing = next
+	}
+
+	return items, tokens, errs
+}
+
+// Flush removes all the items from all the caches.
+func (c *Cache) Flush() error {
+	return c.write("Flush", "", func(b cacher.Cacher) error {
+		return b.Flush()
+	})
+}
+
+// Touch updates the ttl of the item in all the caches.
+func (c *Cache) Touch(key string, ttl int64) error {
+	return c.write("Touch", key, func(b cacher.Cacher) error {
+		return b.Touch(key, ttl)
+	})
+}
+
+// Close closes all the caches and returns the first error.
+func (c *Cache) Close() error {
+	errs := make([]error, len(c.backends))
+	c.each(c.backends, func(i int, b cacher.Cacher) {
+		errs[i] = cacher.Close(b)
+	})
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Ping checks all the caches concurrently and succeeds when at least quorum of
+// them can be reached. Otherwise the error of primary is returned, or the first
+// error if primary can be reached.
+func (c *Cache) Ping(ctx context.Context) error {
+	errs := make([]error, len(c.backends))
+	c.each(c.backends, func(i int, b cacher.Cacher) {
+		errs[i] = cacher.Ping(ctx, b)
+	})
+
+	var reachable int
+	var first error
+	for _, err := range errs {
+		if err == nil {
+			reachable++
+		} else if first == nil {
+			first = err
+		}
+	}
+
+	if reachable >= c.quorum {
+		return nil
+	}
+
+	if errs[0] != nil {
+		return errs[0]
+	}
+
+	return first
+}
+
+// Capabilities returns the capabilities of primary, without snapshots. The
+// cache can always be closed and pinged.
+func (c *Cache) Capabilities() cacher.Capability {
+	return c.Forwarder.Capabilities()&^cacher.CanSnapshot | cacher.CanClose | cacher.CanPing
+}
+
+// Snapshot isn't supported, as a snapshot of primary couldn't be restored into
+// the secondaries.
+func (c *Cache) Snapshot(w io.Writer) error {
+	return errors.NewUnsupported("Snapshot")
+}
+
+// Restore isn't supported, as restoring primary would leave the secondaries
+// diverged.
+func (c *Cache) Restore(r io.Reader) error {
+	return errors.NewUnsupported("Restore")
+}
+
+// write runs fn against all the backends concurrently and applies the quorum
+// to the results.
+func (c *Cache) write(op, key string, fn func(cacher.Cacher) error) error {
+	errs := make([]error, len(c.backends))
+	c.each(c.backends, func(i int, b cacher.Cacher) {
+		errs[i] = fn(b)
+	})
+
+	return c.result(op, key, errs)
+}
+
+// writeMulti runs fn against all the backends concurrently and applies the
+// quorum to the results of every key.
+func (c *Cache) writeMulti(op string, keys []string, fn func(cacher.Cacher) map[string]error) map[string]error {
+	results := make([]map[string]error, len(c.backends))
+	c.each(c.backends, func(i int, b cacher.Cacher) {
+		results[i] = fn(b)
+	})
+
+	errs := make(map[string]error)
+	for _, key := range keys {
+		keyErrs := make([]error, len(c.backends))
+		for i := range c.backends {
+			keyErrs[i] = results[i][key]
+		}
+		errs[key] = c.result(op, key, keyErrs)
+	}
+
+	return errs
+}
+
+// result reports a divergence when the backends had different outcomes and
+// returns nil if enough of them succeeded. Otherwise the error of primary is
+// returned, or the first error if primary succeeded.
+func (c *Cache) result(op, key string, errs []error) error {
+	var succeeded int
+	var first error
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else if first == nil {
+			first = err
+		}
+	}
+
+	if succeeded > 0 && succeeded < len(errs) {
+		c.diverged(op, key, errs)
+	}
+
+	if succeeded >= c.quorum {
+		return nil
+	}
+
+	if errs[0] != nil {
+		return errs[0]
+	}
+
+	return first
+}
+
+// backfill adds the value of key, which was served by the secondary b, to
+// primary and returns it as primary has it, with a token of primary. The item
+// expires in primary when it does in b, so it is only added when b is a
+// `cacher.ItemGetter` that can tell. Otherwise, or when primary can't be
+// written to or read from, the value is returned without a token, as a token
+// of b can't be used to replace the item in primary.
+func (c *Cache) backfill(b cacher.Cacher, key string, value []byte) ([]byte, string) {
+	ttl, ok := remainingTTL(b, key)
+	if !ok {
+		return value, ""
+	}
+
+	primary := c.backends[0]
+	if err := primary.Add(key, value, ttl); err != nil && !errors.Is(err, errors.ErrExists) {
+		return value, ""
+	}
+
+	current, token, err := primary.Get(key)
+	if err != nil {
+		return value, ""
+	}
+
+	return current, token
+}
+
+// remainingTTL returns the ttl key has left in b, or 0 if it doesn't expire. It
+// returns false if b can't tell.
+func remainingTTL(b cacher.Cacher, key string) (int64, bool) {
+	ig, ok := b.(cacher.ItemGetter)
+	if !ok {
+		return 0, false
+	}
+
+	ttl, err := ig.TTL(key)
+	if err != nil {
+		return 0, false
+	}
+
+	if ttl == item.NoExpiry {
+		return 0, true
+	}
+
+	return expiry.In(ttl).Seconds(), true
+}
+
+func (c *Cache) diverged(op, key string, errs []error) {
+	if c.onDivergence != nil {
+		c.onDivergence(Divergence{Op: op, Key: key, Errs: errs})
+	}
+}
+
+// each calls fn for every backend in its own goroutine and waits for all of
+// them to return.
+func (c *Cache) each(backends []cacher.Cacher, fn func(int, cacher.Cacher)) {
+	var wg sync.WaitGroup
+	wg.Add(len(backends))
+	for i, b := range backends {
+		go func(i int, b cacher.Cacher) {
+			defer wg.Done()
+			fn(i, b)
+		}(i, b)
+	}
+	wg.Wait()
+}
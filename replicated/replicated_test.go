@@ -0,0 +1,148 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package replicated_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/memory"
+	"github.com/jelmersnoeck/cacher/noop"
+	"github.com/jelmersnoeck/cacher/replicated"
+)
+
+// failing is a cache on which every write fails.
+type failing struct {
+	noop.Cache
+}
+
+func (f *failing) Set(key string, value []byte, ttl int64) error {
+	return errors.New("unavailable")
+}
+
+func (f *failing) Ping(ctx context.Context) error {
+	return errors.New("unavailable")
+}
+
+// nilMaps is a cache whose GetMulti returns nil maps.
+type nilMaps struct {
+	noop.Cache
+}
+
+func (n *nilMaps) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	return nil, nil, nil
+}
+
+func TestReplicatesWrites(t *testing.T) {
+	primary, secondary := memory.New(0), memory.New(0)
+	cache := replicated.New(0, primary, secondary)
+
+	cache.Set("key1", []byte("value1"), 0)
+
+	for _, c := range []*memory.Cache{primary, secondary} {
+		if v, _, _ := c.Get("key1"); string(v) != "value1" {
+			t.Errorf("Expected `key1` to be written to every cache.")
+			t.FailNow()
+		}
+	}
+}
+
+func TestQuorum(t *testing.T) {
+	var divergences []replicated.Divergence
+	cache := replicated.New(1, memory.New(0), &failing{})
+	cache.OnDivergence(func(d replicated.Divergence) {
+		divergences = append(divergences, d)
+	})
+
+	if err := cache.Set("key1", []byte("value1"), 0); err != nil {
+		t.Errorf("Expected Set to meet a quorum of 1, got `%s`.", err)
+		t.FailNow()
+	}
+
+	if len(divergences) != 1 || divergences[0].Op != "Set" || divergences[0].Errs[1] == nil {
+		t.Errorf("Expected the failed write to be reported, got %v.", divergences)
+		t.FailNow()
+	}
+
+	cache = replicated.New(2, memory.New(0), &failing{})
+	if err := cache.Set("key1", []byte("value1"), 0); err == nil {
+		t.Errorf("Expected Set not to meet a quorum of 2.")
+		t.FailNow()
+	}
+}
+
+func TestReadFallback(t *testing.T) {
+	primary, secondary := memory.New(0), memory.New(0)
+	secondary.Set("key1", []byte("value1"), 0)
+	secondary.Set("key3", []byte("value3"), 0)
+
+	var divergences []replicated.Divergence
+	cache := replicated.New(0, primary, secondary)
+	cache.OnDivergence(func(d replicated.Divergence) {
+		divergences = append(divergences, d)
+	})
+
+	v, token, err := cache.Get("key1")
+	if err != nil || string(v) != "value1" {
+		t.Errorf("Expected `key1` to be read from the secondary cache.")
+		t.FailNow()
+	}
+
+	values, _, errs := cache.GetMulti([]string{"key3", "key2"})
+	if string(values["key3"]) != "value3" || errs["key2"] == nil {
+		t.Errorf("Expected GetMulti to fall back for `key3` and miss `key2`.")
+		t.FailNow()
+	}
+
+	if len(divergences) != 2 {
+		t.Errorf("Expected 2 divergences to be reported, got %d.", len(divergences))
+		t.FailNow()
+	}
+
+	for _, key := range []string{"key1", "key3"} {
+		if _, _, err := primary.Get(key); err != nil {
+			t.Errorf("Expected `%s` to be added to the primary cache, got `%s`.", key, err)
+		}
+	}
+
+	if err := cache.CompareAndReplace(token, "key1", []byte("value2"), 0); err != nil {
+		t.Errorf("Expected the token of a fallback read to replace the value, got `%s`.", err)
+	}
+}
+
+func TestReadFallbackUnknownTTL(t *testing.T) {
+	primary, secondary := memory.New(0), memory.New(0)
+	secondary.Set("key1", []byte("value1"), 60)
+
+	// The struct hides that the secondary can tell the ttl of its items.
+	cache := replicated.New(0, primary, struct{ cacher.Cacher }{secondary})
+	if v, token, err := cache.Get("key1"); err != nil || string(v) != "value1" || token != "" {
+		t.Errorf("Expected `key1` to be read from the secondary cache without a token.")
+		t.FailNow()
+	}
+
+	if _, _, err := primary.Get("key1"); err == nil {
+		t.Errorf("Expected `key1` not to be added to the primary cache without a ttl.")
+	}
+
+	cache = replicated.New(0, &nilMaps{}, secondary)
+	if values, _, errs := cache.GetMulti([]string{"key1"}); string(values["key1"]) != "value1" || errs["key1"] != nil {
+		t.Errorf("Expected GetMulti to fall back when the primary returns nil maps.")
+	}
+}
+
+func TestPing(t *testing.T) {
+	if err := replicated.New(1, memory.New(0), &failing{}).Ping(context.Background()); err != nil {
+		t.Errorf("Expected Ping to meet a quorum of 1, got `%s`.", err)
+		t.FailNow()
+	}
+
+	if err := replicated.New(2, memory.New(0), &failing{}).Ping(context.Background()); err == nil {
+		t.Errorf("Expected Ping not to meet a quorum of 2.")
+		t.FailNow()
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package sql
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+)
+
+// Dialect selects the SQL flavour used to talk to the database.
+type Dialect int
+
+const (
+	// SQLite uses `?` placeholders and `INSERT ... ON CONFLICT` upserts. It
+	// requires SQLite 3.35 or later.
+	SQLite Dialect = iota
+
+	// Postgres uses `$n` placeholders and `INSERT ... ON CONFLICT` upserts.
+	Postgres
+
+	// MySQL uses `?` placeholders and `INSERT ... ON DUPLICATE KEY UPDATE`
+	// upserts. As MySQL has no `RETURNING` clause, the affected row count is
+	// used to detect a successful increment.
+	MySQL
+)
+
+// createTable returns the statement that creates the cache table.
+func (d Dialect) createTable(table string) string {
+	var key, value, integer string
+	switch d {
+	case Postgres:
+		key, value, integer = "VARCHAR(250)", "BYTEA", "BIGINT"
+	case MySQL:
+		key, value, integer = "VARCHAR(250)", "LONGBLOB", "BIGINT"
+	default:
+		key, value, integer = "TEXT", "BLOB", "INTEGER"
+	}
+
+	return fmt.Sprintf(
+		"CREATE TABLE IF NOT EXISTS %s (cache_key %s NOT NULL PRIMARY KEY, value %s NOT NULL, counter %s, version %s NOT NULL, expires_at %s NOT NULL)",
+		table, key, value, integer, integer, integer,
+	)
+}
+
+// insertIgnore returns an insert statement that doesn't fail, but affects no
+// rows, when the key is already present.
+func (d Dialect) insertIgnore(table string) string {
+	switch d {
+	case MySQL:
+		return fmt.Sprintf("INSERT IGNORE INTO %s (%s) VALUES (?, ?, ?, ?, ?)", table, columns)
+	case Postgres:
+		return fmt.Sprintf("INSERT INTO %s (%s) VALUES (?, ?, ?, ?, ?) ON CONFLICT (cache_key) DO NOTHING", table, columns)
+	default:
+		return fmt.Sprintf("INSERT OR IGNORE INTO %s (%s) VALUES (?, ?, ?, ?, ?)", table, columns)
+	}
+}
+
+// upsert returns an insert statement that overwrites the value of an existing
+// key and bumps its version.
+func (d Dialect) upsert(table string) string {
+	if d == MySQL {
+		return fmt.Sprintf(
+			"INSERT INTO %s (%s) VALUES (?, ?, ?, ?, ?) ON DUPLICATE KEY UPDATE value = VALUES(value), counter = VALUES(counter), version = version + 1, expires_at = VALUES(expires_at)",
+			table, columns,
+		)
+	}
+
+	return fmt.Sprintf(
+		"INSERT INTO %s (%s) VALUES (?, ?, ?, ?, ?) ON CONFLICT (cache_key) DO UPDATE SET value = excluded.value, counter = excluded.counter, version = %s.version + 1, expires_at = excluded.expires_at",
+		table, columns, table,
+	)
+}
+
+// returning reports whether the dialect supports `UPDATE ... RETURNING`.
+func (d Dialect) returning() bool {
+	return d != MySQL
+}
+
+// rebind rewrites the `?` placeholders in query to the style of the dialect.
+func (d Dialect) rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+
+	var buf bytes.Buffer
+	n := 0
+	for i := 0; i < len(query); i++ {
+		if query[i] != '?' {
+			buf.WriteByte(query[i])
+			continue
+		}
+
+		n++
+		buf.WriteByte('$')
+		buf.WriteString(strconv.Itoa(n))
+	}
+
+	return buf.String()
+}
@@ -0,0 +1,75 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package sql
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/internal/dsn"
+)
+
+func init() {
+	cacher.Register("sql", open)
+}
+
+var dialectNames = map[string]Dialect{
+	"":         SQLite,
+	"sqlite":   SQLite,
+	"postgres": Postgres,
+	"mysql":    MySQL,
+}
+
+// open creates a cache from a URL like
+// `sql://postgres?dsn=host%3Ddb%20dbname%3Dapp&dialect=postgres&table=cache`.
+// The host is the name of the `database/sql` driver, which has to be imported,
+// and `dsn` its data source name. `dialect`, `table` and `purge_interval` set
+// the fields of `Options`, `create_table=true` creates the table if it doesn't
+// exist. The database handle is closed with the cache.
+func open(u *url.URL) (cacher.Cacher, error) {
+	var opts Options
+	var err error
+	q := u.Query()
+
+	dialect, ok := dialectNames[q.Get("dialect")]
+	if !ok {
+		return nil, fmt.Errorf("sql: unknown dialect `%s`", q.Get("dialect"))
+	}
+	opts.Dialect = dialect
+	opts.Table = q.Get("table")
+	if opts.Table != "" {
+		if err := validTable(opts.Table); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.PurgeInterval, err = dsn.Duration(q, "purge_interval"); err != nil {
+		return nil, fmt.Errorf("sql: %s", err)
+	}
+
+	create, err := dsn.Bool(q, "create_table")
+	if err != nil {
+		return nil, fmt.Errorf("sql: %s", err)
+	}
+
+	db, err := sql.Open(u.Host, q.Get("dsn"))
+	if err != nil {
+		return nil, err
+	}
+
+	cache := New(db, opts)
+	cache.ownsDB = true
+
+	if create {
+		if err := cache.CreateTable(); err != nil {
+			cache.Close()
+			return nil, err
+		}
+	}
+
+	return cache, nil
+}
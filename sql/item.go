@@ -0,0 +1,48 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package sql
+
+import (
+	"time"
+
+	"github.com/jelmersnoeck/cacher/item"
+)
+
+// GetItem gets the value associated with the provided key together with its
+// remaining TTL, which are read from the same row. The table doesn't keep track
+// of when a value was written, so the timestamps of the item are left empty.
+func (c *Cache) GetItem(key string) (*item.Item, error) {
+	r, err := c.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	value := r.bytes()
+	it := &item.Item{
+		Key:   key,
+		Value: value,
+		Token: r.token(),
+		TTL:   item.NoExpiry,
+		Size:  len(value),
+	}
+
+	if r.expiresAt != 0 {
+		it.Expiry = time.Unix(0, r.expiresAt*int64(time.Millisecond))
+		it.TTL = it.Expiry.Sub(time.Now())
+	}
+
+	return it, nil
+}
+
+// TTL returns the time the item stored under key has left, or
+// `item.NoExpiry` if it doesn't expire.
+func (c *Cache) TTL(key string) (time.Duration, error) {
+	it, err := c.GetItem(key)
+	if err != nil {
+		return 0, err
+	}
+
+	return it.TTL, nil
+}
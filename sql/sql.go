@@ -0,0 +1,604 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package sql provides a cache that stores its data in a table of a relational
+// database, accessed through `database/sql`.
+//
+// Every row carries a version which is bumped on each write. The version is
+// used as the CAS token, so CompareAndReplace is a single conditional UPDATE.
+// Values that are valid integers are mirrored in a numeric column, which lets
+// Increment and Decrement run as a single atomic UPDATE as well.
+package sql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/internal/encoding"
+)
+
+const columns = "cache_key, value, counter, version, expires_at"
+
+// live is the condition that filters out expired rows. It takes the current
+// time in milliseconds as its only argument.
+const live = "(expires_at = 0 OR expires_at > ?)"
+
+// incrementAttempts is how many times an increment is tried while the row keeps
+// changing under it.
+const incrementAttempts = 3
+
+// Options configures a Cache. The zero value of every field selects a sensible
+// default.
+type Options struct {
+	// Table is the name of the table the items are stored in, optionally
+	// qualified by a schema. It is part of every query, so it may only hold
+	// letters, digits, underscores and dots. Defaults to `cache`.
+	Table string
+
+	// Dialect is the SQL flavour of the database. Defaults to SQLite.
+	Dialect Dialect
+
+	// PurgeInterval is how often rows that have expired are deleted from the
+	// table. Defaults to one minute, a negative value disables purging.
+	PurgeInterval time.Duration
+}
+
+type queries struct {
+	get, getMulti                string
+	insert, upsert               string
+	replace, compareAndReplace   string
+	increment, touch             string
+	delete, deleteExpired, purge string
+	flush                        string
+}
+
+// Cache is a caching implementation that stores the data in a database table.
+type Cache struct {
+	db      *sql.DB
+	opts    Options
+	queries queries
+	done    chan struct{}
+	once    sync.Once
+
+	// ownsDB is set when the cache opened db itself, so it closes it too.
+	ownsDB bool
+}
+
+// New creates a new instance of Cache which stores its items in the table
+// configured in opts. The table can be created with `CreateTable()`. New panics
+// if the name of the table isn't a valid identifier.
+func New(db *sql.DB, opts Options) *Cache {
+	if opts.Table == "" {
+		opts.Table = "cache"
+	}
+	if err := validTable(opts.Table); err != nil {
+		panic(err)
+	}
+	if opts.PurgeInterval == 0 {
+		opts.PurgeInterval = time.Minute
+	}
+
+	d, t := opts.Dialect, opts.Table
+	update := "UPDATE " + t + " SET value = ?, counter = ?, version = version + 1, expires_at = ? WHERE cache_key = ? AND " + live
+	increment := "UPDATE " + t + " SET counter = counter + ?, version = version + 1, expires_at = ? WHERE cache_key = ? AND " + live + " AND counter IS NOT NULL AND counter + ? >= 0"
+	if d.returning() {
+		increment += " RETURNING counter"
+	}
+
+	cache := new(Cache)
+	cache.db = db
+	cache.opts = opts
+	cache.queries = queries{
+		get:               d.rebind("SELECT value, counter, version, expires_at FROM " + t + " WHERE cache_key = ? AND " + live),
+		getMulti:          "SELECT cache_key, value, counter, version FROM " + t + " WHERE cache_key IN (",
+		insert:            d.rebind(d.insertIgnore(t)),
+		upsert:            d.rebind(d.upsert(t)),
+		replace:           d.rebind(update),
+		compareAndReplace: d.rebind(update + " AND version = ?"),
+		increment:         d.rebind(increment),
+		touch:             d.rebind("UPDATE " + t + " SET expires_at = ? WHERE cache_key = ? AND " + live),
+		delete:            d.rebind("DELETE FROM " + t + " WHERE cache_key = ? AND " + live),
+		deleteExpired:     d.rebind("DELETE FROM " + t + " WHERE cache_key = ? AND expires_at <> 0 AND expires_at <= ?"),
+		purge:             d.rebind("DELETE FROM " + t + " WHERE expires_at <> 0 AND expires_at <= ?"),
+		flush:             "DELETE FROM " + t,
+	}
+
+	if opts.PurgeInterval > 0 {
+		cache.done = make(chan struct{})
+		go cache.purgeLoop()
+	}
+
+	return cache
+}
+
+// tablePattern matches the table names New accepts.
+var tablePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// validTable returns an error if table can't be used as the name of the table.
+func validTable(table string) error {
+	if !tablePattern.MatchString(table) {
+		return fmt.Errorf("sql: invalid table name `%s`", table)
+	}
+
+	return nil
+}
+
+// CreateTable creates the table the cache stores its items in, if it doesn't
+// exist yet.
+func (c *Cache) CreateTable() error {
+	_, err := c.db.Exec(c.opts.Dialect.createTable(c.opts.Table))
+
+	return err
+}
+
+// Add an item to the cache. If the item is already cached, the value won't be
+// overwritten.
+//
+// See the `Set()` function for ttl information.
+func (c *Cache) Add(key string, value []byte, ttl int64) error {
+	if ttl < 0 {
+		if _, err := c.get(key); err == nil {
+			return errors.NewAlreadyExistingKey(key)
+		}
+		return c.Delete(key)
+	}
+
+	added, err := c.add(key, value, ttl)
+	if err != nil {
+		return err
+	}
+
+	if !added {
+		return errors.NewAlreadyExistingKey(key)
+	}
+
+	return nil
+}
+
+// Set sets the value of an item, regardless of wether or not the value is
+// already cached.
+//
+// ttl defines the number of seconds the value should be cached. If ttl is 0,
+// the item will be cached infinitely. If ttl is < 0, the value will be deleted
+// from the cache using the `Delete()` function.
+func (c *Cache) Set(key string, value []byte, ttl int64) error {
+	if ttl < 0 {
+		return c.Delete(key)
+	}
+
+	_, err := c.db.Exec(c.queries.upsert, insertArgs(key, value, ttl)...)
+	return err
+}
+
+// SetMulti sets multiple values for their respective keys in a single
+// transaction.
+func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	results := make(map[string]error)
+
+	if ttl < 0 {
+		for key := range items {
+			results[key] = c.Delete(key)
+		}
+		return results
+	}
+
+	tx, err := c.db.Begin()
+	if err != nil {
+		for key := range items {
+			results[key] = err
+		}
+		return results
+	}
+
+	for key, value := range items {
+		_, results[key] = tx.Exec(c.queries.upsert, insertArgs(key, value, ttl)...)
+	}
+
+	if err := tx.Commit(); err != nil {
+		for key := range items {
+			results[key] = err
+		}
+	}
+
+	return results
+}
+
+// CompareAndReplace validates the token, which is the version of the row, with
+// the version in the table. If they match, the value will be replaced and the
+// version bumped in a single statement.
+func (c *Cache) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	version, err := strconv.ParseInt(token, 10, 64)
+	if err != nil {
+		return c.conflict(key)
+	}
+
+	if ttl < 0 {
+		return c.Delete(key)
+	}
+
+	args := append(updateArgs(key, value, ttl), version)
+	err = c.affected(key, c.queries.compareAndReplace, args...)
+	if errors.Is(err, errors.ErrNotFound) {
+		return c.conflict(key)
+	}
+
+	return err
+}
+
+// Replace will update and only update the value of a cache key. If the key is
+// not previously used, we will return false.
+func (c *Cache) Replace(key string, value []byte, ttl int64) error {
+	if ttl < 0 {
+		return c.Delete(key)
+	}
+
+	return c.affected(key, c.queries.replace, updateArgs(key, value, ttl)...)
+}
+
+// Get gets the value out of the table associated with the provided key. The
+// token is the version of the row.
+func (c *Cache) Get(key string) ([]byte, string, error) {
+	r, err := c.get(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return r.bytes(), r.token(), nil
+}
+
+// GetMulti gets multiple values from the table in a single query and returns
+// them as a map.
+func (c *Cache) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	items := make(map[string][]byte)
+	errs := make(map[string]error)
+	tokens := make(map[string]string)
+
+	for _, key := range keys {
+		errs[key] = errors.NewNotFound(key)
+	}
+
+	if len(keys) == 0 {
+		return items, tokens, errs
+	}
+
+	args := make([]interface{}, 0, len(keys)+1)
+	for _, key := range keys {
+		args = append(args, key)
+	}
+	args = append(args, now())
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(keys)), ", ")
+	query := c.opts.Dialect.rebind(c.queries.getMulti + placeholders + ") AND " + live)
+
+	rows, err := c.db.Query(query, args...)
+	if err != nil {
+		for _, key := range keys {
+			errs[key] = err
+		}
+		return items, tokens, errs
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var r row
+		if err := rows.Scan(&key, &r.value, &r.counter, &r.version); err != nil {
+			errs[key] = err
+			continue
+		}
+
+		items[key], tokens[key], errs[key] = r.bytes(), r.token(), nil
+	}
+
+	// The keys that weren't read before the rows broke off may exist.
+	if err := rows.Err(); err != nil {
+		for _, key := range keys {
+			if _, ok := items[key]; !ok {
+				errs[key] = err
+			}
+		}
+	}
+
+	return items, tokens, errs
+}
+
+// Increment adds a value of offset to the initial value. If the initial value
+// is already set, it will be added to the value currently stored in the cache.
+//
+// Initial value and offset can't be below 0. When the row keeps changing while
+// it is being incremented, an errors.CASConflict is returned after a few
+// attempts. The counter is left as it is, so the call can be retried.
+func (c *Cache) Increment(key string, initial, offset, ttl int64) error {
+	if initial < 0 || offset <= 0 {
+		return errors.NewInvalidRange(initial, offset)
+	}
+
+	return c.incrementOffset(key, initial, offset, ttl)
+}
+
+// Decrement subtracts a value of offset to the initial value. If the initial
+// value is already set, it will be added to the value currently stored in the
+// cache.
+//
+// Initial value and offset can't be below 0. Like `Increment()`, it returns an
+// errors.CASConflict when the row keeps changing.
+func (c *Cache) Decrement(key string, initial, offset, ttl int64) error {
+	if initial < 0 || offset <= 0 {
+		return errors.NewInvalidRange(initial, offset)
+	}
+
+	return c.incrementOffset(key, initial, offset*-1, ttl)
+}
+
+// Flush will remove all the rows from the table.
+func (c *Cache) Flush() error {
+	_, err := c.db.Exec(c.queries.flush)
+
+	return err
+}
+
+// Delete will validate if the key actually is stored in the cache. If it is
+// stored, it will remove the item from the cache. If it is not stored, it will
+// return false.
+func (c *Cache) Delete(key string) error {
+	res, err := c.db.Exec(c.queries.delete, key, now())
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.NewNotFound(key)
+	}
+
+	return nil
+}
+
+// DeleteMulti will delete multiple values at a time. It uses the `Delete`
+// method internally to do so. It will return a map of results to see if the
+// deletion is successful.
+func (c *Cache) DeleteMulti(keys []string) map[string]error {
+	results := make(map[string]error)
+
+	for _, key := range keys {
+		results[key] = c.Delete(key)
+	}
+
+	return results
+}
+
+// Touch will update the key's ttl to the given ttl value without altering the
+// value.
+func (c *Cache) Touch(key string, ttl int64) error {
+	if ttl < 0 {
+		return c.Delete(key)
+	}
+
+	return c.affected(key, c.queries.touch, expiresAt(ttl), key, now())
+}
+
+// Purge deletes all the rows that have expired. Purge is called periodically
+// in the background, see `Options`, but can be called manually as well.
+func (c *Cache) Purge() error {
+	_, err := c.db.Exec(c.queries.purge, now())
+
+	return err
+}
+
+// Close stops the background purge process. The database handle is owned by
+// the caller and is left open, unless the cache was created with
+// `cacher.Open()`.
+func (c *Cache) Close() error {
+	var err error
+	c.once.Do(func() {
+		if c.done != nil {
+			close(c.done)
+		}
+
+		if c.ownsDB {
+			err = c.db.Close()
+		}
+	})
+
+	return err
+}
+
+// Ping checks whether the database can be reached.
+func (c *Cache) Ping(ctx context.Context) error {
+	return c.db.PingContext(ctx)
+}
+
+// add inserts the item unless a live row for key exists already. A row for key
+// that has expired but hasn't been purged yet is removed first.
+func (c *Cache) add(key string, value []byte, ttl int64) (bool, error) {
+	if _, err := c.db.Exec(c.queries.deleteExpired, key, now()); err != nil {
+		return false, err
+	}
+
+	res, err := c.db.Exec(c.queries.insert, insertArgs(key, value, ttl)...)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// get fetches the live row for key.
+func (c *Cache) get(key string) (row, error) {
+	var r row
+	err := c.db.QueryRow(c.queries.get, key, now()).Scan(&r.value, &r.counter, &r.version, &r.expiresAt)
+	if err == sql.ErrNoRows {
+		return r, errors.NewNotFound(key)
+	}
+
+	return r, err
+}
+
+// conflict returns the error for a token that doesn't match the row of key,
+// which is a NotFound error if there is no live row.
+func (c *Cache) conflict(key string) error {
+	if _, err := c.get(key); err != nil {
+		return err
+	}
+
+	return errors.NewCASConflict(key)
+}
+
+// affected runs an update statement for key and translates the absence of
+// affected rows to a NotFound error.
+func (c *Cache) affected(key, query string, args ...interface{}) error {
+	res, err := c.db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return errors.NewNotFound(key)
+	}
+
+	return nil
+}
+
+// increment adds offset to the counter of key in a single statement. It
+// returns false when no row was updated, because the key doesn't exist, isn't
+// numeric or would drop below zero.
+func (c *Cache) increment(key string, offset, ttl int64) (bool, error) {
+	args := []interface{}{offset, expiresAt(ttl), key, now(), offset}
+
+	if c.opts.Dialect.returning() {
+		var counter int64
+		err := c.db.QueryRow(c.queries.increment, args...).Scan(&counter)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	res, err := c.db.Exec(c.queries.increment, args...)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// incrementOffset is a common incrementor method used between Increment and
+// Decrement. If the key isn't set before, we will set the initial value. If
+// there is a value present, we will add the given offset to that value and
+// update the value with the new TTL.
+//
+// When the atomic update doesn't apply, the row is inspected to find out why.
+// If it turns out the row changed in the meantime, the update is retried.
+func (c *Cache) incrementOffset(key string, initial, offset, ttl int64) error {
+	for attempt := 0; attempt < incrementAttempts; attempt++ {
+		if ok, err := c.increment(key, offset, ttl); err != nil || ok {
+			return err
+		}
+
+		r, err := c.get(key)
+		if errors.Is(err, errors.ErrNotFound) {
+			if added, err := c.add(key, encoding.Int64Bytes(initial), ttl); err != nil || added {
+				return err
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if !r.counter.Valid {
+			return errors.NewEncoding(key)
+		}
+
+		if r.counter.Int64+offset < 0 {
+			return errors.NewValueBelowZero(key)
+		}
+	}
+
+	return errors.NewCASConflict(key)
+}
+
+// purgeLoop periodically purges the expired rows.
+func (c *Cache) purgeLoop() {
+	ticker := time.NewTicker(c.opts.PurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.Purge()
+		}
+	}
+}
+
+// row holds the columns of a cache row.
+type row struct {
+	value     []byte
+	counter   sql.NullInt64
+	version   int64
+	expiresAt int64
+}
+
+// bytes returns the value of the row. Counters are stored in their own column
+// and are formatted the same way `Set` would store them.
+func (r row) bytes() []byte {
+	if r.counter.Valid {
+		return encoding.Int64Bytes(r.counter.Int64)
+	}
+
+	return r.value
+}
+
+func (r row) token() string {
+	return strconv.FormatInt(r.version, 10)
+}
+
+// counterOf returns the numeric representation of value, if value is exactly
+// how `encoding.Int64Bytes` would format a number.
+func counterOf(value []byte) sql.NullInt64 {
+	n, ok := encoding.BytesInt64(value)
+	if !ok || !bytes.Equal(value, encoding.Int64Bytes(n)) {
+		return sql.NullInt64{}
+	}
+
+	return sql.NullInt64{Int64: n, Valid: true}
+}
+
+func insertArgs(key string, value []byte, ttl int64) []interface{} {
+	// A new row starts at a time based version, so a token for a key that
+	// was deleted doesn't match the key once it's added again.
+	return []interface{}{key, value, counterOf(value), time.Now().UnixNano(), expiresAt(ttl)}
+}
+
+func updateArgs(key string, value []byte, ttl int64) []interface{} {
+	return []interface{}{value, counterOf(value), expiresAt(ttl), key, now()}
+}
+
+// expiresAt converts a ttl in seconds to an absolute time in milliseconds, 0
+// meaning the row never expires.
+func expiresAt(ttl int64) int64 {
+	if ttl <= 0 {
+		return 0
+	}
+
+	return now() + ttl*1000
+}
+
+func now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
@@ -0,0 +1,158 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package sql_test
+
+import (
+	dbsql "database/sql"
+	"testing"
+	"time"
+
+	"github.com/jelmersnoeck/cacher/internal/encoding"
+	"github.com/jelmersnoeck/cacher/internal/sqltest"
+	"github.com/jelmersnoeck/cacher/sql"
+)
+
+var dialects = map[string]sql.Dialect{
+	"sqlite":   sql.SQLite,
+	"postgres": sql.Postgres,
+	"mysql":    sql.MySQL,
+}
+
+func TestDialects(t *testing.T) {
+	for name, dialect := range dialects {
+		cache := newCache(t, name, dialect)
+
+		cache.Set("key1", []byte("value1"), 0)
+		if err := cache.Add("key1", []byte("value2"), 0); err == nil {
+			t.Errorf("%s: Expected `key1` not to be added twice.", name)
+			t.FailNow()
+		}
+
+		_, token, _ := cache.Get("key1")
+		if err := cache.CompareAndReplace(token, "key1", []byte("value3"), 0); err != nil {
+			t.Errorf("%s: Expected CompareAndReplace to succeed, got `%s`.", name, err)
+			t.FailNow()
+		}
+
+		if err := cache.CompareAndReplace(token, "key1", []byte("value4"), 0); err == nil {
+			t.Errorf("%s: Expected CompareAndReplace with a stale token to fail.", name)
+			t.FailNow()
+		}
+
+		cache.Increment("counter", 5, 1, 0)
+		cache.Increment("counter", 5, 2, 0)
+		if v, _, _ := cache.Get("counter"); string(v) != "7" {
+			t.Errorf("%s: Expected `counter` to equal 7, got `%s`.", name, v)
+			t.FailNow()
+		}
+
+		values, _, _ := cache.GetMulti([]string{"key1", "counter"})
+		if string(values["key1"]) != "value3" || string(values["counter"]) != "7" {
+			t.Errorf("%s: Expected GetMulti to return all values, got %v.", name, values)
+			t.FailNow()
+		}
+	}
+}
+
+func TestVersionToken(t *testing.T) {
+	cache := newCache(t, "version", sql.SQLite)
+
+	cache.Set("key1", []byte("value"), 0)
+	_, token1, _ := cache.Get("key1")
+
+	// The same value written again still invalidates the token.
+	cache.Set("key1", []byte("value"), 0)
+	_, token2, _ := cache.Get("key1")
+
+	if token1 == token2 {
+		t.Errorf("Expected every write to change the token.")
+		t.FailNow()
+	}
+
+	cache.Increment("key2", 1, 1, 0)
+	_, token3, _ := cache.Get("key2")
+	cache.Increment("key2", 1, 1, 0)
+	_, token4, _ := cache.Get("key2")
+
+	if token3 == token4 {
+		t.Errorf("Expected an increment to change the token.")
+		t.FailNow()
+	}
+}
+
+func TestIncrementNumericValue(t *testing.T) {
+	cache := newCache(t, "numeric", sql.SQLite)
+
+	cache.Set("key1", encoding.Int64Bytes(41), 0)
+	if err := cache.Increment("key1", 0, 1, 0); err != nil {
+		t.Errorf("Expected a numeric value to be incrementable, got `%s`.", err)
+		t.FailNow()
+	}
+
+	if v, _, _ := cache.Get("key1"); string(v) != "42" {
+		t.Errorf("Expected `key1` to equal 42, got `%s`.", v)
+		t.FailNow()
+	}
+
+	cache.Set("key2", []byte("042"), 0)
+	if err := cache.Increment("key2", 0, 1, 0); err == nil {
+		t.Errorf("Expected a value with leading zeros not to be treated as a counter.")
+		t.FailNow()
+	}
+}
+
+func TestPurge(t *testing.T) {
+	cache := newCache(t, "purge", sql.SQLite)
+
+	cache.Set("key1", []byte("value1"), 1)
+	cache.Set("key2", []byte("value2"), 0)
+	time.Sleep(1100 * time.Millisecond)
+
+	if _, _, err := cache.Get("key1"); err == nil {
+		t.Errorf("Expected `key1` to have expired.")
+		t.FailNow()
+	}
+
+	if err := cache.Add("key1", []byte("value3"), 0); err != nil {
+		t.Errorf("Expected an expired key to be addable, got `%s`.", err)
+		t.FailNow()
+	}
+
+	cache.Set("key3", []byte("value3"), 1)
+	time.Sleep(1100 * time.Millisecond)
+	cache.Purge()
+
+	if n := sqltest.Len("purge"); n != 2 {
+		t.Errorf("Expected 2 rows after purging, got %d.", n)
+		t.FailNow()
+	}
+}
+
+func newCache(t *testing.T, name string, dialect sql.Dialect) *sql.Cache {
+	db, err := dbsql.Open(sqltest.DriverName, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache := sql.New(db, sql.Options{Dialect: dialect, PurgeInterval: -1})
+	if err := cache.CreateTable(); err != nil {
+		t.Fatal(err)
+	}
+	cache.Flush()
+
+	return cache
+}
+
+func TestInvalidTable(t *testing.T) {
+	db, _ := dbsql.Open(sqltest.DriverName, "invalid")
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Expected New to refuse a table name that isn't an identifier.")
+		}
+	}()
+
+	sql.New(db, sql.Options{Table: "cache%s; --", PurgeInterval: -1})
+}
@@ -0,0 +1,311 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package stale provides a cache that keeps serving values for a while after
+// they expire, while they are refreshed in the background.
+//
+// Every value is stored with a soft expiry, after its ttl, and kept in the
+// other cache until its hard expiry, StaleTTL later. Between the two, Get
+// returns the stale value straight away and refreshes it with the loader in
+// the background (stale-while-revalidate). When the loader fails, the stale
+// value keeps being served until its hard expiry (stale-if-error).
+package stale
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/expiry"
+)
+
+// magic starts the header of a value with a soft expiry. It is followed by the
+// soft expiry in nanoseconds and the ttl the value was written with in
+// seconds, both as big endian int64.
+var magic = []byte{0xc0, 0x5e}
+
+const headerSize = 18
+
+// Options configures a Cache.
+type Options struct {
+	// StaleTTL is how long a value is kept after its soft expiry. Values
+	// expire as usual if it is 0.
+	StaleTTL time.Duration
+
+	// Load loads the value of key, to refresh a stale value. Stale values
+	// aren't refreshed if it is nil.
+	Load func(key string) ([]byte, error)
+}
+
+// Stats holds the number of reads by what they found.
+type Stats struct {
+	// FreshHits is the number of keys found before their soft expiry.
+	FreshHits uint64
+
+	// StaleHits is the number of keys found after their soft expiry, which
+	// were returned stale.
+	StaleHits uint64
+
+	// Misses is the number of keys not found.
+	Misses uint64
+}
+
+// Cache is a caching implementation that serves stale values from another
+// cache while it refreshes them. Refreshes run in the background, so the other
+// cache has to be safe for concurrent use when there is a loader.
+type Cache struct {
+	cacher.Forwarder
+
+	next cacher.Cacher
+	opts Options
+
+	mu         sync.Mutex
+	refreshing map[string]bool
+
+	freshHits uint64
+	staleHits uint64
+	misses    uint64
+}
+
+// New creates a new instance of Cache which stores the values in next.
+func New(next cacher.Cacher, opts Options) *Cache {
+	cache := new(Cache)
+	cache.Forwarder = cacher.NewForwarder(next)
+	cache.next = next
+	cache.opts = opts
+	cache.refreshing = make(map[string]bool)
+
+	return cache
+}
+
+// Add adds the item with a soft expiry after ttl.
+func (c *Cache) Add(key string, value []byte, ttl int64) error {
+	return c.next.Add(key, c.encode(value, ttl), c.hardTTL(ttl))
+}
+
+// CompareAndReplace replaces the item if token matches. The token is the one
+// returned by `Get()`.
+func (c *Cache) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	return c.next.CompareAndReplace(token, key, c.encode(value, ttl), c.hardTTL(ttl))
+}
+
+// Set sets the item with a soft expiry after ttl.
+func (c *Cache) Set(key string, value []byte, ttl int64) error {
+	return c.next.Set(key, c.encode(value, ttl), c.hardTTL(ttl))
+}
+
+// SetMulti sets the items with a soft expiry after ttl.
+func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	encoded := make(map[string][]byte)
+	for key, value := range items {
+		encoded[key] = c.encode(value, ttl)
+	}
+
+	return c.next.SetMulti(encoded, c.hardTTL(ttl))
+}
+
+// Replace replaces the item with a soft expiry after ttl.
+func (c *Cache) Replace(key string, value []byte, ttl int64) error {
+	return c.next.Replace(key, c.encode(value, ttl), c.hardTTL(ttl))
+}
+
+// Increment increments the counter in the other cache. Counters have no soft
+// expiry.
+func (c *Cache) Increment(key string, initial, offset, ttl int64) error {
+	return c.next.Increment(key, initial, offset, ttl)
+}
+
+// Decrement decrements the counter in the other cache. Counters have no soft
+// expiry.
+func (c *Cache) Decrement(key string, initial, offset, ttl int64) error {
+	return c.next.Decrement(key, initial, offset, ttl)
+}
+
+// Delete deletes the item from the cache.
+func (c *Cache) Delete(key string) error {
+	return c.next.Delete(key)
+}
+
+// DeleteMulti deletes the items from the cache.
+func (c *Cache) DeleteMulti(keys []string) map[string]error {
+	return c.next.DeleteMulti(keys)
+}
+
+// Get gets the value from the cache. A value past its soft expiry is returned
+// as it is, and refreshed in the background.
+func (c *Cache) Get(key string) ([]byte, string, error) {
+	data, token, err := c.next.Get(key)
+	if err != nil {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, "", err
+	}
+
+	return c.read(key, data, token), token, nil
+}
+
+// GetMulti gets the values from the cache, refreshing the stale ones in the
+// background.
+func (c *Cache) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	items, tokens, errs := c.next.GetMulti(keys)
+
+	for _, key := range keys {
+		data, ok := items[key]
+		if !ok || errs[key] != nil {
+			atomic.AddUint64(&c.misses, 1)
+			continue
+		}
+
+		items[key] = c.read(key, data, tokens[key])
+	}
+
+	return items, tokens, errs
+}
+
+// Flush removes all the items from the cache.
+func (c *Cache) Flush() error {
+	return c.next.Flush()
+}
+
+// Touch moves the soft expiry of the item to ttl from now. The header holding
+// it is rewritten with `CompareAndReplace()`; when the other cache doesn't
+// support that, only the hard expiry is moved. Items without a header, like
+// counters, are touched in the other cache as they are.
+func (c *Cache) Touch(key string, ttl int64) error {
+	data, token, err := c.next.Get(key)
+	if err != nil {
+		return err
+	}
+
+	if !hasHeader(data) {
+		return c.next.Touch(key, ttl)
+	}
+
+	value, _, _ := decode(data)
+	err = c.next.CompareAndReplace(token, key, c.encode(value, ttl), c.hardTTL(ttl))
+	if errors.Is(err, errors.ErrUnsupported) {
+		return c.next.Touch(key, c.hardTTL(ttl))
+	}
+
+	return err
+}
+
+// StaleStats returns the number of reads by what they found so far. `Stats()`
+// reports the statistics of the other cache.
+func (c *Cache) StaleStats() Stats {
+	return Stats{
+		FreshHits: atomic.LoadUint64(&c.freshHits),
+		StaleHits: atomic.LoadUint64(&c.staleHits),
+		Misses:    atomic.LoadUint64(&c.misses),
+	}
+}
+
+// Close closes the other cache.
+func (c *Cache) Close() error {
+	return cacher.Close(c.next)
+}
+
+// Ping checks whether the other cache can be reached.
+func (c *Cache) Ping(ctx context.Context) error {
+	return cacher.Ping(ctx, c.next)
+}
+
+// read returns the value stored as data with token, starting a refresh if it
+// is stale.
+func (c *Cache) read(key string, data []byte, token string) []byte {
+	value, soft, ttl := decode(data)
+	if soft.IsZero() || time.Now().Before(soft) {
+		atomic.AddUint64(&c.freshHits, 1)
+		return value
+	}
+
+	atomic.AddUint64(&c.staleHits, 1)
+	c.refresh(key, token, ttl)
+
+	return value
+}
+
+// refresh loads the value of key in the background, unless it is being
+// refreshed already. The loaded value only replaces the stale one read with
+// token, so a value written while it loads wins. If the load fails, the stale
+// value is left in place.
+func (c *Cache) refresh(key, token string, ttl int64) {
+	if c.opts.Load == nil {
+		return
+	}
+
+	c.mu.Lock()
+	if c.refreshing[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.refreshing[key] = true
+	c.mu.Unlock()
+
+	go func() {
+		defer func() {
+			c.mu.Lock()
+			delete(c.refreshing, key)
+			c.mu.Unlock()
+		}()
+
+		if value, err := c.opts.Load(key); err == nil {
+			c.CompareAndReplace(token, key, value, ttl)
+		}
+	}()
+}
+
+// encode prefixes value with a header holding its soft expiry. Values without
+// a ttl are only prefixed when they could be mistaken for a header.
+func (c *Cache) encode(value []byte, ttl int64) []byte {
+	if ttl <= 0 && !bytes.HasPrefix(value, magic) {
+		return value
+	}
+
+	var soft int64
+	if ttl > 0 {
+		soft = time.Now().Add(time.Duration(ttl) * time.Second).UnixNano()
+	}
+
+	data := make([]byte, headerSize+len(value))
+	copy(data, magic)
+	binary.BigEndian.PutUint64(data[len(magic):], uint64(soft))
+	binary.BigEndian.PutUint64(data[len(magic)+8:], uint64(ttl))
+	copy(data[headerSize:], value)
+
+	return data
+}
+
+// hardTTL is the ttl the other cache keeps a value written with ttl for.
+func (c *Cache) hardTTL(ttl int64) int64 {
+	if ttl <= 0 || c.opts.StaleTTL <= 0 {
+		return ttl
+	}
+
+	return ttl + expiry.In(c.opts.StaleTTL).Seconds()
+}
+
+// decode splits data into the value, its soft expiry and the ttl it was
+// written with. Values without a header have no soft expiry.
+func decode(data []byte) ([]byte, time.Time, int64) {
+	if !hasHeader(data) {
+		return data, time.Time{}, 0
+	}
+
+	var soft time.Time
+	if nsec := int64(binary.BigEndian.Uint64(data[len(magic):])); nsec != 0 {
+		soft = time.Unix(0, nsec)
+	}
+
+	return data[headerSize:], soft, int64(binary.BigEndian.Uint64(data[len(magic)+8:]))
+}
+
+// hasHeader reports whether data starts with a header.
+func hasHeader(data []byte) bool {
+	return len(data) >= headerSize && bytes.HasPrefix(data, magic)
+}
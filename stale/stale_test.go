@@ -0,0 +1,182 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package stale_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/memory"
+	rcache "github.com/jelmersnoeck/cacher/redis"
+	"github.com/jelmersnoeck/cacher/stale"
+)
+
+// sharedCache returns a cache that is safe for concurrent use, as background
+// refreshes need.
+func sharedCache() *rcache.Cache {
+	pool := &redis.Pool{Dial: func() (redis.Conn, error) {
+		return redis.Dial("tcp", ":6379")
+	}}
+	cache := rcache.NewPool(pool, rcache.Options{Prefix: "stale:"})
+	cache.Flush()
+
+	return cache
+}
+
+func TestStaleWhileRevalidate(t *testing.T) {
+	var loads int32
+	release := make(chan struct{})
+	cache := stale.New(sharedCache(), stale.Options{
+		StaleTTL: time.Minute,
+		Load: func(key string) ([]byte, error) {
+			n := atomic.AddInt32(&loads, 1)
+			<-release
+			return []byte(fmt.Sprintf("value%d", n+1)), nil
+		},
+	})
+
+	cache.Set("key1", []byte("value1"), 1)
+	if value, _, _ := cache.Get("key1"); string(value) != "value1" {
+		t.Errorf("Expecting the fresh value1, got `%s`.", value)
+		t.FailNow()
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if value, _, _ := cache.Get("key1"); string(value) != "value1" {
+				t.Errorf("Expecting the stale value1, got `%s`.", value)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&loads); n != 1 {
+		t.Errorf("Expecting 20 stale reads to refresh once, got %d refreshes.", n)
+	}
+	close(release)
+
+	if value := waitFor(cache, "key1", "value1"); value == "value1" {
+		t.Errorf("Expecting the value to be refreshed, got `%s`.", value)
+		t.FailNow()
+	}
+
+	if stats := cache.StaleStats(); stats.FreshHits < 2 || stats.StaleHits < 20 {
+		t.Errorf("Expecting fresh and stale hits to be counted, got %+v.", stats)
+	}
+}
+
+func TestStaleIfError(t *testing.T) {
+	var loads int32
+	cache := stale.New(sharedCache(), stale.Options{
+		StaleTTL: time.Minute,
+		Load: func(key string) ([]byte, error) {
+			atomic.AddInt32(&loads, 1)
+			return nil, fmt.Errorf("database is down")
+		},
+	})
+
+	cache.Set("key1", []byte("value1"), 1)
+	time.Sleep(1100 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if value, _, err := cache.Get("key1"); err != nil || string(value) != "value1" {
+			t.Errorf("Expecting the stale value1, got `%s` %v.", value, err)
+			t.FailNow()
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if atomic.LoadInt32(&loads) == 0 {
+		t.Errorf("Expecting a refresh to be tried.")
+	}
+}
+
+func TestRefreshLosesToSet(t *testing.T) {
+	loading := make(chan struct{})
+	release := make(chan struct{})
+	cache := stale.New(sharedCache(), stale.Options{
+		StaleTTL: time.Minute,
+		Load: func(key string) ([]byte, error) {
+			close(loading)
+			<-release
+			return []byte("loaded"), nil
+		},
+	})
+
+	cache.Set("key1", []byte("value1"), 1)
+	time.Sleep(1100 * time.Millisecond)
+
+	cache.Get("key1")
+	<-loading
+	cache.Set("key1", []byte("value2"), 60)
+	close(release)
+
+	if value := waitFor(cache, "key1", "value2"); value != "value2" {
+		t.Errorf("Expecting the refresh to keep value2, got `%s`.", value)
+	}
+}
+
+func TestHardExpiry(t *testing.T) {
+	cache := stale.New(memory.New(0), stale.Options{StaleTTL: time.Second})
+
+	cache.Set("key1", []byte("value1"), 1)
+	time.Sleep(2100 * time.Millisecond)
+
+	if _, _, err := cache.Get("key1"); !errors.Is(err, errors.ErrNotFound) {
+		t.Errorf("Expecting the value to expire after the stale ttl, got %v.", err)
+	}
+
+	_, _, errs := cache.GetMulti([]string{"key2"})
+	if !errors.Is(errs["key2"], errors.ErrNotFound) {
+		t.Errorf("Expecting `key2` to miss, got %v.", errs["key2"])
+	}
+
+	if stats := cache.StaleStats(); stats.Misses != 2 {
+		t.Errorf("Expecting 2 misses, got %+v.", stats)
+	}
+}
+
+func TestCounters(t *testing.T) {
+	cache := stale.New(memory.New(0), stale.Options{StaleTTL: time.Minute})
+
+	cache.Increment("counter", 5, 1, 60)
+	cache.Increment("counter", 5, 10, 60)
+
+	if value, _, _ := cache.Get("counter"); string(value) != "15" {
+		t.Errorf("Expecting the counter to be 15, got `%s`.", value)
+	}
+
+	if err := cache.Touch("counter", 60); err != nil {
+		t.Errorf("Expecting the counter to be touched, got %v.", err)
+	}
+
+	if err := cache.Increment("counter", 5, 1, 60); err != nil {
+		t.Errorf("Expecting the counter to increment after a touch, got %v.", err)
+	}
+}
+
+// waitFor reads key until it no longer has old, for at most a second, and
+// returns the last value read.
+func waitFor(cache *stale.Cache, key, old string) string {
+	var got []byte
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); {
+		if got, _, _ = cache.Get(key); string(got) != old {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return string(got)
+}
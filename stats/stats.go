@@ -0,0 +1,22 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package stats describes the statistics a cache reports about itself.
+package stats
+
+// Stats holds the statistics of a cache. Caches leave the numbers they don't
+// keep track of at 0.
+type Stats struct {
+	// Items is the number of items stored.
+	Items int64
+
+	// Bytes is the size of the stored values in bytes.
+	Bytes int64
+
+	// Hits is the number of reads that found the item.
+	Hits int64
+
+	// Misses is the number of reads that didn't find the item.
+	Misses int64
+}
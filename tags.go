@@ -0,0 +1,348 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package cacher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/internal/encoding"
+	"github.com/jelmersnoeck/cacher/option"
+)
+
+// Tagger is an OptionsCacher that can invalidate the items written with
+// `option.WithTags()` by tag:
+//
+//	cache.SetWith("page:/products/42", page, option.WithTags("product:42"))
+//	cache.InvalidateTags("product:42")
+type Tagger interface {
+	OptionsCacher
+
+	// InvalidateTags makes every item tagged with one of tags miss.
+	InvalidateTags(tags ...string) error
+}
+
+// WithTags returns c as a Tagger. Caches that store tags natively are
+// returned as they are. Other caches are wrapped so every tag has a version
+// counter, stored in c with `Increment()`. Tagged values are stored with the
+// versions of their tags, and a value one of whose tags has been invalidated
+// since is reported as not found when it is read. It is left in c until it
+// expires or is written again, as another client may have rewritten it in the
+// meantime. Tagged items should be read through the wrapper. The wrapper is a
+// FlagCacher, so tagged items can carry flags too.
+func WithTags(c Cacher) Tagger {
+	if t, ok := c.(Tagger); ok {
+		return t
+	}
+
+	return tagsAdapter{c, NewForwarder(c)}
+}
+
+// tagsMagic starts the header of a value written by tagsAdapter. It is
+// followed by the number of tags as a big endian uint16 and, for every tag,
+// its length as a big endian uint16, the tag and its version as a big endian
+// int64.
+var tagsMagic = []byte{0xca, 0x7a}
+
+var errBrokenTags = fmt.Errorf("cacher: value has a broken tags header")
+
+type tagsAdapter struct {
+	Cacher
+	Forwarder
+}
+
+func (a tagsAdapter) Add(key string, value []byte, ttl int64) error {
+	return a.AddWithFlags(key, value, 0, ttl)
+}
+
+func (a tagsAdapter) AddWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	return WithFlags(a.Cacher).AddWithFlags(key, encodeTags(value, nil), flags, ttl)
+}
+
+func (a tagsAdapter) Set(key string, value []byte, ttl int64) error {
+	return a.SetWithFlags(key, value, 0, ttl)
+}
+
+func (a tagsAdapter) SetWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	return WithFlags(a.Cacher).SetWithFlags(key, encodeTags(value, nil), flags, ttl)
+}
+
+func (a tagsAdapter) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	encoded := make(map[string][]byte)
+	for key, value := range items {
+		encoded[key] = encodeTags(value, nil)
+	}
+
+	return WithFlags(a.Cacher).SetMulti(encoded, ttl)
+}
+
+func (a tagsAdapter) Replace(key string, value []byte, ttl int64) error {
+	return a.ReplaceWithFlags(key, value, 0, ttl)
+}
+
+func (a tagsAdapter) ReplaceWithFlags(key string, value []byte, flags uint32, ttl int64) error {
+	return WithFlags(a.Cacher).ReplaceWithFlags(key, encodeTags(value, nil), flags, ttl)
+}
+
+func (a tagsAdapter) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	return a.CompareAndReplaceWithFlags(token, key, value, 0, ttl)
+}
+
+func (a tagsAdapter) CompareAndReplaceWithFlags(token, key string, value []byte, flags uint32, ttl int64) error {
+	return WithFlags(a.Cacher).CompareAndReplaceWithFlags(token, key, encodeTags(value, nil), flags, ttl)
+}
+
+func (a tagsAdapter) SetWith(key string, value []byte, opts ...option.Option) error {
+	o := option.Apply(opts...)
+	if err := o.Validate(); err != nil {
+		return err
+	}
+
+	var versions []tagVersion
+	if len(o.Tags) > 0 {
+		var err error
+		if versions, err = a.versions(o.Tags); err != nil {
+			return err
+		}
+	}
+
+	// The tags are stored in the value, not by the cache it is written to.
+	untagged := append(opts[:len(opts):len(opts)], func(o *option.Options) {
+		o.Tags = nil
+	})
+
+	return WithOptions(a.Cacher).SetWith(key, encodeTags(value, versions), untagged...)
+}
+
+func (a tagsAdapter) Get(key string) ([]byte, string, error) {
+	value, token, _, err := a.GetWithFlags(key)
+	return value, token, err
+}
+
+func (a tagsAdapter) GetWithFlags(key string) ([]byte, string, uint32, error) {
+	data, token, flags, err := WithFlags(a.Cacher).GetWithFlags(key)
+	if err != nil {
+		return nil, "", 0, err
+	}
+
+	value, versions, ok := decodeTags(data)
+	if !ok {
+		return nil, "", 0, errors.NewDecode(key, errBrokenTags)
+	}
+
+	if !valid(versions, a.current(versions)) {
+		return nil, "", 0, errors.NewNotFound(key)
+	}
+
+	return value, token, flags, nil
+}
+
+func (a tagsAdapter) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	items, tokens, _, errs := a.GetMultiWithFlags(keys)
+	return items, tokens, errs
+}
+
+func (a tagsAdapter) GetMultiWithFlags(keys []string) (map[string][]byte, map[string]string, map[string]uint32, map[string]error) {
+	items, tokens, flags, errs := WithFlags(a.Cacher).GetMultiWithFlags(keys)
+	if errs == nil {
+		errs = make(map[string]error)
+	}
+
+	// The versions of the tags of all the items are read at once.
+	versions := make(map[string][]tagVersion)
+	for key, data := range items {
+		if errs[key] != nil {
+			continue
+		}
+
+		value, v, ok := decodeTags(data)
+		if !ok {
+			errs[key] = errors.NewDecode(key, errBrokenTags)
+			delete(items, key)
+			delete(tokens, key)
+			delete(flags, key)
+			continue
+		}
+
+		items[key] = value
+		versions[key] = v
+	}
+
+	all := make([][]tagVersion, 0, len(versions))
+	for _, v := range versions {
+		all = append(all, v)
+	}
+	current := a.current(all...)
+
+	for key, v := range versions {
+		if !valid(v, current) {
+			errs[key] = errors.NewNotFound(key)
+			delete(items, key)
+			delete(tokens, key)
+			delete(flags, key)
+		}
+	}
+
+	return items, tokens, flags, errs
+}
+
+func (a tagsAdapter) InvalidateTags(tags ...string) error {
+	for _, tag := range tags {
+		if err := a.Cacher.Increment(tagVersionKey(tag), tagSeed(), 1, 0); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// versions returns the current versions of tags, starting the ones that don't
+// have a version yet.
+func (a tagsAdapter) versions(tags []string) ([]tagVersion, error) {
+	versions := make([]tagVersion, len(tags))
+	for i, tag := range tags {
+		value, _, err := a.Cacher.Get(tagVersionKey(tag))
+		if errors.Is(err, errors.ErrNotFound) {
+			err = a.Cacher.Add(tagVersionKey(tag), encoding.Int64Bytes(tagSeed()), 0)
+			if err != nil && !errors.Is(err, errors.ErrExists) {
+				return nil, err
+			}
+
+			value, _, err = a.Cacher.Get(tagVersionKey(tag))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		version, ok := encoding.BytesInt64(value)
+		if !ok {
+			return nil, errors.NewEncoding(tagVersionKey(tag))
+		}
+		versions[i] = tagVersion{tag: tag, version: version}
+	}
+
+	return versions, nil
+}
+
+// current reads the current versions of the tags in versions with a single
+// `GetMulti()`, by the key they are stored under. Tags without a version are
+// left out.
+func (a tagsAdapter) current(versions ...[]tagVersion) map[string]int64 {
+	var keys []string
+	seen := make(map[string]bool)
+	for _, vs := range versions {
+		for _, v := range vs {
+			if key := tagVersionKey(v.tag); !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+
+	current := make(map[string]int64)
+	if len(keys) == 0 {
+		return current
+	}
+
+	values, _, errs := a.Cacher.GetMulti(keys)
+	for _, key := range keys {
+		if errs[key] != nil {
+			continue
+		}
+
+		if version, ok := encoding.BytesInt64(values[key]); ok {
+			current[key] = version
+		}
+	}
+
+	return current
+}
+
+// valid reports whether none of the tags have been invalidated since the
+// versions were read, given the current versions.
+func valid(versions []tagVersion, current map[string]int64) bool {
+	for _, v := range versions {
+		if version, ok := current[tagVersionKey(v.tag)]; !ok || version != v.version {
+			return false
+		}
+	}
+
+	return true
+}
+
+type tagVersion struct {
+	tag     string
+	version int64
+}
+
+// tagVersionKey returns the key the version counter of tag is stored under.
+func tagVersionKey(tag string) string {
+	return "cacher:tag:" + tag
+}
+
+// tagSeed is the first version of a tag. It is based on the time, so a counter
+// that was evicted doesn't start over at a version that has been used before.
+func tagSeed() int64 {
+	return time.Now().UnixNano()
+}
+
+// encodeTags prefixes value with a header holding the versions of its tags.
+// Values without tags are only prefixed when they could be mistaken for a
+// header.
+func encodeTags(value []byte, versions []tagVersion) []byte {
+	if len(versions) == 0 && !bytes.HasPrefix(value, tagsMagic) {
+		return value
+	}
+
+	var buf bytes.Buffer
+	buf.Write(tagsMagic)
+	binary.Write(&buf, binary.BigEndian, uint16(len(versions)))
+	for _, v := range versions {
+		binary.Write(&buf, binary.BigEndian, uint16(len(v.tag)))
+		buf.WriteString(v.tag)
+		binary.Write(&buf, binary.BigEndian, v.version)
+	}
+	buf.Write(value)
+
+	return buf.Bytes()
+}
+
+// decodeTags splits data into the value and the versions of its tags. It
+// returns false if the header is cut short.
+func decodeTags(data []byte) ([]byte, []tagVersion, bool) {
+	if !bytes.HasPrefix(data, tagsMagic) {
+		return data, nil, true
+	}
+
+	r := bytes.NewReader(data[len(tagsMagic):])
+	var n uint16
+	if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+		return nil, nil, false
+	}
+
+	versions := make([]tagVersion, n)
+	for i := range versions {
+		var size uint16
+		if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+			return nil, nil, false
+		}
+
+		tag := make([]byte, size)
+		if _, err := io.ReadFull(r, tag); err != nil {
+			return nil, nil, false
+		}
+
+		versions[i].tag = string(tag)
+		if err := binary.Read(r, binary.BigEndian, &versions[i].version); err != nil {
+			return nil, nil, false
+		}
+	}
+
+	return data[len(data)-r.Len():], versions, true
+}
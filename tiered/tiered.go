@@ -0,0 +1,364 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+// Package tiered provides a two-tier cache which keeps a local in-memory copy
+// (L1) of the items stored in another, typically remote, cache (L2).
+//
+// Reads are served from L1 when possible and fall back to L2, copying the
+// value into L1 on the way back, to expire no later than in L2. Writes go to L2
+// first and are only copied into L1 when they succeed. Increment, Decrement and
+// CompareAndReplace need an up to date value and are always executed on L2,
+// invalidating the L1 copy. The optional interfaces are those of L2.
+package tiered
+
+import (
+	"context"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/expiry"
+	"github.com/jelmersnoeck/cacher/internal/encoding"
+	"github.com/jelmersnoeck/cacher/item"
+	"github.com/jelmersnoeck/cacher/memory"
+)
+
+// Stats holds the number of reads served by each tier.
+type Stats struct {
+	// L1Hits is the number of keys found in the local cache.
+	L1Hits uint64
+
+	// L2Hits is the number of keys not found in the local cache, but found in
+	// the remote cache.
+	L2Hits uint64
+
+	// Misses is the number of keys found in neither cache.
+	Misses uint64
+}
+
+// Cache is a caching implementation that layers a memory cache in front of
+// another cache.
+type Cache struct {
+	cacher.Forwarder
+
+	local    *memory.Cache
+	remote   cacher.Cacher
+	localTTL int64
+
+	l1Hits uint64
+	l2Hits uint64
+	misses uint64
+}
+
+// New creates a new instance of Cache. Items are kept in local for at most
+// localTTL seconds, so changes made to remote by other processes show up after
+// that time. If localTTL is 0, items written through this cache keep the ttl
+// they were written with and items read from remote are kept in local until
+// they're evicted.
+func New(local *memory.Cache, remote cacher.Cacher, localTTL int64) *Cache {
+	cache := new(Cache)
+	cache.Forwarder = cacher.NewForwarder(remote)
+	cache.local = local
+	cache.remote = remote
+	cache.localTTL = localTTL
+
+	return cache
+}
+
+// Add adds the item to the remote cache. If that succeeds, the item is added
+// to the local cache as well.
+func (c *Cache) Add(key string, value []byte, ttl int64) error {
+	if err := c.remote.Add(key, value, ttl); err != nil {
+		return err
+	}
+
+	c.setLocal(key, value, ttl)
+	return nil
+}
+
+// CompareAndReplace validates the token against the value in the remote cache
+// and replaces it there. The local copy is invalidated.
+//
+// Tokens handed out by this cache are the MD5 sum of the value, like the
+// memory cache does, regardless of the remote cache. The token is translated to
+// the token of the remote cache before it is replaced, so the remote cache's
+// own CAS guarantees still apply.
+func (c *Cache) CompareAndReplace(token, key string, value []byte, ttl int64) error {
+	c.local.Delete(key)
+
+	current, remoteToken, err := c.remote.Get(key)
+	if err != nil {
+		return err
+	}
+
+	if encoding.Md5Sum(current) != token {
+		return errors.NewCASConflict(key)
+	}
+
+	return c.remote.CompareAndReplace(remoteToken, key, value, ttl)
+}
+
+// Set sets the value in the remote cache and, if that succeeds, in the local
+// cache.
+func (c *Cache) Set(key string, value []byte, ttl int64) error {
+	if err := c.remote.Set(key, value, ttl); err != nil {
+		c.local.Delete(key)
+		return err
+	}
+
+	c.setLocal(key, value, ttl)
+	return nil
+}
+
+// SetMulti sets multiple values in the remote cache and copies the ones that
+// succeeded to the local cache.
+func (c *Cache) SetMulti(items map[string][]byte, ttl int64) map[string]error {
+	results := c.remote.SetMulti(items, ttl)
+	for key, value := range items {
+		if results[key] == nil {
+			c.setLocal(key, value, ttl)
+		} else {
+			c.local.Delete(key)
+		}
+	}
+
+	return results
+}
+
+// Replace replaces the value in the remote cache and, if that succeeds, in the
+// local cache.
+func (c *Cache) Replace(key string, value []byte, ttl int64) error {
+	if err := c.remote.Replace(key, value, ttl); err != nil {
+		c.local.Delete(key)
+		return err
+	}
+
+	c.setLocal(key, value, ttl)
+	return nil
+}
+
+// Increment increments the value in the remote cache and invalidates the local
+// copy, so counters stay correct across processes.
+func (c *Cache) Increment(key string, initial, offset, ttl int64) error {
+	c.local.Delete(key)
+
+	return c.remote.Increment(key, initial, offset, ttl)
+}
+
+// Decrement decrements the value in the remote cache and invalidates the local
+// copy, so counters stay correct across processes.
+func (c *Cache) Decrement(key string, initial, offset, ttl int64) error {
+	c.local.Delete(key)
+
+	return c.remote.Decrement(key, initial, offset, ttl)
+}
+
+// Delete removes the item from both caches. The result of the remote cache is
+// returned.
+func (c *Cache) Delete(key string) error {
+	c.local.Delete(key)
+
+	return c.remote.Delete(key)
+}
+
+// DeleteMulti removes the items from both caches. The results of the remote
+// cache are returned.
+func (c *Cache) DeleteMulti(keys []string) map[string]error {
+	c.local.DeleteMulti(keys)
+
+	return c.remote.DeleteMulti(keys)
+}
+
+// Get gets the value from the local cache. If it isn't there, it is read from
+// the remote cache and stored in the local cache for subsequent reads.
+func (c *Cache) Get(key string) ([]byte, string, error) {
+	if value, token, err := c.local.Get(key); err == nil {
+		atomic.AddUint64(&c.l1Hits, 1)
+		return value, token, nil
+	}
+
+	value, exp, err := c.getRemote(key)
+	if err != nil {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, "", err
+	}
+
+	atomic.AddUint64(&c.l2Hits, 1)
+	c.backfill(key, value, exp)
+
+	return value, encoding.Md5Sum(value), nil
+}
+
+// GetMulti gets the values that are present in the local cache from there, and
+// the remaining values from the remote cache in a single call. When the remote
+// cache is a `cacher.ItemGetter`, the remaining TTL of every value read from it
+// is looked up separately to expire the local copy in time.
+func (c *Cache) GetMulti(keys []string) (map[string][]byte, map[string]string, map[string]error) {
+	items, tokens, errs := c.local.GetMulti(keys)
+
+	var missing []string
+	for _, key := range keys {
+		if errs[key] == nil {
+			atomic.AddUint64(&c.l1Hits, 1)
+			continue
+		}
+		missing = append(missing, key)
+	}
+
+	if len(missing) == 0 {
+		return items, tokens, errs
+	}
+
+	remoteItems, _, remoteErrs := c.remote.GetMulti(missing)
+	for _, key := range missing {
+		if err := remoteErrs[key]; err != nil {
+			atomic.AddUint64(&c.misses, 1)
+			delete(items, key)
+			delete(tokens, key)
+			errs[key] = err
+			continue
+		}
+
+		atomic.AddUint64(&c.l2Hits, 1)
+		value := remoteItems[key]
+		c.backfill(key, value, c.remoteExpiry(key))
+		items[key], tokens[key], errs[key] = value, encoding.Md5Sum(value), nil
+	}
+
+	return items, tokens, errs
+}
+
+// Flush removes all the items from both caches.
+func (c *Cache) Flush() error {
+	c.local.Flush()
+
+	return c.remote.Flush()
+}
+
+// Touch updates the ttl of the item in the remote cache and of the local copy,
+// if there is one.
+func (c *Cache) Touch(key string, ttl int64) error {
+	if err := c.remote.Touch(key, ttl); err != nil {
+		c.local.Delete(key)
+		return err
+	}
+
+	if ttl < 0 {
+		c.local.Delete(key)
+	} else {
+		c.local.Touch(key, c.capTTL(ttl))
+	}
+
+	return nil
+}
+
+// TierStats returns the number of reads served by each tier so far. `Stats()`
+// reports the statistics of the remote cache.
+func (c *Cache) TierStats() Stats {
+	return Stats{
+		L1Hits: atomic.LoadUint64(&c.l1Hits),
+		L2Hits: atomic.LoadUint64(&c.l2Hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
+}
+
+// Restore restores the remote cache from a snapshot and empties the local one,
+// so no copies of the replaced items are served.
+func (c *Cache) Restore(r io.Reader) error {
+	defer c.local.Flush()
+
+	return c.Forwarder.Restore(r)
+}
+
+// Capabilities returns the capabilities of the remote cache. The cache can
+// always be closed, to empty the local one.
+func (c *Cache) Capabilities() cacher.Capability {
+	return c.Forwarder.Capabilities() | cacher.CanClose
+}
+
+// Close closes the remote cache and empties the local one.
+func (c *Cache) Close() error {
+	err := cacher.Close(c.remote)
+	if lerr := c.local.Close(); err == nil {
+		err = lerr
+	}
+
+	return err
+}
+
+// Ping checks whether the remote cache can be reached.
+func (c *Cache) Ping(ctx context.Context) error {
+	return cacher.Ping(ctx, c.remote)
+}
+
+// setLocal copies the value into the local cache, capping its ttl.
+func (c *Cache) setLocal(key string, value []byte, ttl int64) {
+	if ttl < 0 {
+		c.local.Delete(key)
+		return
+	}
+
+	c.local.Set(key, value, c.capTTL(ttl))
+}
+
+// getRemote reads key from the remote cache, together with its expiry when the
+// remote cache can tell.
+func (c *Cache) getRemote(key string) ([]byte, expiry.Expiry, error) {
+	ig, ok := c.remote.(cacher.ItemGetter)
+	if !ok {
+		value, _, err := c.remote.Get(key)
+		return value, expiry.Never, err
+	}
+
+	it, err := ig.GetItem(key)
+	if err != nil {
+		return nil, expiry.Never, err
+	}
+
+	return it.Value, expiry.At(it.Expiry), nil
+}
+
+// remoteExpiry returns when key expires in the remote cache, or Never if it
+// doesn't or the remote cache can't tell.
+func (c *Cache) remoteExpiry(key string) expiry.Expiry {
+	ig, ok := c.remote.(cacher.ItemGetter)
+	if !ok {
+		return expiry.Never
+	}
+
+	ttl, err := ig.TTL(key)
+	if err != nil || ttl == item.NoExpiry {
+		return expiry.Never
+	}
+
+	return expiry.In(ttl)
+}
+
+// backfill copies a value read from the remote cache into the local cache, so
+// it expires with the remote item at the latest.
+func (c *Cache) backfill(key string, value []byte, exp expiry.Expiry) {
+	if c.localTTL > 0 {
+		local := expiry.In(time.Duration(c.localTTL) * time.Second)
+		if exp.IsNever() || local.Time().Before(exp.Time()) {
+			exp = local
+		}
+	}
+
+	if exp.Expired() {
+		return
+	}
+
+	c.local.SetUntil(key, value, exp)
+}
+
+// capTTL limits ttl to the configured local ttl, 0 meaning no expiry.
+func (c *Cache) capTTL(ttl int64) int64 {
+	if c.localTTL > 0 && (ttl == 0 || ttl > c.localTTL) {
+		return c.localTTL
+	}
+
+	return ttl
+}
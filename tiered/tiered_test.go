@@ -0,0 +1,127 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package tiered_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jelmersnoeck/cacher/memory"
+	"github.com/jelmersnoeck/cacher/tiered"
+)
+
+func TestBackfill(t *testing.T) {
+	remote := memory.New(0)
+	cache := tiered.New(memory.New(0), remote, 0)
+
+	remote.Set("key1", []byte("value1"), 0)
+
+	cache.Get("key1")
+	cache.Get("key1")
+	cache.Get("key2")
+
+	expected := tiered.Stats{L1Hits: 1, L2Hits: 1, Misses: 1}
+	if stats := cache.TierStats(); stats != expected {
+		t.Errorf("Expected stats %+v, got %+v.", expected, stats)
+		t.FailNow()
+	}
+}
+
+func TestLocalTTL(t *testing.T) {
+	cache := tiered.New(memory.New(0), memory.New(0), 1)
+
+	cache.Set("key1", []byte("value1"), 0)
+	cache.Get("key1")
+	time.Sleep(1100 * time.Millisecond)
+
+	if v, _, err := cache.Get("key1"); err != nil || string(v) != "value1" {
+		t.Errorf("Expected `key1` to be read from the remote cache.")
+		t.FailNow()
+	}
+
+	expected := tiered.Stats{L1Hits: 1, L2Hits: 1}
+	if stats := cache.TierStats(); stats != expected {
+		t.Errorf("Expected stats %+v, got %+v.", expected, stats)
+		t.FailNow()
+	}
+}
+
+func TestBackfillRemoteTTL(t *testing.T) {
+	remote := memory.New(0)
+	cache := tiered.New(memory.New(0), remote, 60)
+
+	remote.Set("key1", []byte("value1"), 1)
+	remote.Set("key2", []byte("value2"), 1)
+	cache.Get("key1")
+	cache.GetMulti([]string{"key2"})
+	time.Sleep(1100 * time.Millisecond)
+
+	for _, key := range []string{"key1", "key2"} {
+		if _, _, err := cache.Get(key); err == nil {
+			t.Errorf("Expected the local copy of `%s` to expire with the remote item.", key)
+		}
+	}
+}
+
+func TestIncrementDelegated(t *testing.T) {
+	remote := memory.New(0)
+	first := tiered.New(memory.New(0), remote, 0)
+	second := tiered.New(memory.New(0), remote, 0)
+
+	first.Increment("counter", 1, 1, 0)
+	first.Get("counter")
+	second.Increment("counter", 1, 1, 0)
+	first.Increment("counter", 1, 1, 0)
+
+	if v, _, _ := first.Get("counter"); string(v) != "3" {
+		t.Errorf("Expected `counter` to equal 3, got `%s`.", v)
+		t.FailNow()
+	}
+}
+
+func TestCompareAndReplaceRemoteChange(t *testing.T) {
+	remote := memory.New(0)
+	cache := tiered.New(memory.New(0), remote, 0)
+
+	cache.Set("key1", []byte("value1"), 0)
+	_, token, _ := cache.Get("key1")
+
+	// Another process changes the value, the local copy is now stale.
+	remote.Set("key1", []byte("value2"), 0)
+
+	if err := cache.CompareAndReplace(token, "key1", []byte("value3"), 0); err == nil {
+		t.Errorf("Expected CompareAndReplace to fail on a stale token.")
+		t.FailNow()
+	}
+
+	if v, _, _ := cache.Get("key1"); string(v) != "value2" {
+		t.Errorf("Expected `key1` to equal `value2`, got `%s`.", v)
+		t.FailNow()
+	}
+}
+
+func TestRestore(t *testing.T) {
+	remote := memory.New(0)
+	cache := tiered.New(memory.New(0), remote, 0)
+
+	cache.Set("key1", []byte("value1"), 0)
+
+	var snapshot bytes.Buffer
+	if err := cache.Snapshot(&snapshot); err != nil {
+		t.Errorf("Expected the snapshot of the remote cache, got %v.", err)
+		t.FailNow()
+	}
+
+	cache.Set("key1", []byte("value2"), 0)
+	if err := cache.Restore(&snapshot); err != nil {
+		t.Errorf("Expected the remote cache to be restored, got %v.", err)
+		t.FailNow()
+	}
+
+	if v, _, _ := cache.Get("key1"); string(v) != "value1" {
+		t.Errorf("Expected `key1` to equal `value1` after the restore, got `%s`.", v)
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package cacher
+
+import (
+	"github.com/jelmersnoeck/cacher/codec"
+	"github.com/jelmersnoeck/cacher/errors"
+)
+
+// Typed stores values of type T in a Cacher, encoded with a codec:
+//
+//	users := cacher.NewTyped[User](cache, codec.JSON)
+//	users.Set("user:1", User{Name: "Jane"}, 0)
+//	user, _, err := users.Get("user:1")
+//
+// Values that can't be decoded to T return an `errors.Decode`. Use `Cache()`
+// for the operations that don't depend on the type, like Delete and Touch.
+type Typed[T any] struct {
+	cache Cacher
+	codec codec.Codec
+}
+
+// NewTyped creates a Typed that stores its values in c, encoded with cd.
+func NewTyped[T any](c Cacher, cd codec.Codec) *Typed[T] {
+	return &Typed[T]{cache: c, codec: cd}
+}
+
+// Cache returns the cache the values are stored in.
+func (t *Typed[T]) Cache() Cacher {
+	return t.cache
+}
+
+// Add encodes value and adds it with `Cacher.Add()`.
+func (t *Typed[T]) Add(key string, value T, ttl int64) error {
+	data, err := t.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return t.cache.Add(key, data, ttl)
+}
+
+// Set encodes value and sets it with `Cacher.Set()`.
+func (t *Typed[T]) Set(key string, value T, ttl int64) error {
+	data, err := t.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return t.cache.Set(key, data, ttl)
+}
+
+// SetMulti encodes the values and sets them with `Cacher.SetMulti()`. Values
+// that can't be encoded aren't set and have the error of the codec.
+func (t *Typed[T]) SetMulti(items map[string]T, ttl int64) map[string]error {
+	results := make(map[string]error)
+	encoded := make(map[string][]byte)
+	for key, value := range items {
+		data, err := t.codec.Marshal(value)
+		if err != nil {
+			results[key] = err
+			continue
+		}
+		encoded[key] = data
+	}
+
+	for key, err := range t.cache.SetMulti(encoded, ttl) {
+		results[key] = err
+	}
+
+	return results
+}
+
+// Replace encodes value and replaces it with `Cacher.Replace()`.
+func (t *Typed[T]) Replace(key string, value T, ttl int64) error {
+	data, err := t.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return t.cache.Replace(key, data, ttl)
+}
+
+// CompareAndReplace encodes value and replaces it with
+// `Cacher.CompareAndReplace()`.
+func (t *Typed[T]) CompareAndReplace(token, key string, value T, ttl int64) error {
+	data, err := t.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+
+	return t.cache.CompareAndReplace(token, key, data, ttl)
+}
+
+// Get gets the value stored under key and decodes it. The token is the one of
+// the encoded value.
+func (t *Typed[T]) Get(key string) (T, string, error) {
+	var value T
+	data, token, err := t.cache.Get(key)
+	if err != nil {
+		return value, "", err
+	}
+
+	if err := t.codec.Unmarshal(data, &value); err != nil {
+		return value, "", errors.NewDecode(key, err)
+	}
+
+	return value, token, nil
+}
+
+// GetMulti gets the values stored under keys and decodes them. Values that
+// can't be decoded are left out and have an `errors.Decode`.
+func (t *Typed[T]) GetMulti(keys []string) (map[string]T, map[string]string, map[string]error) {
+	items, tokens, errs := t.cache.GetMulti(keys)
+	if errs == nil {
+		errs = make(map[string]error)
+	}
+
+	values := make(map[string]T)
+	for key, data := range items {
+		if errs[key] != nil {
+			continue
+		}
+
+		var value T
+		if err := t.codec.Unmarshal(data, &value); err != nil {
+			errs[key] = errors.NewDecode(key, err)
+			delete(tokens, key)
+			continue
+		}
+		values[key] = value
+	}
+
+	return values, tokens, errs
+}
+
+// Delete deletes the value stored under key with `Cacher.Delete()`.
+func (t *Typed[T]) Delete(key string) error {
+	return t.cache.Delete(key)
+}
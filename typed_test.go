@@ -0,0 +1,80 @@
+// Copyright 2010 The Go Authors. All rights reserved.
+// Use of this source code is governed by a MIT-style license that can be found
+// in the LICENSE file.
+
+package cacher_test
+
+import (
+	"testing"
+
+	"github.com/jelmersnoeck/cacher"
+	"github.com/jelmersnoeck/cacher/codec"
+	"github.com/jelmersnoeck/cacher/errors"
+	"github.com/jelmersnoeck/cacher/internal/tests"
+	"github.com/jelmersnoeck/cacher/memory"
+)
+
+type user struct {
+	Name  string
+	Admin bool
+}
+
+func TestTyped(t *testing.T) {
+	for _, cache := range testDrivers() {
+		testTyped(t, cache, codec.JSON)
+	}
+
+	// Gob values carry their type, which makes them too big for the memory
+	// tier of the hybrid test cache.
+	testTyped(t, memory.New(0), codec.Gob)
+}
+
+func testTyped(t *testing.T, cache cacher.Cacher, cd codec.Codec) {
+	users := cacher.NewTyped[user](cache, cd)
+
+	if err := users.Set("user:1", user{Name: "Jane", Admin: true}, 0); err != nil {
+		tests.FailMsg(t, cache, "Expecting `user:1` to be set, got %s.", err)
+	}
+
+	u, token, err := users.Get("user:1")
+	if err != nil || u.Name != "Jane" || !u.Admin {
+		tests.FailMsg(t, cache, "Expecting to get Jane, got %v %s.", u, err)
+	}
+
+	if err := users.CompareAndReplace(token, "user:1", user{Name: "John"}, 0); err != nil {
+		tests.FailMsg(t, cache, "Expecting `user:1` to be replaced, got %s.", err)
+	}
+
+	users.Set("user:2", user{Name: "Joe"}, 0)
+	values, _, errs := users.GetMulti([]string{"user:1", "user:2", "user:3"})
+	if values["user:1"].Name != "John" || values["user:2"].Name != "Joe" {
+		tests.FailMsg(t, cache, "Expecting John and Joe, got %v.", values)
+	}
+
+	if !errors.Is(errs["user:3"], errors.ErrNotFound) {
+		tests.FailMsg(t, cache, "Expecting `user:3` not to be found, got %v.", errs["user:3"])
+	}
+}
+
+func TestTypedDecodeError(t *testing.T) {
+	for _, cache := range testDrivers() {
+		cache.Set("user:1", []byte("not a user"), 0)
+		users := cacher.NewTyped[user](cache, codec.JSON)
+
+		_, _, err := users.Get("user:1")
+		var de errors.Decode
+		if !errors.Is(err, errors.ErrDecode) || !errors.As(err, &de) || de.Key != "user:1" {
+			tests.FailMsg(t, cache, "Expecting a decode error for `user:1`, got %v.", err)
+		}
+
+		_, _, errs := users.GetMulti([]string{"user:1"})
+		if !errors.Is(errs["user:1"], errors.ErrDecode) {
+			tests.FailMsg(t, cache, "Expecting a decode error from GetMulti, got %v.", errs["user:1"])
+		}
+
+		raw := cacher.NewTyped[string](cache, codec.Raw)
+		if s, _, err := raw.Get("user:1"); err != nil || s != "not a user" {
+			tests.FailMsg(t, cache, "Expecting the raw value, got %q %v.", s, err)
+		}
+	}
+}